@@ -2,79 +2,220 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"log/slog"
+	nethttp "net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 
 	"github.com/tfenng/scaffold/internal/api/http"
+	"github.com/tfenng/scaffold/internal/auth"
 	"github.com/tfenng/scaffold/internal/cache"
+	"github.com/tfenng/scaffold/internal/config"
+	"github.com/tfenng/scaffold/internal/cursor"
 	"github.com/tfenng/scaffold/internal/db"
+	"github.com/tfenng/scaffold/internal/health"
+	"github.com/tfenng/scaffold/internal/idempotency"
+	"github.com/tfenng/scaffold/internal/lifecycle"
+	"github.com/tfenng/scaffold/internal/logging"
+	"github.com/tfenng/scaffold/internal/metrics"
 	"github.com/tfenng/scaffold/internal/repo"
 	"github.com/tfenng/scaffold/internal/service"
+	"github.com/tfenng/scaffold/internal/tracing"
 )
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	if err := godotenv.Load(); err != nil {
-		log.Println("warning: .env file not found, using environment variables")
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	host := getEnv("POSTGRES_HOST", "localhost")
-	dbName := getEnv("POSTGRES_DB", "app")
-	user := getEnv("POSTGRES_USER", "xmap")
-	password := getEnv("POSTGRES_PASSWORD", "xmap")
-	dsn := fmt.Sprintf("postgres://%s:%s@%s:5432/%s?sslmode=disable", user, password, host, dbName)
-
-	pool, err := db.NewPostgres(ctx, dsn)
+	logger, err := logging.New(os.Stdout, cfg.Log.Level, cfg.Log.Format)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer pool.Close()
+	slog.SetDefault(logger)
 
-	redisAddr := getEnv("REDIS_ADDR", "127.0.0.1:6379")
-	rdb := cache.NewRedis(redisAddr)
-	defer func() { _ = rdb.Close() }()
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		fatal("setup tracing", err)
+	}
 
-	var userCache *cache.UserCache
+	pool, err := db.NewPostgres(ctx, cfg.Postgres)
+	if err != nil {
+		fatal("connect postgres", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	m := metrics.New(reg)
+	m.RegisterPool(pool)
+
+	rdb := cache.NewRedis(cfg.Redis)
+	rdb.AddHook(m.RedisHook())
+	rdb.AddHook(tracing.RedisHook())
+
+	lc := lifecycle.New(cfg.HTTP.ShutdownTimeout)
+	lc.OnStop("tracing", shutdownTracing)
+	lc.OnStop("redis", func(context.Context) error { return rdb.Close() })
+	lc.OnStop("postgres", func(context.Context) error { pool.Close(); return nil })
+
+	userCache := cache.NewSupervisor(
+		cache.NewUserCache(rdb, cfg.Cache.UserTTL), cfg.Cache.BreakerThreshold, cfg.Cache.ProbeInterval,
+	)
 	if err := cache.Ping(ctx, rdb); err != nil {
-		log.Println("redis unavailable, continue without cache:", err)
-		log.Println("cache_mode=no-cache")
+		userCache.Trip(err)
 	} else {
-		userCache = cache.NewUserCache(rdb)
-		log.Println("cache_mode=redis")
+		slog.Info("redis connected", "cache_mode", "redis")
 	}
+	lc.Go(userCache.Run)
 
 	txMgr := repo.PgxTxManager{Pool: pool}
 	userRepo := repo.NewUserRepo(pool)
 	userQueryRepo := repo.NewUserQueryRepo(pool)
 
+	cursors := cursor.New([]byte(cfg.Users.CursorSecret))
+	if cfg.Users.CursorSecret == "" {
+		slog.Warn("USERS_CURSOR_SECRET not set, list cursors will not survive a restart or work across instances")
+		cursors = cursor.NewRandom()
+	}
+
+	auditRepo := repo.NewAuditRepo(pool)
 	userSvc := &service.UserService{
-		Tx: txMgr, Users: userRepo, Query: userQueryRepo, UCache: userCache,
+		Tx: txMgr, Users: userRepo, Query: userQueryRepo, UCache: m.InstrumentUserCache(userCache),
+		Audit: auditRepo, Cursors: cursors, SearchSimilarity: cfg.Users.SearchSimilarity,
+		ImportMaxRows: cfg.Users.ImportMaxRows,
+	}
+	if cfg.Users.PurgeRetention > 0 {
+		lc.Go(func(ctx context.Context) {
+			userSvc.RunPurge(ctx, cfg.Users.PurgeRetention, cfg.Users.PurgeInterval)
+		})
 	}
 
 	r := gin.New()
 	r.Use(gin.Recovery())
-	r.Use(cors.Default())
-	r.Use(http.ErrorMiddleware())
+	corsCfg := cors.DefaultConfig()
+	corsCfg.AllowAllOrigins = true
+	corsCfg.AddAllowHeaders("Authorization", "If-Match", http.IdempotencyKeyHeader)
+	corsCfg.AddExposeHeaders("ETag", http.IdempotentReplayedHeader)
+	r.Use(cors.New(corsCfg))
+	r.Use(otelgin.Middleware(cfg.Tracing.ServiceName, otelgin.WithFilter(func(req *nethttp.Request) bool {
+		switch req.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			return false
+		}
+		return true
+	})))
+	r.Use(http.RequestIDMiddleware())
+	r.Use(http.AccessLogMiddleware(logger))
+	r.Use(http.MetricsMiddleware(m))
+	r.Use(http.ErrorMiddleware(http.ErrorFormat(cfg.HTTP.ErrorFormat)))
 
-	h := &http.UserHandler{Svc: userSvc}
-	r.GET("/users/:id", h.Get)
-	r.POST("/users", h.Create)
-	r.GET("/users", h.List)
-	r.PUT("/users/:id", h.Update)
-	r.DELETE("/users/:id", h.Delete)
+	hh := &http.HealthHandler{
+		Checks:  []health.Checker{health.Postgres(pool), health.Migrations(pool), health.Redis(rdb, userCache)},
+		Timeout: cfg.Health.Timeout,
+	}
+	public := r.Group("")
+	public.GET("/healthz", hh.Live)
+	public.GET("/readyz", hh.Ready)
+	public.GET("/metrics", gin.WrapH(m.Handler()))
+
+	apiKeySvc := &service.APIKeyService{
+		Keys:          repo.NewAPIKeyRepo(pool),
+		Cache:         userCache.APIKeys(cache.NewAPIKeyCache(rdb, cfg.Cache.APIKeyTTL)),
+		TouchInterval: time.Minute,
+	}
+
+	private := r.Group("")
+	if cfg.Auth.Enabled {
+		verifier, err := auth.NewVerifier(cfg.Auth)
+		if err != nil {
+			fatal("setup auth", err)
+		}
+		private.Use(http.AuthMiddleware(verifier, apiKeySvc))
+	} else {
+		slog.Warn("authentication disabled, private routes run as the system principal")
+		private.Use(http.AssumePrincipal(auth.System()))
+	}
+
+	idemRepo := repo.NewIdempotencyRepo(pool)
+	lc.Go(func(ctx context.Context) { idempotency.Sweep(ctx, idemRepo, time.Hour) })
+	// Only routes that create something opt in; buffering every POST body
+	// would also hold secrets and streamed uploads.
+	idem := http.IdempotencyMiddleware(&idempotency.Store{
+		Primary:  cache.NewIdempotencyCache(rdb),
+		Fallback: idemRepo,
+		Lock:     cfg.Idempotency.LockTTL,
+		TTL:      cfg.Idempotency.TTL,
+	}, int64(cfg.Idempotency.MaxBodyBytes))
+
+	if cfg.Auth.HS256Secret != "" {
+		ah := &http.AuthHandler{Svc: &service.AuthService{
+			Tx:                txMgr,
+			Users:             userRepo,
+			Credentials:       repo.NewCredentialRepo(pool),
+			Hasher:            auth.NewHasher(cfg.Auth.Password),
+			Issuer:            auth.NewIssuer(cfg.Auth),
+			Throttle:          userCache.LoginThrottle(cache.NewLoginThrottle(rdb, cfg.Auth.LoginMaxAttempts, cfg.Auth.LoginLockout)),
+			Audit:             auditRepo,
+			UCache:            userSvc.UCache,
+			MinPasswordLength: cfg.Auth.Password.MinLength,
+			RefreshTTL:        cfg.Auth.RefreshTokenTTL,
+		}}
+		if cfg.Auth.RegistrationEnabled {
+			public.POST("/auth/register", ah.Register)
+		}
+		public.POST("/auth/login", ah.Login)
+		public.POST("/auth/refresh", ah.Refresh)
+		public.POST("/auth/logout", ah.Logout)
+		private.POST("/auth/password", ah.ChangePassword)
+	} else {
+		slog.Warn("AUTH_HS256_SECRET not set, local accounts are disabled")
+	}
+
+	kh := &http.APIKeyHandler{Svc: apiKeySvc}
+	private.POST("/api-keys", http.RequirePermission(auth.PermAPIKeysManage), kh.Create)
+	private.GET("/api-keys", http.RequirePermission(auth.PermAPIKeysManage), kh.List)
+	private.DELETE("/api-keys/:id", http.RequirePermission(auth.PermAPIKeysManage), kh.Revoke)
+
+	h := &http.UserHandler{Svc: userSvc, RequireIfMatch: cfg.Users.RequireIfMatch}
+	private.GET("/users/:id", http.RequirePermission(auth.PermUsersRead), h.Get)
+	private.POST("/users", http.RequirePermission(auth.PermUsersWrite), idem, h.Create)
+	private.GET("/users", http.RequirePermission(auth.PermUsersRead), h.List)
+	private.GET("/users/search", http.RequirePermission(auth.PermUsersRead), h.Search)
+	private.POST("/users:method", http.CustomMethod("import"), http.RequirePermission(auth.PermUsersWrite), h.Import)
+	private.GET("/users:method", http.CustomMethod("export"), http.RequirePermission(auth.PermUsersRead), h.Export)
+	private.PUT("/users/:id", http.RequirePermission(auth.PermUsersWrite), h.Update)
+	private.PATCH("/users/:id", http.RequirePermission(auth.PermUsersWrite), h.Patch)
+	private.DELETE("/users/:id", http.RequirePermission(auth.PermUsersDelete), h.Delete)
+	private.GET("/users/:id/audit", http.RequirePermission(auth.PermUsersRead), h.Audit)
+	private.POST("/users/:id/restore", http.RequirePermission(auth.PermUsersDelete), idem, h.Restore)
+
+	srv := &nethttp.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           r,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+	if err := lc.Run(ctx, srv); err != nil {
+		fatal("server stopped with error", err)
+	}
+}
 
-	log.Fatal(r.Run(":8080"))
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
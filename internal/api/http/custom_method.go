@@ -0,0 +1,20 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+// CustomMethod guards a "/collection:method" route, e.g.
+// POST /users:method, letting through only the request for method name.
+// Gin has no literal ':' in paths, so the route captures everything after
+// the collection ("/usersx" too) and this sorts it out.
+func CustomMethod(name string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("method") != ":"+name {
+			abortWith(c, domain.NotFound("route not found"))
+			return
+		}
+		c.Next()
+	}
+}
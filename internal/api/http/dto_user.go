@@ -18,6 +18,8 @@ type userResponse struct {
 	Birth     *string `json:"birth"`
 	CreatedAt string  `json:"created_at"`
 	UpdatedAt string  `json:"updated_at"`
+	DeletedAt *string `json:"deleted_at"`
+	Version   int64   `json:"version"`
 }
 
 func toUserResponse(u sqlc.User) userResponse {
@@ -31,6 +33,8 @@ func toUserResponse(u sqlc.User) userResponse {
 		Birth:     datePtr(u.Birth),
 		CreatedAt: timestampString(u.CreatedAt),
 		UpdatedAt: timestampString(u.UpdatedAt),
+		DeletedAt: timestampPtr(u.DeletedAt),
+		Version:   u.Version,
 	}
 }
 
@@ -49,6 +53,21 @@ func toUserPageResponse(p repo.Page[sqlc.User]) repo.Page[userResponse] {
 	}
 }
 
+func toUserCursorPageResponse(p repo.CursorPage[sqlc.User]) repo.CursorPage[userResponse] {
+	items := make([]userResponse, len(p.Items))
+	for i, item := range p.Items {
+		items[i] = toUserResponse(item)
+	}
+
+	return repo.CursorPage[userResponse]{
+		Items:      items,
+		PageSize:   p.PageSize,
+		NextCursor: p.NextCursor,
+		PrevCursor: p.PrevCursor,
+		Total:      p.Total,
+	}
+}
+
 func textPtr(v pgtype.Text) *string {
 	if !v.Valid {
 		return nil
@@ -65,6 +84,14 @@ func datePtr(v pgtype.Date) *string {
 	return &s
 }
 
+func timestampPtr(v pgtype.Timestamptz) *string {
+	if !v.Valid {
+		return nil
+	}
+	s := timestampString(v)
+	return &s
+}
+
 func timestampString(v pgtype.Timestamptz) string {
 	if !v.Valid {
 		return ""
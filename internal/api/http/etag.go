@@ -0,0 +1,43 @@
+package http
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+// userETag is a strong ETag over the user's version, e.g. "3".
+func userETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseIfMatch reads If-Match as the version the caller expects. It returns
+// nil for a missing header (unless required) and for "*", which matches any
+// existing user. Only a single strong ETag is supported; a weak or foreign
+// ETag can never match and fails with FAILED_PRECONDITION.
+func parseIfMatch(c *gin.Context, required bool) (*int64, error) {
+	v := strings.TrimSpace(c.GetHeader("If-Match"))
+	switch {
+	case v == "":
+		if required {
+			return nil, domain.PreconditionRequired("If-Match header is required")
+		}
+		return nil, nil
+	case v == "*":
+		return nil, nil
+	case strings.Contains(v, ","):
+		return nil, domain.Invalid("If-Match must be a single ETag or *")
+	}
+
+	tag, ok := strings.CutPrefix(v, `"`)
+	if !ok || !strings.HasSuffix(tag, `"`) {
+		return nil, domain.FailedPrecondition("If-Match does not match the current version")
+	}
+	version, err := strconv.ParseInt(strings.TrimSuffix(tag, `"`), 10, 64)
+	if err != nil {
+		return nil, domain.FailedPrecondition("If-Match does not match the current version")
+	}
+	return &version, nil
+}
@@ -0,0 +1,60 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		header     string
+		required   bool
+		want       int64
+		wantNil    bool
+		wantStatus int
+	}{
+		{name: "absent", wantNil: true},
+		{name: "absent but required", required: true, wantStatus: http.StatusPreconditionRequired},
+		{name: "any", header: "*", required: true, wantNil: true},
+		{name: "strong", header: `"7"`, want: 7},
+		{name: "round trip", header: userETag(42), want: 42},
+		{name: "weak never matches", header: `W/"7"`, wantStatus: http.StatusPreconditionFailed},
+		{name: "foreign tag", header: `"abc"`, wantStatus: http.StatusPreconditionFailed},
+		{name: "list", header: `"6", "7"`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPut, "/users/1", nil)
+			if tc.header != "" {
+				c.Request.Header.Set("If-Match", tc.header)
+			}
+
+			got, err := parseIfMatch(c, tc.required)
+			if tc.wantStatus != 0 {
+				var ae *domain.AppError
+				if !errors.As(err, &ae) || ae.HTTPStatus != tc.wantStatus {
+					t.Fatalf("got %v, want status %d", err, tc.wantStatus)
+				}
+				if tc.wantStatus == http.StatusPreconditionRequired && ae.Code != domain.CodePreconditionRequired {
+					t.Fatalf("got code %s, want %s", ae.Code, domain.CodePreconditionRequired)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.wantNil != (got == nil) || (got != nil && *got != tc.want) {
+				t.Fatalf("got %v, want %d (nil=%v)", got, tc.want, tc.wantNil)
+			}
+		})
+	}
+}
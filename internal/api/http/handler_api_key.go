@@ -0,0 +1,88 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/service"
+)
+
+type APIKeyHandler struct{ Svc *service.APIKeyService }
+
+type apiKeyResponse struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedBy  string   `json:"created_by"`
+	ExpiresAt  *string  `json:"expires_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+	RevokedAt  *string  `json:"revoked_at"`
+	CreatedAt  string   `json:"created_at"`
+	// Key is only set in the response to create.
+	Key string `json:"key,omitempty"`
+}
+
+func toAPIKeyResponse(k sqlc.ApiKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		CreatedBy:  k.CreatedBy,
+		ExpiresAt:  timestampPtr(k.ExpiresAt),
+		LastUsedAt: timestampPtr(k.LastUsedAt),
+		RevokedAt:  timestampPtr(k.RevokedAt),
+		CreatedAt:  timestampString(k.CreatedAt),
+	}
+}
+
+type createAPIKeyReq struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (h *APIKeyHandler) Create(c *gin.Context) {
+	var req createAPIKeyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	k, key, err := h.Svc.Create(c.Request.Context(), req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	resp := toAPIKeyResponse(k)
+	resp.Key = key
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, resp)
+}
+
+func (h *APIKeyHandler) List(c *gin.Context) {
+	keys, err := h.Svc.List(c.Request.Context())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	items := make([]apiKeyResponse, len(keys))
+	for i, k := range keys {
+		items[i] = toAPIKeyResponse(k)
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *APIKeyHandler) Revoke(c *gin.Context) {
+	id, ok := parsePositiveID(c)
+	if !ok {
+		return
+	}
+	if err := h.Svc.Revoke(c.Request.Context(), id); err != nil {
+		c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/service"
+)
+
+type AuthHandler struct{ Svc *service.AuthService }
+
+type registerReq struct {
+	Uid      string  `json:"uid" binding:"required"`
+	Email    *string `json:"email"`
+	Name     string  `json:"name" binding:"required"`
+	Password string  `json:"password" binding:"required"`
+}
+
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req registerReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	u, err := h.Svc.Register(c.Request.Context(), req.Uid, req.Email, req.Name, req.Password)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusCreated, toUserResponse(u))
+}
+
+type loginReq struct {
+	// Login is the account's uid or email.
+	Login    string `json:"login" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+func toTokenResponse(t service.Tokens) tokenResponse {
+	now := time.Now()
+	return tokenResponse{
+		AccessToken:      t.AccessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(t.AccessExpiresAt.Sub(now).Seconds()),
+		RefreshToken:     t.RefreshToken,
+		RefreshExpiresIn: int64(t.RefreshExpiresAt.Sub(now).Seconds()),
+	}
+}
+
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req loginReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	t, err := h.Svc.Login(c.Request.Context(), req.Login, req.Password)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, toTokenResponse(t))
+}
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req refreshReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	t, err := h.Svc.Refresh(c.Request.Context(), req.RefreshToken)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, toTokenResponse(t))
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var req refreshReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	if err := h.Svc.Logout(c.Request.Context(), req.RefreshToken); err != nil {
+		c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type changePasswordReq struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var req changePasswordReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	if err := h.Svc.ChangePassword(c.Request.Context(), req.CurrentPassword, req.NewPassword); err != nil {
+		c.Error(err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,34 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/health"
+)
+
+type HealthHandler struct {
+	Checks  []health.Checker
+	Timeout time.Duration
+}
+
+// Live reports that the process is up and serving; it never touches dependencies.
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+}
+
+// Ready returns 503 when a critical dependency is down and 200 otherwise,
+// including the degraded case where only optional dependencies failed.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.Timeout)
+	defer cancel()
+
+	report := health.Run(ctx, h.Checks)
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
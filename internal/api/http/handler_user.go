@@ -1,6 +1,8 @@
 package http
 
 import (
+	"encoding/json"
+	"html"
 	"net/http"
 	"strconv"
 	"strings"
@@ -8,16 +10,21 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/patch"
 	"github.com/tfenng/scaffold/internal/repo"
 	"github.com/tfenng/scaffold/internal/service"
 )
 
-type UserHandler struct{ Svc *service.UserService }
+type UserHandler struct {
+	Svc *service.UserService
+	// RequireIfMatch rejects PUT and DELETE without an If-Match header.
+	RequireIfMatch bool
+}
 
 func parsePositiveID(c *gin.Context) (int64, bool) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil || id <= 0 {
-		c.Error(domain.Invalid("id must be a positive integer"))
+		c.Error(domain.InvalidField("id", "positive", "must be a positive integer"))
 		return 0, false
 	}
 	return id, true
@@ -33,6 +40,7 @@ func (h *UserHandler) Get(c *gin.Context) {
 		c.Error(err)
 		return
 	}
+	c.Header("ETag", userETag(u.Version))
 	c.JSON(http.StatusOK, toUserResponse(u))
 }
 
@@ -48,7 +56,7 @@ type createUserReq struct {
 func (h *UserHandler) Create(c *gin.Context) {
 	var req createUserReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.Error(domain.Invalid(err.Error()))
+		c.Error(bindError(err))
 		return
 	}
 
@@ -63,26 +71,67 @@ func (h *UserHandler) Create(c *gin.Context) {
 		c.Error(err)
 		return
 	}
+	c.Header("ETag", userETag(u.Version))
 	c.JSON(http.StatusCreated, toUserResponse(u))
 }
 
+// listUsersQuery holds the list parameters other than the filter, which
+// repo.ParseUserFilter reads from the same query string.
 type listUsersQuery struct {
-	Email    *string `form:"email"`
-	NameLike *string `form:"name_like"`
-	Page     int32   `form:"page"`
-	PageSize int32   `form:"page_size"`
+	IncludeDeleted bool `form:"include_deleted"`
+	// Sort is e.g. "-updated_at,name"; see repo.ParseUserSort.
+	Sort     string `form:"sort"`
+	Page     int32  `form:"page"`
+	PageSize int32  `form:"page_size"`
+	// Pagination is "offset" (default) or "cursor"; passing a cursor
+	// implies cursor mode.
+	Pagination string `form:"pagination" binding:"omitempty,oneof=offset cursor"`
+	Cursor     string `form:"cursor"`
+	// WithTotal counts all matching users in cursor mode, which skips the
+	// count by default.
+	WithTotal bool `form:"with_total"`
 }
 
 func (h *UserHandler) List(c *gin.Context) {
 	var q listUsersQuery
 	if err := c.ShouldBindQuery(&q); err != nil {
-		c.Error(domain.Invalid(err.Error()))
+		c.Error(bindError(err))
 		return
 	}
 
-	out, err := h.Svc.List(c.Request.Context(), repo.UserListFilter{
-		Email: q.Email, NameLike: q.NameLike, Page: q.Page, PageSize: q.PageSize,
-	})
+	where, err := repo.ParseUserFilter(c.Request.URL.Query())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	sort, err := repo.ParseUserSort(q.Sort)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	f := repo.UserListFilter{
+		Where: where, IncludeDeleted: q.IncludeDeleted, Sort: sort, Page: q.Page, PageSize: q.PageSize,
+	}
+	if q.Pagination == "cursor" || q.Cursor != "" {
+		if q.Pagination == "offset" {
+			c.Error(domain.InvalidField("cursor", "excluded_with", "is not allowed with offset pagination"))
+			return
+		}
+		if q.Page != 0 {
+			c.Error(domain.InvalidField("page", "excluded_with", "is not allowed with cursor pagination"))
+			return
+		}
+		out, err := h.Svc.Scroll(c.Request.Context(), f, q.Cursor, q.WithTotal)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, toUserCursorPageResponse(out))
+		return
+	}
+
+	out, err := h.Svc.List(c.Request.Context(), f)
 	if err != nil {
 		c.Error(err)
 		return
@@ -90,6 +139,43 @@ func (h *UserHandler) List(c *gin.Context) {
 	c.JSON(http.StatusOK, toUserPageResponse(out))
 }
 
+type searchUsersQuery struct {
+	Q     string `form:"q" binding:"required"`
+	Limit int32  `form:"limit"`
+}
+
+type userSearchHitResponse struct {
+	userResponse
+	Rank float64 `json:"rank"`
+	// Snippet is HTML: the searchable fields, escaped, with matches in <mark>.
+	Snippet string `json:"snippet"`
+}
+
+var snippetMarks = strings.NewReplacer(repo.SnippetStart, "<mark>", repo.SnippetStop, "</mark>")
+
+func (h *UserHandler) Search(c *gin.Context) {
+	var q searchUsersQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	hits, err := h.Svc.Search(c.Request.Context(), q.Q, q.Limit)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	items := make([]userSearchHitResponse, len(hits))
+	for i, hit := range hits {
+		items[i] = userSearchHitResponse{
+			userResponse: toUserResponse(hit.User),
+			Rank:         hit.Rank,
+			Snippet:      snippetMarks.Replace(html.EscapeString(hit.Snippet)),
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
 type updateUserReq struct {
 	Email    *string `json:"email"`
 	Name     string  `json:"name" binding:"required"`
@@ -103,10 +189,15 @@ func (h *UserHandler) Update(c *gin.Context) {
 	if !ok {
 		return
 	}
+	ifMatch, err := parseIfMatch(c, h.RequireIfMatch)
+	if err != nil {
+		c.Error(err)
+		return
+	}
 
 	var req updateUserReq
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.Error(domain.Invalid(err.Error()))
+		c.Error(bindError(err))
 		return
 	}
 
@@ -116,11 +207,68 @@ func (h *UserHandler) Update(c *gin.Context) {
 		return
 	}
 
-	u, err := h.Svc.Update(c.Request.Context(), id, req.Email, req.Name, req.UsedName, req.Company, birth)
+	u, err := h.Svc.Update(c.Request.Context(), id, ifMatch, req.Email, req.Name, req.UsedName, req.Company, birth)
 	if err != nil {
 		c.Error(err)
 		return
 	}
+	c.Header("ETag", userETag(u.Version))
+	c.JSON(http.StatusOK, toUserResponse(u))
+}
+
+type patchUserReq struct {
+	Email    patch.Field[string]
+	Name     patch.Field[string]
+	UsedName patch.Field[string]
+	Company  patch.Field[string]
+	Birth    patch.Field[string]
+}
+
+// Patch applies a JSON merge patch: members left out keep their value and
+// null clears them.
+func (h *UserHandler) Patch(c *gin.Context) {
+	id, ok := parsePositiveID(c)
+	if !ok {
+		return
+	}
+	ifMatch, err := parseIfMatch(c, h.RequireIfMatch)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	var req patchUserReq
+	if err := bindMergePatch(c, map[string]json.Unmarshaler{
+		"email":     &req.Email,
+		"name":      &req.Name,
+		"used_name": &req.UsedName,
+		"company":   &req.Company,
+		"birth":     &req.Birth,
+	}); err != nil {
+		c.Error(err)
+		return
+	}
+
+	p := repo.UserPatch{Email: req.Email, Name: req.Name, UsedName: req.UsedName, Company: req.Company}
+	if req.Birth.Present {
+		birth, err := parseBirth(req.Birth.Ptr())
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if birth == nil {
+			p.Birth = patch.Clear[time.Time]()
+		} else {
+			p.Birth = patch.Set(*birth)
+		}
+	}
+
+	u, err := h.Svc.Patch(c.Request.Context(), id, ifMatch, p)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("ETag", userETag(u.Version))
 	c.JSON(http.StatusOK, toUserResponse(u))
 }
 
@@ -129,14 +277,32 @@ func (h *UserHandler) Delete(c *gin.Context) {
 	if !ok {
 		return
 	}
-	err := h.Svc.Delete(c.Request.Context(), id)
+	ifMatch, err := parseIfMatch(c, h.RequireIfMatch)
 	if err != nil {
 		c.Error(err)
 		return
 	}
+	if err := h.Svc.Delete(c.Request.Context(), id, ifMatch); err != nil {
+		c.Error(err)
+		return
+	}
 	c.Status(http.StatusNoContent)
 }
 
+func (h *UserHandler) Restore(c *gin.Context) {
+	id, ok := parsePositiveID(c)
+	if !ok {
+		return
+	}
+	u, err := h.Svc.Restore(c.Request.Context(), id)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Header("ETag", userETag(u.Version))
+	c.JSON(http.StatusOK, toUserResponse(u))
+}
+
 func parseBirth(birth *string) (*time.Time, error) {
 	if birth == nil {
 		return nil, nil
@@ -147,7 +313,57 @@ func parseBirth(birth *string) (*time.Time, error) {
 	}
 	t, err := time.Parse("2006-01-02", v)
 	if err != nil {
-		return nil, domain.Invalid("birth must be in YYYY-MM-DD format")
+		return nil, domain.InvalidField("birth", "date", "must be in YYYY-MM-DD format")
 	}
 	return &t, nil
 }
+
+type auditEventResponse struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	UserID    int64           `json:"user_id"`
+	UserUid   string          `json:"user_uid"`
+	Changes   json.RawMessage `json:"changes"`
+	RequestID *string         `json:"request_id"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type pageQuery struct {
+	Page     int32 `form:"page"`
+	PageSize int32 `form:"page_size"`
+}
+
+func (h *UserHandler) Audit(c *gin.Context) {
+	id, ok := parsePositiveID(c)
+	if !ok {
+		return
+	}
+	var q pageQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	out, err := h.Svc.AuditTrail(c.Request.Context(), id, q.Page, q.PageSize)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	items := make([]auditEventResponse, len(out.Items))
+	for i, e := range out.Items {
+		items[i] = auditEventResponse{
+			ID:        e.ID,
+			Actor:     e.Actor,
+			Action:    e.Action,
+			UserID:    e.TargetID,
+			UserUid:   e.TargetUid,
+			Changes:   e.Changes,
+			RequestID: textPtr(e.RequestID),
+			CreatedAt: timestampString(e.CreatedAt),
+		}
+	}
+	c.JSON(http.StatusOK, repo.Page[auditEventResponse]{
+		Items: items, Total: out.Total, Page: out.Page, PageSize: out.PageSize, TotalPages: out.TotalPages,
+	})
+}
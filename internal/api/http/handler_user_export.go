@@ -0,0 +1,345 @@
+package http
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/logging"
+	"github.com/tfenng/scaffold/internal/repo"
+	"github.com/tfenng/scaffold/internal/xlsx"
+)
+
+const (
+	// exportFlushRows is how many rows go out between flushes.
+	exportFlushRows = 500
+	// exportWriteWindow is how long the client has to take in the rows
+	// written since the last flush. The first write and each flush push
+	// the write deadline out again, so an export that keeps moving
+	// outlives the server's WriteTimeout.
+	exportWriteWindow = 30 * time.Second
+)
+
+// exportColumn is a user field an export can include. value returns a
+// string, an int64, or nil for NULL.
+type exportColumn struct {
+	name  string
+	value func(u sqlc.User) any
+}
+
+var exportColumns = []exportColumn{
+	{"id", func(u sqlc.User) any { return u.ID }},
+	{"uid", func(u sqlc.User) any { return u.Uid }},
+	{"email", func(u sqlc.User) any { return nullable(textPtr(u.Email)) }},
+	{"name", func(u sqlc.User) any { return u.Name }},
+	{"used_name", func(u sqlc.User) any { return nullable(textPtr(u.UsedName)) }},
+	{"company", func(u sqlc.User) any { return nullable(textPtr(u.Company)) }},
+	{"birth", func(u sqlc.User) any { return nullable(datePtr(u.Birth)) }},
+	{"created_at", func(u sqlc.User) any { return nullable(timestampPtr(u.CreatedAt)) }},
+	{"updated_at", func(u sqlc.User) any { return nullable(timestampPtr(u.UpdatedAt)) }},
+	{"deleted_at", func(u sqlc.User) any { return nullable(timestampPtr(u.DeletedAt)) }},
+	{"version", func(u sqlc.User) any { return u.Version }},
+}
+
+// csvFreeTextColumns are the columns csvCell escapes. Identifiers such as
+// uid and email go out unchanged so an export re-imports as it was.
+var csvFreeTextColumns = []string{"name", "used_name", "company"}
+
+// nullable turns a nil *string into an untyped nil.
+func nullable(s *string) any {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+// exportFormats maps the format parameter to its media type, in the order
+// Accept is matched against.
+var exportFormats = []struct{ name, contentType string }{
+	{"csv", csvContentType},
+	{"ndjson", ndjsonContentType},
+	{"xlsx", xlsx.ContentType},
+}
+
+type exportUsersQuery struct {
+	IncludeDeleted bool   `form:"include_deleted"`
+	Sort           string `form:"sort"`
+	// Format overrides the Accept header.
+	Format string `form:"format" binding:"omitempty,oneof=csv ndjson xlsx"`
+	// Columns is a comma-separated subset of exportColumns; all by default.
+	Columns string `form:"columns"`
+}
+
+// Export streams every user matching the list filters as CSV, NDJSON or
+// XLSX, chosen by the format parameter or else by Accept. Rows are written
+// as they are read from one database snapshot, never collected in memory.
+// An error before the first row gets the usual error response; after it,
+// the connection is dropped so the client cannot mistake a partial file for
+// a whole one.
+func (h *UserHandler) Export(c *gin.Context) {
+	var q exportUsersQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	where, err := repo.ParseUserFilter(c.Request.URL.Query())
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	sort, err := repo.ParseUserSort(q.Sort)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	cols, err := parseExportColumns(q.Columns)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	format, contentType, err := negotiateExportFormat(c, q.Format)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	rc := http.NewResponseController(c.Writer)
+
+	var enc userExportEncoder
+	start := func() (err error) {
+		// The window opens at the first write, not before the query: a big
+		// sorted export can take a while to produce its first row.
+		_ = rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", `attachment; filename="users-`+time.Now().UTC().Format("20060102T150405Z")+"."+format+`"`)
+		c.Status(http.StatusOK)
+		enc, err = newUserExportEncoder(format, c.Writer, cols)
+		return err
+	}
+	n := 0
+	err = h.Svc.Export(ctx, repo.UserListFilter{Where: where, IncludeDeleted: q.IncludeDeleted, Sort: sort}, func(u sqlc.User) error {
+		if enc == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		values := make([]any, len(cols))
+		for i, col := range cols {
+			values[i] = col.value(u)
+		}
+		if err := enc.Row(values); err != nil {
+			return err
+		}
+		if n++; n%exportFlushRows != 0 {
+			return nil
+		}
+		_ = rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	if err == nil && enc == nil {
+		// No rows: still a file, with its header row.
+		err = start()
+	}
+	if err == nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		return
+	}
+
+	if !c.Writer.Written() {
+		_ = rc.SetWriteDeadline(time.Now().Add(exportWriteWindow))
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+		c.Error(err)
+		return
+	}
+	if ctx.Err() != nil {
+		// The client went away; nothing is wrong on this side.
+		logging.From(ctx).Warn("user export aborted by client", "rows", n, "err", err)
+	} else {
+		logging.From(ctx).Error("user export failed mid-stream", "rows", n, "err", err)
+	}
+	abortResponse(c)
+}
+
+// abortResponse drops the connection under a response that is under way,
+// so the client sees a broken transfer rather than a short file. gin will
+// not hijack once the response is written, hence the unwrap. HTTP/2 cannot
+// be hijacked; there the body just ends.
+func abortResponse(c *gin.Context) {
+	rw, ok := c.Writer.(interface{ Unwrap() http.ResponseWriter })
+	if !ok {
+		return
+	}
+	if conn, _, err := http.NewResponseController(rw.Unwrap()).Hijack(); err == nil {
+		_ = conn.Close()
+	}
+}
+
+func parseExportColumns(s string) ([]exportColumn, error) {
+	if strings.TrimSpace(s) == "" {
+		return exportColumns, nil
+	}
+	var cols []exportColumn
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		i := slices.IndexFunc(exportColumns, func(c exportColumn) bool { return c.name == name })
+		if i < 0 {
+			names := make([]string, len(exportColumns))
+			for j, c := range exportColumns {
+				names[j] = c.name
+			}
+			return nil, domain.InvalidField("columns", "oneof", "must be a comma-separated list of "+strings.Join(names, ", "))
+		}
+		if slices.ContainsFunc(cols, func(c exportColumn) bool { return c.name == name }) {
+			return nil, domain.InvalidField("columns", "unique", "must not repeat "+name)
+		}
+		cols = append(cols, exportColumns[i])
+	}
+	return cols, nil
+}
+
+// negotiateExportFormat picks the format parameter if set, else the first
+// format Accept allows; no Accept header means CSV.
+func negotiateExportFormat(c *gin.Context, param string) (format, contentType string, err error) {
+	offered := make([]string, len(exportFormats))
+	for i, f := range exportFormats {
+		if f.name == param {
+			return f.name, f.contentType, nil
+		}
+		offered[i] = f.contentType
+	}
+	ct := c.NegotiateFormat(offered...)
+	for _, f := range exportFormats {
+		if f.contentType == ct {
+			return f.name, f.contentType, nil
+		}
+	}
+	return "", "", domain.NotAcceptable("Accept must allow " + strings.Join(offered, ", ") + ", or pass format")
+}
+
+// userExportEncoder writes an export's rows; values line up with its
+// columns. Nothing is written before the first call.
+type userExportEncoder interface {
+	Row(values []any) error
+	// Flush sends the rows buffered so far.
+	Flush() error
+	// Close finishes the file, without closing the writer.
+	Close() error
+}
+
+func newUserExportEncoder(format string, w io.Writer, cols []exportColumn) (userExportEncoder, error) {
+	header := make([]any, len(cols))
+	for i, c := range cols {
+		header[i] = c.name
+	}
+	switch format {
+	case "ndjson":
+		keys := make([][]byte, len(cols))
+		for i, c := range cols {
+			keys[i], _ = json.Marshal(c.name)
+		}
+		return &ndjsonExportEncoder{w: bufio.NewWriter(w), keys: keys}, nil
+	case "xlsx":
+		xw, err := xlsx.NewWriter(w, "Users")
+		if err != nil {
+			return nil, err
+		}
+		return xlsxExportEncoder{xw}, xw.WriteRow(header...)
+	}
+	e := &csvExportEncoder{w: csv.NewWriter(w), rec: make([]string, len(cols)), escape: make([]bool, len(cols))}
+	for i, c := range cols {
+		e.escape[i] = slices.Contains(csvFreeTextColumns, c.name)
+	}
+	return e, e.Row(header)
+}
+
+type csvExportEncoder struct {
+	w   *csv.Writer
+	rec []string
+	// escape marks the free-text columns.
+	escape []bool
+}
+
+func (e *csvExportEncoder) Row(values []any) error {
+	for i, v := range values {
+		switch v := v.(type) {
+		case nil:
+			e.rec[i] = ""
+		case int64:
+			e.rec[i] = strconv.FormatInt(v, 10)
+		case string:
+			if e.escape[i] {
+				v = csvCell(v)
+			}
+			e.rec[i] = v
+		}
+	}
+	return e.w.Write(e.rec)
+}
+
+func (e *csvExportEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExportEncoder) Close() error { return e.Flush() }
+
+// csvCell keeps spreadsheets from running a user's text as a formula by
+// prefixing the characters that start one with a quote.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+type ndjsonExportEncoder struct {
+	w *bufio.Writer
+	// keys are the JSON-encoded column names.
+	keys [][]byte
+}
+
+func (e *ndjsonExportEncoder) Row(values []any) error {
+	e.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		e.w.Write(e.keys[i])
+		e.w.WriteByte(':')
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		e.w.Write(b)
+	}
+	e.w.WriteByte('}')
+	return e.w.WriteByte('\n')
+}
+
+func (e *ndjsonExportEncoder) Flush() error { return e.w.Flush() }
+
+func (e *ndjsonExportEncoder) Close() error { return e.w.Flush() }
+
+type xlsxExportEncoder struct{ w *xlsx.Writer }
+
+func (e xlsxExportEncoder) Row(values []any) error { return e.w.WriteRow(values...) }
+
+func (e xlsxExportEncoder) Flush() error { return e.w.Flush() }
+
+func (e xlsxExportEncoder) Close() error { return e.w.Close() }
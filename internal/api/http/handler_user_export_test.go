@@ -0,0 +1,237 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/repo"
+	"github.com/tfenng/scaffold/internal/service"
+	"github.com/tfenng/scaffold/internal/xlsx"
+)
+
+// exportQuery hands out users after delay, then fails with err if set.
+type exportQuery struct {
+	repo.UserQueryRepo
+	users []sqlc.User
+	delay time.Duration
+	err   error
+}
+
+func (q exportQuery) Each(_ context.Context, _ repo.UserListFilter, fn func(sqlc.User) error) error {
+	time.Sleep(q.delay)
+	for _, u := range q.users {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return q.err
+}
+
+type exportTx struct{}
+
+func (exportTx) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (exportTx) WithinSnapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func newExportServer(t *testing.T, q exportQuery) *httptest.Server {
+	t.Helper()
+	return newExportServerTimeout(t, q, 0)
+}
+
+func newExportServerTimeout(t *testing.T, q exportQuery, writeTimeout time.Duration) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorMiddleware(ErrorFormatJSON), func(c *gin.Context) {
+		p := auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}}
+		c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), p))
+	})
+	h := &UserHandler{Svc: &service.UserService{Tx: exportTx{}, Query: q}}
+	r.GET("/users:method", CustomMethod("export"), h.Export)
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func exportGet(t *testing.T, srv *httptest.Server, query, accept string) (*http.Response, string, error) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/users:export"+query, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp, string(body), err
+}
+
+func TestExport(t *testing.T) {
+	users := []sqlc.User{
+		{ID: 2, Uid: "bob", Name: "=HYPERLINK(\"x\")", Company: pgtype.Text{String: "Acme, Inc", Valid: true}},
+		{ID: 1, Uid: "ann", Name: "Ann"},
+	}
+	srv := newExportServer(t, exportQuery{users: users})
+
+	resp, body, err := exportGet(t, srv, "?columns=id,name,company", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != csvContentType {
+		t.Fatalf("got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="users-`) || !strings.HasSuffix(cd, `.csv"`) {
+		t.Fatalf("unexpected Content-Disposition %q", cd)
+	}
+	want := "id,name,company\n2,\"'=HYPERLINK(\"\"x\"\")\",\"Acme, Inc\"\n1,Ann,\n"
+	if body != want {
+		t.Fatalf("got %q, want %q", body, want)
+	}
+
+	_, body, err = exportGet(t, srv, "?columns=uid,company,id", ndjsonContentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `{"uid":"bob","company":"Acme, Inc","id":2}` + "\n" + `{"uid":"ann","company":null,"id":1}` + "\n"
+	if body != want {
+		t.Fatalf("got %q, want %q", body, want)
+	}
+
+	resp, body, err = exportGet(t, srv, "?format=xlsx", csvContentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != xlsx.ContentType || !strings.HasPrefix(body, "PK") {
+		t.Fatalf("format should win over Accept, got %s", resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestExportRoundTripsIdentifiers(t *testing.T) {
+	users := []sqlc.User{{ID: 1, Uid: "-foo", Email: pgtype.Text{String: "+1@x.com", Valid: true}, Name: "Foo"}}
+	srv := newExportServer(t, exportQuery{users: users})
+	_, body, err := exportGet(t, srv, "?columns=uid,email,name", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := newUserImportSource(csvContentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := src.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Uid != "-foo" || row.Email != "+1@x.com" {
+		t.Fatalf("identifiers changed on the way back: uid=%q email=%q", row.Uid, row.Email)
+	}
+}
+
+func TestExportEmpty(t *testing.T) {
+	srv := newExportServer(t, exportQuery{})
+	resp, body, err := exportGet(t, srv, "?columns=uid,name", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || body != "uid,name\n" {
+		t.Fatalf("got %d %q, want just the header row", resp.StatusCode, body)
+	}
+}
+
+func TestExportSlowFirstRow(t *testing.T) {
+	// The query outlasts the server's WriteTimeout; the write window only
+	// starts with the first row.
+	srv := newExportServerTimeout(t, exportQuery{users: []sqlc.User{{ID: 1, Uid: "ann", Name: "Ann"}}, delay: 200 * time.Millisecond}, 50*time.Millisecond)
+	resp, body, err := exportGet(t, srv, "?columns=uid", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || body != "uid\nann\n" {
+		t.Fatalf("got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestExportFailure(t *testing.T) {
+	boom := errors.New("connection reset")
+
+	// Nothing written yet: an ordinary error response.
+	srv := newExportServer(t, exportQuery{err: boom})
+	resp, _, err := exportGet(t, srv, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Content-Disposition") != "" {
+		t.Fatalf("got %d with Content-Disposition %q", resp.StatusCode, resp.Header.Get("Content-Disposition"))
+	}
+
+	// Rows already out: the transfer must break rather than end cleanly.
+	users := make([]sqlc.User, exportFlushRows+1)
+	for i := range users {
+		users[i] = sqlc.User{ID: int64(i + 1), Uid: "u", Name: "n"}
+	}
+	srv = newExportServer(t, exportQuery{users: users, err: boom})
+	resp, _, err = exportGet(t, srv, "", "")
+	if resp.StatusCode != http.StatusOK || err == nil {
+		t.Fatalf("got %d and %v, want a truncated 200", resp.StatusCode, err)
+	}
+}
+
+func TestExportRejects(t *testing.T) {
+	srv := newExportServer(t, exportQuery{})
+	tests := []struct {
+		query, accept string
+		want          int
+	}{
+		{"?columns=id,password", "", http.StatusBadRequest},
+		{"?columns=id,id", "", http.StatusBadRequest},
+		{"?format=pdf", "", http.StatusBadRequest},
+		{"?sort=password", "", http.StatusBadRequest},
+		{"", "application/pdf", http.StatusNotAcceptable},
+	}
+	for _, tc := range tests {
+		resp, _, err := exportGet(t, srv, tc.query, tc.accept)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s (Accept %q): got %d, want %d", tc.query, tc.accept, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestParseExportColumns(t *testing.T) {
+	cols, err := parseExportColumns(" email , id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 2 || cols[0].name != "email" || cols[1].name != "id" {
+		t.Fatalf("unexpected columns %+v", cols)
+	}
+	if cols, _ := parseExportColumns(""); len(cols) != len(exportColumns) {
+		t.Fatalf("no columns should mean all of them, got %d", len(cols))
+	}
+	_, err = parseExportColumns("id,")
+	var ae *domain.AppError
+	if !errors.As(err, &ae) || ae.HTTPStatus != http.StatusBadRequest {
+		t.Fatalf("got %v, want a 400", err)
+	}
+}
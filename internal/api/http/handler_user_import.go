@@ -0,0 +1,206 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/service"
+)
+
+const (
+	csvContentType    = "text/csv"
+	ndjsonContentType = "application/x-ndjson"
+	// maxNDJSONLine bounds one NDJSON record.
+	maxNDJSONLine = 64 << 10
+)
+
+// importColumns are the fields of an import record, in UserImportRow order.
+// A CSV file names the ones it has in its header row.
+var importColumns = []string{"uid", "email", "name", "used_name", "company", "birth"}
+
+type importUsersQuery struct {
+	Mode string `form:"mode" binding:"omitempty,oneof=all_or_nothing best_effort"`
+}
+
+// Import creates users from a CSV or NDJSON body, decoding it as it arrives.
+// The per-row report comes back with 200, or with 422 when an all-or-nothing
+// import had failed rows and nothing was created. Idempotency-Key is refused:
+// honouring it would mean buffering the whole upload to fingerprint it.
+func (h *UserHandler) Import(c *gin.Context) {
+	if c.GetHeader(IdempotencyKeyHeader) != "" {
+		c.Error(domain.InvalidField(IdempotencyKeyHeader, "excluded", "is not supported by imports; retry an all_or_nothing import instead"))
+		return
+	}
+	var q importUsersQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.Error(bindError(err))
+		return
+	}
+	src, err := newUserImportSource(c.GetHeader("Content-Type"), c.Request.Body)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	report, err := h.Svc.Import(c.Request.Context(), src, service.ImportMode(q.Mode))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	status := http.StatusOK
+	if !report.Committed {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, report)
+}
+
+func newUserImportSource(contentType string, body io.Reader) (service.UserImportSource, error) {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case csvContentType:
+		return newCSVImportSource(body)
+	case ndjsonContentType, "application/jsonl":
+		sc := bufio.NewScanner(body)
+		sc.Buffer(make([]byte, 0, 4096), maxNDJSONLine)
+		return &ndjsonImportSource{sc: sc}, nil
+	}
+	return nil, domain.UnsupportedMediaType("Content-Type must be " + csvContentType + " or " + ndjsonContentType)
+}
+
+// csvImportSource reads a CSV file whose header row names its columns, in
+// any order; uid and name are required.
+type csvImportSource struct {
+	r *csv.Reader
+	// cols maps each CSV column to its index in importColumns.
+	cols []int
+}
+
+func newCSVImportSource(body io.Reader) (*csvImportSource, error) {
+	r := csv.NewReader(body)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, domain.Invalid("CSV file must start with a header row")
+	}
+	if err != nil {
+		return nil, csvError(err)
+	}
+	cols := make([]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			// Spreadsheet programs like to save CSV with a byte order mark.
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		j := slices.Index(importColumns, name)
+		if j < 0 {
+			return nil, domain.Invalid(fmt.Sprintf("CSV header has unknown column %q; columns are %s", name, strings.Join(importColumns, ", ")))
+		}
+		if slices.Contains(cols[:i], j) {
+			return nil, domain.Invalid(fmt.Sprintf("CSV header repeats column %q", name))
+		}
+		cols[i] = j
+	}
+	if !slices.Contains(cols, 0) || !slices.Contains(cols, 2) {
+		return nil, domain.Invalid("CSV header must have uid and name columns")
+	}
+	return &csvImportSource{r: r, cols: cols}, nil
+}
+
+func (s *csvImportSource) Next() (service.UserImportRow, error) {
+	rec, err := s.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return service.UserImportRow{}, io.EOF
+		}
+		return service.UserImportRow{}, csvError(err)
+	}
+	line, _ := s.r.FieldPos(0)
+	if len(rec) != len(s.cols) {
+		return service.UserImportRow{Line: line, Err: fmt.Errorf("has %d fields, the header has %d", len(rec), len(s.cols))}, nil
+	}
+	var f [6]string
+	for i, v := range rec {
+		f[s.cols[i]] = v
+	}
+	return service.UserImportRow{Line: line, Uid: f[0], Email: f[1], Name: f[2], UsedName: f[3], Company: f[4], Birth: f[5]}, nil
+}
+
+// csvError turns a CSV syntax error, which the reader cannot recover from,
+// into one about the whole request.
+func csvError(err error) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return domain.Invalid(fmt.Sprintf("malformed CSV on line %d: %v", pe.Line, pe.Err))
+	}
+	return importReadError(err)
+}
+
+// ndjsonImportSource reads one JSON object per line; blank lines are
+// skipped.
+type ndjsonImportSource struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+type ndjsonImportRecord struct {
+	Uid      string `json:"uid"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	UsedName string `json:"used_name"`
+	Company  string `json:"company"`
+	Birth    string `json:"birth"`
+}
+
+func (s *ndjsonImportSource) Next() (service.UserImportRow, error) {
+	for s.sc.Scan() {
+		s.line++
+		b := bytes.TrimSpace(s.sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+
+		row := service.UserImportRow{Line: s.line}
+		var rec ndjsonImportRecord
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rec); err != nil {
+			row.Err = fmt.Errorf("is not a valid user object: %v", err)
+			return row, nil
+		}
+		if dec.More() {
+			row.Err = errors.New("has data after the user object")
+			return row, nil
+		}
+		row.Uid, row.Email, row.Name, row.UsedName, row.Company, row.Birth = rec.Uid, rec.Email, rec.Name, rec.UsedName, rec.Company, rec.Birth
+		return row, nil
+	}
+
+	err := s.sc.Err()
+	switch {
+	case err == nil:
+		return service.UserImportRow{}, io.EOF
+	case errors.Is(err, bufio.ErrTooLong):
+		return service.UserImportRow{}, domain.Invalid(fmt.Sprintf("line %d is longer than %d bytes", s.line+1, maxNDJSONLine))
+	}
+	return service.UserImportRow{}, importReadError(err)
+}
+
+func importReadError(err error) error {
+	e := domain.Invalid("could not read request body")
+	e.Cause = err
+	return e
+}
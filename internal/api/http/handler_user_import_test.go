@@ -0,0 +1,191 @@
+package http
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/service"
+)
+
+func readImportRows(t *testing.T, src service.UserImportSource) ([]service.UserImportRow, error) {
+	t.Helper()
+	var rows []service.UserImportRow
+	for {
+		row, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestCSVImportSource(t *testing.T) {
+	body := "\ufeffName, UID ,birth\n" +
+		"Ann,ann,1990-01-02\n" +
+		"\n" +
+		"\"Smith, Bob\",bob,\n" +
+		"short\n"
+	src, err := newUserImportSource("text/csv; charset=utf-8", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("new source: %v", err)
+	}
+	rows, err := readImportRows(t, src)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3: %+v", len(rows), rows)
+	}
+	if r := rows[0]; r.Line != 2 || r.Name != "Ann" || r.Uid != "ann" || r.Birth != "1990-01-02" || r.Email != "" || r.Err != nil {
+		t.Fatalf("unexpected first row: %+v", r)
+	}
+	if r := rows[1]; r.Line != 4 || r.Name != "Smith, Bob" || r.Uid != "bob" {
+		t.Fatalf("unexpected second row: %+v", r)
+	}
+	if r := rows[2]; r.Line != 5 || r.Err == nil {
+		t.Fatalf("short record should fail on its own: %+v", r)
+	}
+}
+
+func TestCSVImportSourceRejects(t *testing.T) {
+	tests := []struct {
+		name, body string
+	}{
+		{name: "empty", body: ""},
+		{name: "unknown column", body: "uid,name,age\n"},
+		{name: "repeated column", body: "uid,name,uid\n"},
+		{name: "missing name", body: "uid,email\n"},
+		{name: "bad quote", body: "uid,name\nann,\"Ann\n"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			src, err := newUserImportSource("text/csv", strings.NewReader(tc.body))
+			if err == nil {
+				_, err = readImportRows(t, src)
+			}
+			var ae *domain.AppError
+			if !errors.As(err, &ae) || ae.HTTPStatus != http.StatusBadRequest {
+				t.Fatalf("got %v, want a 400", err)
+			}
+		})
+	}
+}
+
+func TestNDJSONImportSource(t *testing.T) {
+	body := `{"uid":"ann","name":"Ann","email":"ann@example.com"}` + "\n" +
+		"\n" +
+		`{"uid":"bob","age":3}` + "\n" +
+		`{"uid":"carol"} {}` + "\n" +
+		`{"uid":"dave","name":null}`
+	src, err := newUserImportSource("application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("new source: %v", err)
+	}
+	rows, err := readImportRows(t, src)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4: %+v", len(rows), rows)
+	}
+	if r := rows[0]; r.Line != 1 || r.Uid != "ann" || r.Email != "ann@example.com" || r.Err != nil {
+		t.Fatalf("unexpected first row: %+v", r)
+	}
+	if rows[1].Line != 3 || rows[1].Err == nil || rows[2].Err == nil {
+		t.Fatalf("unknown fields and trailing data should fail the row: %+v", rows[1:3])
+	}
+	if r := rows[3]; r.Line != 5 || r.Uid != "dave" || r.Name != "" || r.Err != nil {
+		t.Fatalf("unexpected last row: %+v", r)
+	}
+
+	src, _ = newUserImportSource("application/x-ndjson", strings.NewReader(`{"uid":"`+strings.Repeat("a", maxNDJSONLine)+`"}`))
+	if _, err := readImportRows(t, src); err == nil {
+		t.Fatal("an overlong line should abort the import")
+	}
+}
+
+func TestImportSourceStreams(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go io.WriteString(pw, "uid,name\nann,Ann\n")
+
+	// The first row is decoded while the body is still open.
+	src, err := newUserImportSource("text/csv", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := src.Next()
+	if err != nil || row.Uid != "ann" {
+		t.Fatalf("got %+v, %v", row, err)
+	}
+}
+
+// unreadBody fails the test if anything reads it.
+type unreadBody struct{ t *testing.T }
+
+func (b unreadBody) Read([]byte) (int, error) {
+	b.t.Error("request body was read")
+	return 0, io.EOF
+}
+
+func TestImportRejectsIdempotencyKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorMiddleware(ErrorFormatJSON))
+	r.POST("/users:method", CustomMethod("import"), (&UserHandler{}).Import)
+
+	req := httptest.NewRequest(http.MethodPost, "/users:import", unreadBody{t})
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set(IdempotencyKeyHeader, "k1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), IdempotencyKeyHeader) {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestImportSourceContentType(t *testing.T) {
+	_, err := newUserImportSource("application/json", strings.NewReader("[]"))
+	var ae *domain.AppError
+	if !errors.As(err, &ae) || ae.HTTPStatus != http.StatusUnsupportedMediaType {
+		t.Fatalf("got %v, want 415", err)
+	}
+}
+
+func TestCustomMethod(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(ErrorMiddleware(ErrorFormatJSON))
+	ok := func(name string) gin.HandlerFunc { return func(c *gin.Context) { c.String(http.StatusOK, name) } }
+	r.POST("/users", ok("create"))
+	r.GET("/users/:id", ok("get"))
+	r.POST("/users:method", CustomMethod("import"), ok("import"))
+
+	tests := []struct {
+		method, path string
+		wantStatus   int
+		wantBody     string
+	}{
+		{http.MethodPost, "/users:import", http.StatusOK, "import"},
+		{http.MethodPost, "/users", http.StatusOK, "create"},
+		{http.MethodGet, "/users/7", http.StatusOK, "get"},
+		{http.MethodPost, "/users:export", http.StatusNotFound, ""},
+		{http.MethodPost, "/usersimport", http.StatusNotFound, ""},
+	}
+	for _, tc := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.wantStatus || (tc.wantBody != "" && w.Body.String() != tc.wantBody) {
+			t.Errorf("%s %s: got %d %q", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+}
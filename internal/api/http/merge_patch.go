@@ -0,0 +1,56 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"mime"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+const mergePatchContentType = "application/merge-patch+json"
+
+// bindMergePatch decodes an RFC 7396 merge patch into fields, keyed by JSON
+// member name. Unknown members and values of the wrong type are reported as
+// field violations; plain application/json is accepted as well.
+func bindMergePatch(c *gin.Context, fields map[string]json.Unmarshaler) error {
+	mt, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mt != mergePatchContentType && mt != "application/json" {
+		return domain.UnsupportedMediaType("Content-Type must be " + mergePatchContentType)
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&members); err != nil {
+		var te *json.UnmarshalTypeError
+		if errors.As(err, &te) {
+			return domain.Invalid("merge patch must be a JSON object")
+		}
+		return domain.Invalid("request body must be valid JSON")
+	}
+	if members == nil {
+		return domain.Invalid("merge patch must be a JSON object")
+	}
+
+	var violations []domain.FieldViolation
+	for _, name := range slices.Sorted(maps.Keys(members)) {
+		f, ok := fields[name]
+		if !ok {
+			violations = append(violations, domain.FieldViolation{Field: name, Rule: "unknown", Message: "cannot be patched"})
+			continue
+		}
+		if err := f.UnmarshalJSON(members[name]); err != nil {
+			var te *json.UnmarshalTypeError
+			if !errors.As(err, &te) {
+				return domain.Invalid("request body must be valid JSON")
+			}
+			violations = append(violations, domain.FieldViolation{Field: name, Rule: "type", Message: "must be " + jsonTypeName(te.Type)})
+		}
+	}
+	if len(violations) > 0 {
+		return domain.InvalidFields(summarize(violations), violations...)
+	}
+	return nil
+}
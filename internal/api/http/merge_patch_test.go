@@ -0,0 +1,81 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/patch"
+)
+
+func TestBindMergePatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantFields  []string
+	}{
+		{name: "merge patch", contentType: mergePatchContentType, body: `{"company":null,"name":"Bob"}`},
+		{name: "json", contentType: "application/json; charset=utf-8", body: `{}`},
+		{name: "json patch", contentType: "application/json-patch+json", body: `[]`, wantStatus: http.StatusUnsupportedMediaType},
+		{name: "not an object", contentType: mergePatchContentType, body: `["name"]`, wantStatus: http.StatusBadRequest},
+		{name: "null document", contentType: mergePatchContentType, body: `null`, wantStatus: http.StatusBadRequest},
+		{
+			name:        "unknown and mistyped members",
+			contentType: mergePatchContentType,
+			body:        `{"uid":"x","name":42}`,
+			wantStatus:  http.StatusBadRequest,
+			wantFields:  []string{"name", "uid"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(tc.body))
+			c.Request.Header.Set("Content-Type", tc.contentType)
+
+			var name, company patch.Field[string]
+			err := bindMergePatch(c, map[string]json.Unmarshaler{"name": &name, "company": &company})
+			if tc.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var ae *domain.AppError
+			if !errors.As(err, &ae) || ae.HTTPStatus != tc.wantStatus {
+				t.Fatalf("got %v, want status %d", err, tc.wantStatus)
+			}
+			if len(ae.Fields) != len(tc.wantFields) {
+				t.Fatalf("unexpected violations: %+v", ae.Fields)
+			}
+			for i, f := range ae.Fields {
+				if f.Field != tc.wantFields[i] {
+					t.Fatalf("unexpected violations: %+v", ae.Fields)
+				}
+			}
+		})
+	}
+
+	t.Run("tri-state", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(`{"company":null,"name":"Bob"}`))
+		c.Request.Header.Set("Content-Type", mergePatchContentType)
+
+		var name, company, email patch.Field[string]
+		if err := bindMergePatch(c, map[string]json.Unmarshaler{"name": &name, "company": &company, "email": &email}); err != nil {
+			t.Fatal(err)
+		}
+		if !name.IsSet() || name.Value != "Bob" || !company.Present || !company.Null || email.Present {
+			t.Fatalf("name=%+v company=%+v email=%+v", name, company, email)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/logging"
+	"github.com/tfenng/scaffold/internal/requestid"
+)
+
+// errorCodeKey is where ErrorMiddleware leaves the rendered domain.Code for the access log.
+const errorCodeKey = "error_code"
+
+// AccessLogMiddleware puts a request-scoped logger (tagged with request_id)
+// into the request context and writes one line per request once it completes.
+// It must run after RequestIDMiddleware (and the tracing middleware, to pick
+// up trace_id) and before ErrorMiddleware.
+func AccessLogMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		l := logger.With("request_id", requestid.From(c.Request.Context()))
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			l = l.With("trace_id", sc.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(logging.With(c.Request.Context(), l))
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.Int("status", c.Writer.Status()),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if code, ok := c.Get(errorCodeKey); ok {
+			attrs = append(attrs, slog.String("error_code", string(code.(domain.Code))))
+		}
+		l.LogAttrs(c.Request.Context(), slog.LevelInfo, "http request", attrs...)
+	}
+}
@@ -0,0 +1,71 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/logging"
+	"github.com/tfenng/scaffold/internal/requestid"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	r := gin.New()
+	r.Use(RequestIDMiddleware(), AccessLogMiddleware(logger), ErrorMiddleware(ErrorFormatJSON))
+	r.GET("/users/:id", func(c *gin.Context) {
+		logging.From(c.Request.Context()).Info("inside handler")
+		c.Error(domain.NotFound("user not found"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.Header.Set(requestid.Header, "req-42")
+	req.Header.Set("User-Agent", "test-agent")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected handler line and access line, got %d:\n%s", len(lines), buf.String())
+	}
+
+	var handlerLine map[string]any
+	if err := json.Unmarshal(lines[0], &handlerLine); err != nil {
+		t.Fatal(err)
+	}
+	if handlerLine["request_id"] != "req-42" {
+		t.Fatalf("context logger lacks request_id: %v", handlerLine)
+	}
+
+	var access map[string]any
+	if err := json.Unmarshal(lines[1], &access); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"msg":        "http request",
+		"request_id": "req-42",
+		"method":     "GET",
+		"route":      "/users/:id",
+		"status":     float64(404),
+		"user_agent": "test-agent",
+		"error_code": "NOT_FOUND",
+	}
+	for k, v := range want {
+		if access[k] != v {
+			t.Fatalf("unexpected %s: got=%v want=%v", k, access[k], v)
+		}
+	}
+	for _, k := range []string{"latency_ms", "bytes", "client_ip"} {
+		if _, ok := access[k]; !ok {
+			t.Fatalf("missing %s in %v", k, access)
+		}
+	}
+}
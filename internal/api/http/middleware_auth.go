@@ -0,0 +1,98 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/logging"
+)
+
+// APIKeyAuthenticator resolves an API key to its principal.
+type APIKeyAuthenticator interface {
+	Authenticate(ctx context.Context, key string) (auth.Principal, error)
+}
+
+// AuthMiddleware requires "Authorization: Bearer <jwt>" or, when keys is
+// set, an API key as "Authorization: ApiKey sk_..." (or "Bearer sk_...").
+// The verified principal is stored in the request context. Failures are
+// rendered by ErrorMiddleware as UNAUTHENTICATED with a WWW-Authenticate
+// challenge.
+func AuthMiddleware(v *auth.Verifier, keys APIKeyAuthenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		scheme, token, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		token = strings.TrimSpace(token)
+
+		var p auth.Principal
+		switch {
+		case token == "" || (!strings.EqualFold(scheme, "Bearer") && !strings.EqualFold(scheme, "ApiKey")):
+			unauthenticated(c, `Bearer`, "missing bearer token")
+			return
+
+		case strings.EqualFold(scheme, "ApiKey") || strings.HasPrefix(token, auth.APIKeyPrefix):
+			if keys == nil {
+				unauthenticated(c, `Bearer`, "api keys are not accepted")
+				return
+			}
+			var err error
+			if p, err = keys.Authenticate(ctx, token); err != nil {
+				var ae *domain.AppError
+				if errors.As(err, &ae) && ae.Code == domain.CodeUnauthenticated {
+					c.Header("WWW-Authenticate", `ApiKey`)
+				}
+				_ = c.Error(err)
+				c.Abort()
+				return
+			}
+
+		default:
+			var err error
+			if p, err = v.Verify(token); err != nil {
+				logging.From(ctx).LogAttrs(ctx, slog.LevelInfo, "token rejected", slog.String("reason", err.Error()))
+				unauthenticated(c, `Bearer error="invalid_token"`, auth.Message(err))
+				return
+			}
+		}
+
+		ctx = auth.WithPrincipal(ctx, p)
+		trace.SpanFromContext(ctx).SetAttributes(semconv.EnduserID(p.Subject))
+		c.Request = c.Request.WithContext(logging.With(ctx, logging.From(ctx).With("subject", p.Subject)))
+		c.Next()
+	}
+}
+
+func unauthenticated(c *gin.Context, challenge, msg string) {
+	c.Header("WWW-Authenticate", challenge)
+	_ = c.Error(domain.Unauthenticated(msg))
+	c.Abort()
+}
+
+// RequirePermission rejects callers whose principal lacks perm before the
+// handler runs. UserService checks again so non-HTTP callers are covered.
+func RequirePermission(perm auth.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := auth.Require(c.Request.Context(), perm); err != nil {
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// AssumePrincipal stands in for AuthMiddleware when authentication is
+// disabled, acting as p on every request.
+func AssumePrincipal(p auth.Principal) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), p))
+		c.Next()
+	}
+}
@@ -0,0 +1,160 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/config"
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "0123456789abcdef0123456789abcdef"
+	v, err := auth.NewVerifier(config.Auth{HS256Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "42",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(ErrorMiddleware(ErrorFormatJSON))
+	r.GET("/public", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	private := r.Group("", AuthMiddleware(v, nil))
+	private.GET("/private", func(c *gin.Context) {
+		p, _ := auth.PrincipalFrom(c.Request.Context())
+		c.String(http.StatusOK, p.Subject)
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		header     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "public needs no token", path: "/public", wantStatus: http.StatusNoContent},
+		{name: "missing token", path: "/private", wantStatus: http.StatusUnauthorized},
+		{name: "wrong scheme", path: "/private", header: "Basic " + valid, wantStatus: http.StatusUnauthorized},
+		{name: "garbage token", path: "/private", header: "Bearer abc.def.ghi", wantStatus: http.StatusUnauthorized},
+		{name: "valid token", path: "/private", header: "Bearer " + valid, wantStatus: http.StatusOK, wantBody: "42"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("status: got=%d want=%d body=%s", w.Code, tc.wantStatus, w.Body)
+			}
+			if tc.wantStatus != http.StatusUnauthorized {
+				if tc.wantBody != "" && w.Body.String() != tc.wantBody {
+					t.Fatalf("body: got=%q want=%q", w.Body, tc.wantBody)
+				}
+				return
+			}
+			if w.Header().Get("WWW-Authenticate") == "" {
+				t.Fatal("missing WWW-Authenticate challenge")
+			}
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body["code"] != "UNAUTHENTICATED" {
+				t.Fatalf("unexpected body: %v", body)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		roles      []string
+		wantStatus int
+	}{
+		{name: "admin", roles: []string{auth.RoleAdmin}, wantStatus: http.StatusNoContent},
+		{name: "support", roles: []string{auth.RoleSupport}, wantStatus: http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(ErrorMiddleware(ErrorFormatJSON))
+			r.Use(AssumePrincipal(auth.Principal{Subject: "7", Roles: tc.roles}))
+			r.DELETE("/users/1", RequirePermission(auth.PermUsersDelete), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/users/1", nil))
+			if w.Code != tc.wantStatus {
+				t.Fatalf("status: got=%d want=%d body=%s", w.Code, tc.wantStatus, w.Body)
+			}
+		})
+	}
+}
+
+type stubKeys map[string]auth.Principal
+
+func (s stubKeys) Authenticate(_ context.Context, key string) (auth.Principal, error) {
+	p, ok := s[key]
+	if !ok {
+		return auth.Principal{}, domain.Unauthenticated("invalid api key")
+	}
+	return p, nil
+}
+
+func TestAuthMiddlewareAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	v, err := auth.NewVerifier(config.Auth{HS256Secret: "0123456789abcdef0123456789abcdef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const key = "sk_0123456789ab_secret"
+	keys := stubKeys{key: {Subject: "api_key:1", Roles: []string{auth.RoleService}}}
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+	}{
+		{name: "apikey scheme", header: "ApiKey " + key, wantStatus: http.StatusOK},
+		{name: "bearer with key", header: "Bearer " + key, wantStatus: http.StatusOK},
+		{name: "unknown key", header: "ApiKey sk_0123456789ab_other", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(ErrorMiddleware(ErrorFormatJSON))
+			r.GET("/private", AuthMiddleware(v, keys), func(c *gin.Context) {
+				p, _ := auth.PrincipalFrom(c.Request.Context())
+				c.String(http.StatusOK, p.Subject)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/private", nil)
+			req.Header.Set("Authorization", tc.header)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tc.wantStatus {
+				t.Fatalf("status: got=%d want=%d body=%s", w.Code, tc.wantStatus, w.Body)
+			}
+		})
+	}
+}
@@ -2,13 +2,54 @@ package http
 
 import (
 	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/logging"
+	"github.com/tfenng/scaffold/internal/requestid"
+)
+
+type ErrorFormat string
+
+const (
+	// ErrorFormatJSON renders {"code","message","errors","request_id"}.
+	ErrorFormatJSON ErrorFormat = "json"
+	// ErrorFormatProblem renders RFC 7807 application/problem+json.
+	ErrorFormatProblem ErrorFormat = "problem"
 )
 
-func ErrorMiddleware() gin.HandlerFunc {
+const problemContentType = "application/problem+json"
+
+type errorBody struct {
+	Code      domain.Code             `json:"code"`
+	Message   string                  `json:"message"`
+	Errors    []domain.FieldViolation `json:"errors,omitempty"`
+	RequestID string                  `json:"request_id,omitempty"`
+}
+
+type problemBody struct {
+	Type      string                  `json:"type"`
+	Title     string                  `json:"title"`
+	Status    int                     `json:"status"`
+	Detail    string                  `json:"detail"`
+	Instance  string                  `json:"instance"`
+	Code      domain.Code             `json:"code"`
+	Errors    []domain.FieldViolation `json:"errors,omitempty"`
+	RequestID string                  `json:"request_id,omitempty"`
+}
+
+// ErrorMiddleware renders the last error a handler set with c.Error. Clients
+// sending "Accept: application/problem+json" get problem details regardless
+// of format. Every 5xx, and every error with a Cause, is logged with its
+// request ID so the response can be traced back to the root error.
+func ErrorMiddleware(format ErrorFormat) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
 
@@ -18,11 +59,72 @@ func ErrorMiddleware() gin.HandlerFunc {
 		err := c.Errors.Last().Err
 
 		var ae *domain.AppError
-		if errors.As(err, &ae) {
-			c.JSON(ae.HTTPStatus, gin.H{"code": ae.Code, "message": ae.Message})
+		if !errors.As(err, &ae) {
+			ae = domain.Internal(err)
+		}
+
+		c.Set(errorCodeKey, ae.Code)
+		if ae.HTTPStatus >= http.StatusInternalServerError {
+			span := trace.SpanFromContext(c.Request.Context())
+			span.RecordError(err)
+			span.SetStatus(codes.Error, string(ae.Code))
+		}
+		reqID := requestid.From(c.Request.Context())
+		if ae.Cause != nil || ae.HTTPStatus >= http.StatusInternalServerError {
+			logError(c, ae)
+		}
+
+		if format == ErrorFormatProblem || strings.Contains(c.GetHeader("Accept"), problemContentType) {
+			// gin keeps an explicitly set Content-Type when rendering JSON.
+			c.Header("Content-Type", problemContentType)
+			c.JSON(ae.HTTPStatus, problemBody{
+				Type:      problemType(ae.Code),
+				Title:     problemTitle(ae.Code),
+				Status:    ae.HTTPStatus,
+				Detail:    ae.Message,
+				Instance:  c.Request.URL.Path,
+				Code:      ae.Code,
+				Errors:    ae.Fields,
+				RequestID: reqID,
+			})
 			return
 		}
 
-		c.JSON(http.StatusInternalServerError, gin.H{"code": domain.CodeInternal, "message": "internal error"})
+		c.JSON(ae.HTTPStatus, errorBody{Code: ae.Code, Message: ae.Message, Errors: ae.Fields, RequestID: reqID})
+	}
+}
+
+// problemType is a relative URI reference per code, e.g. /problems/not-found.
+func problemType(code domain.Code) string {
+	return "/problems/" + strings.ReplaceAll(strings.ToLower(string(code)), "_", "-")
+}
+
+// problemTitle turns INVALID_ARGUMENT into "Invalid argument".
+func problemTitle(code domain.Code) string {
+	s := strings.ReplaceAll(strings.ToLower(string(code)), "_", " ")
+	if s == "" {
+		return ""
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func logError(c *gin.Context, ae *domain.AppError) {
+	var chain []string
+	for e := ae.Cause; e != nil; e = errors.Unwrap(e) {
+		chain = append(chain, fmt.Sprintf("%T", e))
+	}
+	level := slog.LevelWarn
+	if ae.HTTPStatus >= http.StatusInternalServerError {
+		level = slog.LevelError
 	}
+	ctx := c.Request.Context()
+	logging.From(ctx).LogAttrs(ctx, level, "request failed",
+		slog.String("method", c.Request.Method),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", ae.HTTPStatus),
+		slog.String("code", string(ae.Code)),
+		slog.String("message", ae.Message),
+		slog.Any("cause", ae.Cause),
+		slog.String("chain", strings.Join(chain, " -> ")),
+	)
 }
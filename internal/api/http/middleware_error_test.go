@@ -0,0 +1,104 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/requestid"
+)
+
+func TestErrorMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name            string
+		format          ErrorFormat
+		accept          string
+		err             error
+		wantStatus      int
+		wantContentType string
+		want            map[string]any
+	}{
+		{
+			name:            "json",
+			format:          ErrorFormatJSON,
+			err:             domain.InvalidField("birth", "date", "must be in YYYY-MM-DD format"),
+			wantStatus:      http.StatusBadRequest,
+			wantContentType: "application/json; charset=utf-8",
+			want: map[string]any{
+				"code":       "INVALID_ARGUMENT",
+				"message":    "birth must be in YYYY-MM-DD format",
+				"request_id": "req-1",
+			},
+		},
+		{
+			name:            "problem",
+			format:          ErrorFormatProblem,
+			err:             domain.NotFound("user not found"),
+			wantStatus:      http.StatusNotFound,
+			wantContentType: problemContentType,
+			want: map[string]any{
+				"type":       "/problems/not-found",
+				"title":      "Not found",
+				"status":     float64(404),
+				"detail":     "user not found",
+				"instance":   "/users/1",
+				"code":       "NOT_FOUND",
+				"request_id": "req-1",
+			},
+		},
+		{
+			name:            "problem negotiated by accept",
+			format:          ErrorFormatJSON,
+			accept:          problemContentType,
+			err:             errors.New("boom"),
+			wantStatus:      http.StatusInternalServerError,
+			wantContentType: problemContentType,
+			want: map[string]any{
+				"code":   "INTERNAL",
+				"detail": "internal error",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(RequestIDMiddleware(), ErrorMiddleware(tc.format))
+			r.GET("/users/:id", func(c *gin.Context) { c.Error(tc.err) })
+
+			req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+			req.Header.Set(requestid.Header, "req-1")
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("unexpected status: got=%d want=%d", w.Code, tc.wantStatus)
+			}
+			if got := w.Header().Get("Content-Type"); got != tc.wantContentType {
+				t.Fatalf("unexpected content type: got=%q want=%q", got, tc.wantContentType)
+			}
+			if got := w.Header().Get(requestid.Header); got != "req-1" {
+				t.Fatalf("request id not echoed: %q", got)
+			}
+
+			var body map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tc.want {
+				if body[k] != v {
+					t.Fatalf("unexpected %s: got=%v want=%v", k, body[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/idempotency"
+	"github.com/tfenng/scaffold/internal/logging"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	maxIdempotentResponseSize = 1 << 20
+)
+
+// replayedHeaders are the response headers stored with a response.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// IdempotencyClaimer claims an Idempotency-Key for one request.
+type IdempotencyClaimer interface {
+	Begin(ctx context.Context, key, hash string) (*idempotency.Claim, error)
+}
+
+// IdempotencyMiddleware makes POST requests carrying an Idempotency-Key
+// header safe to retry. The first request with a key runs and its successful
+// (< 500, no error) response is stored; later requests with the same key and
+// body get that response replayed with Idempotent-Replayed: true. A key
+// reused for a different request is rejected with 422, and a retry while the
+// first attempt still runs gets 409. A response marked Cache-Control:
+// no-store, such as one carrying a secret, is never stored. Keys are scoped to
+// the principal, so it must run after AuthMiddleware. The body is read whole
+// to fingerprint the request; one over maxBody bytes gets 413.
+func IdempotencyMiddleware(store IdempotencyClaimer, maxBody int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if !validIdempotencyKey(key) {
+			abortWith(c, domain.InvalidField(IdempotencyKeyHeader, "format", "must be 1-255 printable ASCII characters"))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortWith(c, domain.PayloadTooLarge(fmt.Sprintf("request body with %s must be at most %d bytes", IdempotencyKeyHeader, maxBody)))
+			return
+		}
+		if err != nil {
+			abortWith(c, domain.Invalid("could not read request body"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		claim, err := store.Begin(ctx, scopedIdempotencyKey(ctx, key), requestHash(c.Request, body))
+		switch {
+		case errors.Is(err, idempotency.ErrInFlight):
+			c.Header("Retry-After", "1")
+			abortWith(c, domain.Conflict("a request with this Idempotency-Key is still in progress"))
+			return
+		case errors.Is(err, idempotency.ErrMismatch):
+			abortWith(c, domain.Unprocessable("Idempotency-Key was already used for a different request"))
+			return
+		case err != nil:
+			abortWith(c, err)
+			return
+		case claim.Replay != nil:
+			for k, v := range claim.Replay.Header {
+				c.Header(k, v)
+			}
+			c.Header(IdempotentReplayedHeader, "true")
+			c.Status(claim.Replay.Status)
+			_, _ = c.Writer.Write(claim.Replay.Body)
+			c.Abort()
+			return
+		}
+
+		w := &captureWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		// Errors are rendered later by ErrorMiddleware, so they are never
+		// stored: the key is released and a retry runs the request again.
+		// The same goes for responses that must not be kept anywhere.
+		status := c.Writer.Status()
+		if len(c.Errors) > 0 || status >= http.StatusInternalServerError || w.overflow || noStore(c.Writer.Header()) {
+			if err := claim.Release(context.WithoutCancel(ctx)); err != nil {
+				logging.From(ctx).Warn("idempotency release failed", "err", err)
+			}
+			return
+		}
+		resp := idempotency.Response{Status: status, Header: map[string]string{}, Body: w.body.Bytes()}
+		for _, h := range replayedHeaders {
+			if v := c.Writer.Header().Get(h); v != "" {
+				resp.Header[h] = v
+			}
+		}
+		if err := claim.Complete(context.WithoutCancel(ctx), resp); err != nil {
+			logging.From(ctx).Warn("idempotency complete failed", "err", err)
+		}
+	}
+}
+
+func noStore(h http.Header) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), "no-store") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func abortWith(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func scopedIdempotencyKey(ctx context.Context, key string) string {
+	subject := "anonymous"
+	if p, ok := auth.PrincipalFrom(ctx); ok {
+		subject = p.Subject
+	}
+	return subject + ":" + key
+}
+
+// requestHash fingerprints what the key must keep meaning: method, target
+// and body.
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// captureWriter keeps a copy of the response body, up to
+// maxIdempotentResponseSize.
+type captureWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *captureWriter) capture(b []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(b) > maxIdempotentResponseSize {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(b)
+}
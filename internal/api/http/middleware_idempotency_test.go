@@ -0,0 +1,136 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/idempotency"
+)
+
+type memIdempotency struct {
+	hashes    map[string]string
+	responses map[string]*idempotency.Response
+}
+
+func (m *memIdempotency) Claim(_ context.Context, key, hash string, _ time.Duration) (*idempotency.Response, error) {
+	h, ok := m.hashes[key]
+	switch {
+	case !ok:
+		m.hashes[key] = hash
+		return nil, nil
+	case h != hash:
+		return nil, idempotency.ErrMismatch
+	case m.responses[key] == nil:
+		return nil, idempotency.ErrInFlight
+	}
+	return m.responses[key], nil
+}
+
+func (m *memIdempotency) Complete(_ context.Context, key, _ string, resp idempotency.Response, _ time.Duration) error {
+	m.responses[key] = &resp
+	return nil
+}
+
+func (m *memIdempotency) Release(_ context.Context, key, _ string) error {
+	delete(m.hashes, key)
+	return nil
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	backend := &memIdempotency{hashes: map[string]string{}, responses: map[string]*idempotency.Response{}}
+	calls := 0
+	fail := false
+	r := gin.New()
+	r.Use(ErrorMiddleware(ErrorFormatJSON), AssumePrincipal(auth.Principal{Subject: "1"}))
+	r.Use(IdempotencyMiddleware(&idempotency.Store{Primary: backend, Lock: time.Minute, TTL: time.Hour}, 1<<10))
+	r.POST("/users", func(c *gin.Context) {
+		calls++
+		if fail {
+			c.Error(domain.Conflict("uid already exists"))
+			return
+		}
+		c.Header("ETag", `"1"`)
+		c.JSON(http.StatusCreated, gin.H{"id": calls})
+	})
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("k1", `{"uid":"a"}`)
+	retry := post("k1", `{"uid":"a"}`)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() ||
+		retry.Header().Get("ETag") != `"1"` || retry.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Fatalf("unexpected replay: %d %q %v", retry.Code, retry.Body.String(), retry.Header())
+	}
+
+	if w := post("k1", `{"uid":"b"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key: got %d, want 422", w.Code)
+	}
+	if w := post("bad\nkey", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("invalid key: got %d, want 400", w.Code)
+	}
+	if w := post("k3", `{"uid":"`+strings.Repeat("a", 1<<10)+`"}`); w.Code != http.StatusRequestEntityTooLarge || calls != 1 {
+		t.Fatalf("oversized body: got %d, calls=%d", w.Code, calls)
+	}
+
+	// Failed requests are not stored, so a retry runs again.
+	fail = true
+	post("k2", `{"uid":"c"}`)
+	if w := post("k2", `{"uid":"c"}`); w.Code != http.StatusConflict || calls != 3 {
+		t.Fatalf("failed request replayed: code=%d calls=%d", w.Code, calls)
+	}
+
+	post("", `{}`)
+	post("", `{}`)
+	if calls != 5 {
+		t.Fatalf("requests without a key must always run, calls=%d", calls)
+	}
+}
+
+func TestIdempotencyMiddlewareSkipsNoStore(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	backend := &memIdempotency{hashes: map[string]string{}, responses: map[string]*idempotency.Response{}}
+	calls := 0
+	r := gin.New()
+	r.Use(ErrorMiddleware(ErrorFormatJSON), AssumePrincipal(auth.Principal{Subject: "1"}))
+	r.Use(IdempotencyMiddleware(&idempotency.Store{Primary: backend, Lock: time.Minute, TTL: time.Hour}, 1<<10))
+	r.POST("/api-keys", func(c *gin.Context) {
+		calls++
+		c.Header("Cache-Control", "private, no-store")
+		c.JSON(http.StatusCreated, gin.H{"key": "sk_secret"})
+	})
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/api-keys", strings.NewReader(`{"name":"ci"}`))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated || w.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Fatalf("got %d %v, want a fresh response", w.Code, w.Header())
+		}
+	}
+	if calls != 2 || len(backend.responses) != 0 || len(backend.hashes) != 0 {
+		t.Fatalf("no-store response was kept: calls=%d stored=%v", calls, backend.responses)
+	}
+}
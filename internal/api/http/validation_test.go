@@ -0,0 +1,76 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+func TestBindError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantMsg    string
+		wantFields []domain.FieldViolation
+	}{
+		{
+			name:    "missing required fields",
+			body:    `{"email":"a@b.c"}`,
+			wantMsg: "uid is required; name is required",
+			wantFields: []domain.FieldViolation{
+				{Field: "uid", Rule: "required", Message: "is required"},
+				{Field: "name", Rule: "required", Message: "is required"},
+			},
+		},
+		{
+			name:    "wrong type",
+			body:    `{"uid":"u1","name":"n","company":42}`,
+			wantMsg: "company must be a string",
+			wantFields: []domain.FieldViolation{
+				{Field: "company", Rule: "type", Message: "must be a string"},
+			},
+		},
+		{
+			name:    "malformed json",
+			body:    `{"uid":`,
+			wantMsg: "request body must be valid JSON",
+		},
+		{
+			name:    "empty body",
+			body:    ``,
+			wantMsg: "request body must be valid JSON",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tc.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			var req createUserReq
+			err := c.ShouldBindJSON(&req)
+			if err == nil {
+				t.Fatal("expected bind error")
+			}
+
+			ae := bindError(err)
+			if ae.Code != domain.CodeInvalidArgument {
+				t.Fatalf("unexpected code: %s", ae.Code)
+			}
+			if ae.Message != tc.wantMsg {
+				t.Fatalf("unexpected message: got=%q want=%q", ae.Message, tc.wantMsg)
+			}
+			if !reflect.DeepEqual(ae.Fields, tc.wantFields) {
+				t.Fatalf("unexpected fields: got=%+v want=%+v", ae.Fields, tc.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/tfenng/scaffold/internal/config"
+)
+
+// Claims are the JWT claims the API understands on top of the registered ones.
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+	// Scope is the space-separated OAuth 2.0 scope claim.
+	Scope string `json:"scope,omitempty"`
+}
+
+// Verifier validates bearer tokens signed with HS256 or RS256.
+//
+// Keys come from a shared HS256 secret and/or a local JWKS file holding RSA
+// and oct keys selected by the token's kid. The file is re-read when it
+// changes, so keys are rotated by publishing the new key next to the old one
+// and dropping the old key once its tokens have expired.
+type Verifier struct {
+	secret []byte
+	jwks   *jwksFile
+	parser *jwt.Parser
+}
+
+var ErrNoKey = errors.New("no key for token")
+
+func NewVerifier(c config.Auth) (*Verifier, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithLeeway(c.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if c.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(c.Issuer))
+	}
+	if c.Audience != "" {
+		opts = append(opts, jwt.WithAudience(c.Audience))
+	}
+
+	v := &Verifier{parser: jwt.NewParser(opts...)}
+	if c.HS256Secret != "" {
+		v.secret = []byte(c.HS256Secret)
+	}
+	if c.JWKSFile != "" {
+		f, err := loadJWKSFile(c.JWKSFile, c.JWKSCheckInterval)
+		if err != nil {
+			return nil, err
+		}
+		v.jwks = f
+	}
+	return v, nil
+}
+
+// Verify parses and validates token and returns its principal.
+func (v *Verifier) Verify(token string) (Principal, error) {
+	var claims Claims
+	if _, err := v.parser.ParseWithClaims(token, &claims, v.key); err != nil {
+		return Principal{}, err
+	}
+	if claims.Subject == "" {
+		return Principal{}, errors.New("token has no subject")
+	}
+	return Principal{
+		Subject: claims.Subject,
+		Roles:   claims.Roles,
+		Scopes:  strings.Fields(claims.Scope),
+	}, nil
+}
+
+func (v *Verifier) key(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	switch t.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if k, ok := v.lookup(kid, func(ks keySet) (any, bool) { k, ok := ks.hmac[kid]; return k, ok }); ok {
+			return k, nil
+		}
+		if kid == "" && v.secret != nil {
+			return v.secret, nil
+		}
+	case jwt.SigningMethodRS256.Alg():
+		if k, ok := v.lookup(kid, func(ks keySet) (any, bool) { k, ok := ks.rsa[kid]; return k, ok }); ok {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: alg=%s kid=%q", ErrNoKey, t.Method.Alg(), kid)
+}
+
+// lookup finds a JWKS key, re-reading the file once if kid is unknown so a
+// freshly rotated key is picked up without waiting for the next check.
+func (v *Verifier) lookup(kid string, find func(keySet) (any, bool)) (any, bool) {
+	if v.jwks == nil {
+		return nil, false
+	}
+	v.jwks.maybeReload(false)
+	if k, ok := find(v.jwks.get()); ok {
+		return k, true
+	}
+	if kid == "" {
+		return nil, false
+	}
+	v.jwks.maybeReload(true)
+	return find(v.jwks.get())
+}
+
+// Message is a client-safe description of a Verify error.
+func Message(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "token has expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return "token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return "token issuer is not accepted"
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return "token audience is not accepted"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, ErrNoKey):
+		return "token signature is invalid"
+	default:
+		return "token is invalid"
+	}
+}
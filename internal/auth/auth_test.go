@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/tfenng/scaffold/internal/config"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims Claims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func claims(mod func(*Claims)) Claims {
+	now := time.Now()
+	c := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "42",
+			Issuer:    "https://issuer.test",
+			Audience:  jwt.ClaimStrings{"scaffold-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		Roles: []string{"admin"},
+		Scope: "users:read users:write",
+	}
+	if mod != nil {
+		mod(&c)
+	}
+	return c
+}
+
+func TestVerifyHS256(t *testing.T) {
+	v, err := NewVerifier(config.Auth{
+		Issuer: "https://issuer.test", Audience: "scaffold-api", ClockSkew: 30 * time.Second, HS256Secret: secret,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "valid", token: sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims(nil))},
+		{
+			name: "expired within skew",
+			token: sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims(func(c *Claims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second))
+			})),
+		},
+		{
+			name: "expired beyond skew",
+			token: sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims(func(c *Claims) {
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+			})),
+			wantErr: jwt.ErrTokenExpired,
+		},
+		{
+			name:    "missing exp",
+			token:   sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims(func(c *Claims) { c.ExpiresAt = nil })),
+			wantErr: jwt.ErrTokenRequiredClaimMissing,
+		},
+		{
+			name:    "wrong issuer",
+			token:   sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims(func(c *Claims) { c.Issuer = "evil" })),
+			wantErr: jwt.ErrTokenInvalidIssuer,
+		},
+		{
+			name:    "wrong audience",
+			token:   sign(t, jwt.SigningMethodHS256, []byte(secret), "", claims(func(c *Claims) { c.Audience = jwt.ClaimStrings{"other"} })),
+			wantErr: jwt.ErrTokenInvalidAudience,
+		},
+		{
+			name:    "wrong secret",
+			token:   sign(t, jwt.SigningMethodHS256, []byte("another-secret-another-secret-00"), "", claims(nil)),
+			wantErr: jwt.ErrTokenSignatureInvalid,
+		},
+		{
+			name:    "unknown kid",
+			token:   sign(t, jwt.SigningMethodHS256, []byte(secret), "k9", claims(nil)),
+			wantErr: ErrNoKey,
+		},
+		{
+			name:    "alg none",
+			token:   sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", claims(nil)),
+			wantErr: jwt.ErrTokenSignatureInvalid,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := v.Verify(tc.token)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("got err=%v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.Subject != "42" || len(p.Roles) != 1 || len(p.Scopes) != 2 {
+				t.Fatalf("unexpected principal: %+v", p)
+			}
+		})
+	}
+}
+
+func TestVerifyRS256RotatesJWKSFile(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, map[string]*rsa.PrivateKey{"k1": oldKey})
+
+	v, err := NewVerifier(config.Auth{JWKSFile: path, JWKSCheckInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(sign(t, jwt.SigningMethodRS256, oldKey, "k1", claims(nil))); err != nil {
+		t.Fatalf("k1: %v", err)
+	}
+
+	// Publish k2, retire k1. The unknown kid forces a re-read.
+	writeJWKS(t, path, map[string]*rsa.PrivateKey{"k2": newKey})
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	v.jwks.lastCheck = time.Time{}
+
+	if _, err := v.Verify(sign(t, jwt.SigningMethodRS256, newKey, "k2", claims(nil))); err != nil {
+		t.Fatalf("k2 after rotation: %v", err)
+	}
+	if _, err := v.Verify(sign(t, jwt.SigningMethodRS256, oldKey, "k1", claims(nil))); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("retired k1 should be rejected, got %v", err)
+	}
+}
+
+func writeJWKS(t *testing.T, path string, keys map[string]*rsa.PrivateKey) {
+	t.Helper()
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	for kid, k := range keys {
+		doc.Keys = append(doc.Keys, jwk{
+			Kty: "RSA", Kid: kid, Use: "sig",
+			N: base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		})
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
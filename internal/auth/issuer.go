@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/tfenng/scaffold/internal/config"
+)
+
+// Issuer signs HS256 access tokens that Verifier accepts.
+type Issuer struct {
+	secret   []byte
+	issuer   string
+	audience string
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+func NewIssuer(c config.Auth) *Issuer {
+	return &Issuer{
+		secret:   []byte(c.HS256Secret),
+		issuer:   c.Issuer,
+		audience: c.Audience,
+		ttl:      c.AccessTokenTTL,
+		now:      time.Now,
+	}
+}
+
+// Issue returns a signed access token for p and its expiry.
+func (i *Issuer) Issue(p Principal) (string, time.Time, error) {
+	now := i.now()
+	exp := now.Add(i.ttl)
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   p.Subject,
+			Issuer:    i.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(exp),
+		},
+		Roles: p.Roles,
+		Scope: strings.Join(p.Scopes, " "),
+	}
+	if i.audience != "" {
+		claims.Audience = jwt.ClaimStrings{i.audience}
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(i.secret)
+	return token, exp, err
+}
+
+// NewOpaqueToken returns a random URL-safe token and the SHA-256 hex digest
+// under which it is stored.
+func NewOpaqueToken() (token, digest string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashOpaqueToken(token), nil
+}
+
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
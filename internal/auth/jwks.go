@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+type keySet struct {
+	rsa  map[string]*rsa.PublicKey
+	hmac map[string][]byte
+}
+
+// jwksFile holds the keys from a local JWKS document and reloads them when
+// the file changes, so keys can be rotated by rewriting the file.
+type jwksFile struct {
+	path          string
+	checkInterval time.Duration
+
+	mu        sync.RWMutex
+	keys      keySet
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+func loadJWKSFile(path string, checkInterval time.Duration) (*jwksFile, error) {
+	f := &jwksFile{path: path, checkInterval: checkInterval}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *jwksFile) get() keySet {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.keys
+}
+
+// maybeReload re-reads the file if it changed and checkInterval has passed
+// since the last check, or immediately when force is set (unknown kid),
+// throttled to once per second.
+func (f *jwksFile) maybeReload(force bool) {
+	f.mu.RLock()
+	since := time.Since(f.lastCheck)
+	f.mu.RUnlock()
+	if since < time.Second || (!force && since < f.checkInterval) {
+		return
+	}
+	_ = f.reload()
+}
+
+func (f *jwksFile) reload() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastCheck = time.Now()
+
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("stat jwks file: %w", err)
+	}
+	if !f.modTime.IsZero() && st.ModTime().Equal(f.modTime) {
+		return nil
+	}
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("read jwks file: %w", err)
+	}
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return fmt.Errorf("parse jwks file %s: %w", f.path, err)
+	}
+	f.keys, f.modTime = keys, st.ModTime()
+	return nil
+}
+
+func parseJWKS(b []byte) (keySet, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return keySet{}, err
+	}
+	ks := keySet{rsa: map[string]*rsa.PublicKey{}, hmac: map[string][]byte{}}
+	for i, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			pub, err := rsaKey(k)
+			if err != nil {
+				return keySet{}, fmt.Errorf("key %d (%s): %w", i, k.Kid, err)
+			}
+			ks.rsa[k.Kid] = pub
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil {
+				return keySet{}, fmt.Errorf("key %d (%s): invalid k: %w", i, k.Kid, err)
+			}
+			ks.hmac[k.Kid] = secret
+		}
+	}
+	if len(ks.rsa) == 0 && len(ks.hmac) == 0 {
+		return keySet{}, errors.New("no usable signing keys")
+	}
+	return ks, nil
+}
+
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid n: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid e: %w", err)
+	}
+	if len(n) == 0 || len(e) == 0 {
+		return nil, errors.New("missing modulus or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
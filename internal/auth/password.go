@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/tfenng/scaffold/internal/config"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Hasher hashes passwords with the configured algorithm and verifies both
+// argon2id (PHC string format) and bcrypt hashes.
+type Hasher struct {
+	c config.Password
+}
+
+func NewHasher(c config.Password) *Hasher { return &Hasher{c: c} }
+
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.c.Algorithm == "bcrypt" {
+		b, err := bcrypt.GenerateFromPassword([]byte(password), h.c.BcryptCost)
+		return string(b), err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt,
+		uint32(h.c.Argon2Iterations), uint32(h.c.Argon2MemoryKiB), uint8(h.c.Argon2Parallelism), 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.c.Argon2MemoryKiB, h.c.Argon2Iterations, h.c.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches hash, and whether hash should be
+// replaced because it uses another algorithm or weaker parameters.
+func (h *Hasher) Verify(hash, password string) (ok, rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		var version, memory, iterations, parallelism int
+		parts := strings.Split(hash, "$")
+		if len(parts) != 6 {
+			return false, false, ErrUnknownHash
+		}
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return false, false, ErrUnknownHash
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism); err != nil {
+			return false, false, ErrUnknownHash
+		}
+		salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+		if err != nil {
+			return false, false, ErrUnknownHash
+		}
+		want, err := base64.RawStdEncoding.DecodeString(parts[5])
+		if err != nil {
+			return false, false, ErrUnknownHash
+		}
+		got := argon2.IDKey([]byte(password), salt, uint32(iterations), uint32(memory), uint8(parallelism), uint32(len(want)))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			return false, false, nil
+		}
+		rehash = h.c.Algorithm != "argon2id" ||
+			memory != h.c.Argon2MemoryKiB || iterations != h.c.Argon2Iterations || parallelism != h.c.Argon2Parallelism
+		return true, rehash, nil
+
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, err
+		}
+		cost, _ := bcrypt.Cost([]byte(hash))
+		return true, h.c.Algorithm != "bcrypt" || cost != h.c.BcryptCost, nil
+
+	default:
+		return false, false, ErrUnknownHash
+	}
+}
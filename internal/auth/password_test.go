@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tfenng/scaffold/internal/config"
+)
+
+func TestHasher(t *testing.T) {
+	// Small parameters keep the test fast; production values come from config.
+	argon := config.Password{Algorithm: "argon2id", Argon2MemoryKiB: 8 * 1024, Argon2Iterations: 1, Argon2Parallelism: 1, BcryptCost: 10}
+	bcrypt := argon
+	bcrypt.Algorithm = "bcrypt"
+	stronger := argon
+	stronger.Argon2Iterations = 2
+
+	tests := []struct {
+		name       string
+		hashWith   config.Password
+		verifyWith config.Password
+		wantRehash bool
+	}{
+		{name: "argon2id", hashWith: argon, verifyWith: argon},
+		{name: "bcrypt", hashWith: bcrypt, verifyWith: bcrypt},
+		{name: "bcrypt upgraded to argon2id", hashWith: bcrypt, verifyWith: argon, wantRehash: true},
+		{name: "argon2id parameters raised", hashWith: argon, verifyWith: stronger, wantRehash: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			hash, err := NewHasher(tc.hashWith).Hash("correct horse battery")
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := NewHasher(tc.verifyWith)
+
+			ok, rehash, err := h.Verify(hash, "correct horse battery")
+			if err != nil || !ok || rehash != tc.wantRehash {
+				t.Fatalf("verify: ok=%v rehash=%v err=%v", ok, rehash, err)
+			}
+			if ok, _, err := h.Verify(hash, "wrong horse battery"); err != nil || ok {
+				t.Fatalf("wrong password accepted: ok=%v err=%v", ok, err)
+			}
+		})
+	}
+
+	if _, _, err := NewHasher(argon).Verify("plaintext", "plaintext"); err != ErrUnknownHash {
+		t.Fatalf("expected ErrUnknownHash, got %v", err)
+	}
+}
+
+func TestIssuerRoundTrip(t *testing.T) {
+	c := config.Auth{
+		Issuer: "scaffold", Audience: "scaffold-api", HS256Secret: secret, AccessTokenTTL: time.Minute,
+	}
+	token, _, err := NewIssuer(c).Issue(Principal{Subject: "7", Roles: []string{RoleAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := v.Verify(token)
+	if err != nil || p.Subject != "7" || len(p.Roles) != 1 || p.Roles[0] != RoleAdmin {
+		t.Fatalf("round trip: p=%+v err=%v", p, err)
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"slices"
+
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+// Permission names an operation, "<resource>:<action>".
+type Permission string
+
+const (
+	PermUsersRead   Permission = "users:read"
+	PermUsersWrite  Permission = "users:write"
+	PermUsersDelete Permission = "users:delete"
+	// PermUsersReadDeleted lists soft-deleted users alongside live ones.
+	PermUsersReadDeleted Permission = "users:read_deleted"
+	// PermAPIKeysManage creates, lists and revokes API keys.
+	PermAPIKeysManage Permission = "api_keys:manage"
+)
+
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	// RoleSystem is held by in-process callers such as jobs and CLIs.
+	RoleSystem = "system"
+	// RoleService is held by API key callers; the key's scopes decide what
+	// they may actually do.
+	RoleService = "service"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin:   {PermUsersRead, PermUsersWrite, PermUsersDelete, PermUsersReadDeleted, PermAPIKeysManage},
+	RoleSupport: {PermUsersRead},
+	RoleSystem:  {PermUsersRead, PermUsersWrite, PermUsersDelete, PermUsersReadDeleted, PermAPIKeysManage},
+	RoleService: {PermUsersRead, PermUsersWrite, PermUsersDelete},
+}
+
+// KeyScopes are the permissions an API key may be granted.
+var KeyScopes = []Permission{PermUsersRead, PermUsersWrite, PermUsersDelete}
+
+// System is the principal for non-HTTP callers that act on the service's
+// own behalf.
+func System() Principal {
+	return Principal{Subject: "system", Roles: []string{RoleSystem}}
+}
+
+// Can reports whether one of p's roles grants perm. A principal that carries
+// scopes is further limited to those scopes, so a token can be narrowed but
+// never widened beyond its roles.
+func (p Principal) Can(perm Permission) bool {
+	if len(p.Scopes) > 0 && !slices.Contains(p.Scopes, string(perm)) {
+		return false
+	}
+	for _, role := range p.Roles {
+		if slices.Contains(rolePermissions[role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// Require returns UNAUTHENTICATED when ctx has no principal and FORBIDDEN
+// when the principal lacks perm.
+func Require(ctx context.Context, perm Permission) error {
+	p, ok := PrincipalFrom(ctx)
+	if !ok {
+		return domain.Unauthenticated("authentication required")
+	}
+	if !p.Can(perm) {
+		return domain.Forbidden("missing permission " + string(perm))
+	}
+	return nil
+}
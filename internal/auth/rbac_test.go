@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *Principal
+		perm      Permission
+		wantCode  domain.Code
+	}{
+		{name: "anonymous", perm: PermUsersRead, wantCode: domain.CodeUnauthenticated},
+		{name: "no roles", principal: &Principal{Subject: "1"}, perm: PermUsersRead, wantCode: domain.CodeForbidden},
+		{name: "support reads", principal: &Principal{Subject: "1", Roles: []string{RoleSupport}}, perm: PermUsersRead},
+		{name: "support cannot write", principal: &Principal{Subject: "1", Roles: []string{RoleSupport}}, perm: PermUsersWrite, wantCode: domain.CodeForbidden},
+		{name: "admin deletes", principal: &Principal{Subject: "1", Roles: []string{RoleAdmin}}, perm: PermUsersDelete},
+		{
+			name:      "scope narrows admin",
+			principal: &Principal{Subject: "1", Roles: []string{RoleAdmin}, Scopes: []string{"users:read"}},
+			perm:      PermUsersWrite,
+			wantCode:  domain.CodeForbidden,
+		},
+		{
+			name:      "scope cannot widen support",
+			principal: &Principal{Subject: "1", Roles: []string{RoleSupport}, Scopes: []string{"users:write"}},
+			perm:      PermUsersWrite,
+			wantCode:  domain.CodeForbidden,
+		},
+		{name: "system", principal: ptr(System()), perm: PermUsersDelete},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.principal != nil {
+				ctx = WithPrincipal(ctx, *tc.principal)
+			}
+			err := Require(ctx, tc.perm)
+			if tc.wantCode == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var ae *domain.AppError
+			if !errors.As(err, &ae) || ae.Code != tc.wantCode {
+				t.Fatalf("got %v, want %s", err, tc.wantCode)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
@@ -0,0 +1,49 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+// APIKeyCache holds API key records by prefix. Records carry only the key
+// hash, never the key itself.
+type APIKeyCache struct {
+	Rdb *redis.Client
+	TTL time.Duration
+}
+
+func NewAPIKeyCache(rdb *redis.Client, ttl time.Duration) *APIKeyCache {
+	return &APIKeyCache{Rdb: rdb, TTL: ttl}
+}
+
+const apiKeyPattern = "apikey:v1:prefix:*"
+
+func (c *APIKeyCache) key(prefix string) string { return "apikey:v1:prefix:" + prefix }
+
+func (c *APIKeyCache) Get(ctx context.Context, prefix string) (sqlc.ApiKey, bool, error) {
+	val, err := c.Rdb.Get(ctx, c.key(prefix)).Result()
+	if err == redis.Nil {
+		return sqlc.ApiKey{}, false, nil
+	}
+	if err != nil {
+		return sqlc.ApiKey{}, false, err
+	}
+	var k sqlc.ApiKey
+	if err := json.Unmarshal([]byte(val), &k); err != nil {
+		return sqlc.ApiKey{}, false, err
+	}
+	return k, true, nil
+}
+
+func (c *APIKeyCache) Set(ctx context.Context, k sqlc.ApiKey) error {
+	b, _ := json.Marshal(k)
+	return c.Rdb.Set(ctx, c.key(k.Prefix), b, c.TTL).Err()
+}
+
+func (c *APIKeyCache) Del(ctx context.Context, prefix string) error {
+	return c.Rdb.Del(ctx, c.key(prefix)).Err()
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tfenng/scaffold/internal/idempotency"
+)
+
+// IdempotencyCache is the Redis idempotency.Backend. A key holds either an
+// in-flight marker (SET NX with the lock TTL) or the completed response.
+type IdempotencyCache struct{ Rdb *redis.Client }
+
+func NewIdempotencyCache(rdb *redis.Client) *IdempotencyCache {
+	return &IdempotencyCache{Rdb: rdb}
+}
+
+type idempotencyRecord struct {
+	Hash     string                `json:"hash"`
+	Response *idempotency.Response `json:"response,omitempty"`
+}
+
+func (c *IdempotencyCache) key(key string) string { return "idem:v1:" + key }
+
+// releaseInFlight deletes the key only while it still holds this request's
+// in-flight marker, so an expired lock taken over by a retry is left alone.
+var releaseInFlight = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+  return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (c *IdempotencyCache) Claim(ctx context.Context, key, hash string, lock time.Duration) (*idempotency.Response, error) {
+	marker, _ := json.Marshal(idempotencyRecord{Hash: hash})
+	ok, err := c.Rdb.SetNX(ctx, c.key(key), marker, lock).Result()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return nil, nil
+	}
+
+	val, err := c.Rdb.Get(ctx, c.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		// The holder expired between SET NX and GET; let the client retry.
+		return nil, idempotency.ErrInFlight
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec idempotencyRecord
+	if err := json.Unmarshal(val, &rec); err != nil {
+		return nil, err
+	}
+	switch {
+	case rec.Hash != hash:
+		return nil, idempotency.ErrMismatch
+	case rec.Response == nil:
+		return nil, idempotency.ErrInFlight
+	}
+	return rec.Response, nil
+}
+
+func (c *IdempotencyCache) Complete(ctx context.Context, key, hash string, resp idempotency.Response, ttl time.Duration) error {
+	b, err := json.Marshal(idempotencyRecord{Hash: hash, Response: &resp})
+	if err != nil {
+		return err
+	}
+	return c.Rdb.Set(ctx, c.key(key), b, ttl).Err()
+}
+
+func (c *IdempotencyCache) Release(ctx context.Context, key, hash string) error {
+	marker, _ := json.Marshal(idempotencyRecord{Hash: hash})
+	return releaseInFlight.Run(ctx, c.Rdb, []string{c.key(key)}, marker).Err()
+}
@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/tfenng/scaffold/internal/config"
+	"github.com/tfenng/scaffold/internal/idempotency"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := NewRedis(config.Redis{Addr: mr.Addr(), DialTimeout: time.Second, ReadTimeout: time.Second, WriteTimeout: time.Second})
+	defer rdb.Close()
+
+	ctx := context.Background()
+	c := NewIdempotencyCache(rdb)
+
+	if resp, err := c.Claim(ctx, "k1", "h1", time.Minute); err != nil || resp != nil {
+		t.Fatalf("first claim: resp=%v err=%v", resp, err)
+	}
+	if _, err := c.Claim(ctx, "k1", "h1", time.Minute); !errors.Is(err, idempotency.ErrInFlight) {
+		t.Fatalf("want ErrInFlight, got %v", err)
+	}
+	if _, err := c.Claim(ctx, "k1", "h2", time.Minute); !errors.Is(err, idempotency.ErrMismatch) {
+		t.Fatalf("want ErrMismatch, got %v", err)
+	}
+
+	want := idempotency.Response{Status: 201, Header: map[string]string{"ETag": `"1"`}, Body: []byte(`{"id":1}`)}
+	if err := c.Complete(ctx, "k1", "h1", want, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.Claim(ctx, "k1", "h1", time.Minute)
+	if err != nil || got == nil || got.Status != 201 || string(got.Body) != `{"id":1}` || got.Header["ETag"] != `"1"` {
+		t.Fatalf("replay: got=%+v err=%v", got, err)
+	}
+	// Releasing a completed key must not drop the stored response.
+	if err := c.Release(ctx, "k1", "h1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Claim(ctx, "k1", "h1", time.Minute); err != nil || got == nil {
+		t.Fatalf("completed key was released: got=%v err=%v", got, err)
+	}
+
+	if _, err := c.Claim(ctx, "k2", "h1", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Release(ctx, "k2", "h1"); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := c.Claim(ctx, "k2", "h1", time.Minute); err != nil || resp != nil {
+		t.Fatalf("released key not claimable: resp=%v err=%v", resp, err)
+	}
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginThrottle counts failed logins per identifier (uid or email) in a
+// fixed window that starts at the first failure.
+type LoginThrottle struct {
+	Rdb         *redis.Client
+	MaxAttempts int
+	Window      time.Duration
+}
+
+func NewLoginThrottle(rdb *redis.Client, maxAttempts int, window time.Duration) *LoginThrottle {
+	return &LoginThrottle{Rdb: rdb, MaxAttempts: maxAttempts, Window: window}
+}
+
+func (t *LoginThrottle) key(identifier string) string {
+	return "login:v1:fail:" + strings.ToLower(strings.TrimSpace(identifier))
+}
+
+// Blocked reports whether identifier is locked out and for how long.
+func (t *LoginThrottle) Blocked(ctx context.Context, identifier string) (bool, time.Duration, error) {
+	key := t.key(identifier)
+	n, err := t.Rdb.Get(ctx, key).Int()
+	if errors.Is(err, redis.Nil) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	if n < t.MaxAttempts {
+		return false, 0, nil
+	}
+	ttl, err := t.Rdb.PTTL(ctx, key).Result()
+	if err != nil {
+		return true, t.Window, err
+	}
+	return true, ttl, nil
+}
+
+func (t *LoginThrottle) Fail(ctx context.Context, identifier string) error {
+	key := t.key(identifier)
+	n, err := t.Rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		return t.Rdb.Expire(ctx, key, t.Window).Err()
+	}
+	return nil
+}
+
+func (t *LoginThrottle) Reset(ctx context.Context, identifier string) error {
+	return t.Rdb.Del(ctx, t.key(identifier)).Err()
+}
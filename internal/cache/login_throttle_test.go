@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/tfenng/scaffold/internal/config"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := NewRedis(config.Redis{Addr: mr.Addr(), DialTimeout: time.Second, ReadTimeout: time.Second, WriteTimeout: time.Second})
+	defer rdb.Close()
+
+	ctx := context.Background()
+	th := NewLoginThrottle(rdb, 3, time.Minute)
+
+	for i := range 3 {
+		if blocked, _, err := th.Blocked(ctx, "Alice@Example.com"); err != nil || blocked {
+			t.Fatalf("attempt %d: blocked=%v err=%v", i, blocked, err)
+		}
+		if err := th.Fail(ctx, "alice@example.com "); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocked, retry, err := th.Blocked(ctx, "alice@example.com")
+	if err != nil || !blocked || retry <= 0 || retry > time.Minute {
+		t.Fatalf("expected lockout: blocked=%v retry=%s err=%v", blocked, retry, err)
+	}
+
+	mr.FastForward(time.Minute)
+	if blocked, _, _ := th.Blocked(ctx, "alice@example.com"); blocked {
+		t.Fatal("lockout should expire with the window")
+	}
+
+	_ = th.Fail(ctx, "bob")
+	if err := th.Reset(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("login:v1:fail:bob") {
+		t.Fatal("reset should clear the counter")
+	}
+}
@@ -2,17 +2,20 @@ package cache
 
 import (
 	"context"
-	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"github.com/tfenng/scaffold/internal/config"
 )
 
-func NewRedis(addr string) *redis.Client {
+func NewRedis(c config.Redis) *redis.Client {
 	return redis.NewClient(&redis.Options{
-		Addr:         addr,
-		DialTimeout:  2 * time.Second,
-		ReadTimeout:  600 * time.Millisecond,
-		WriteTimeout: 600 * time.Millisecond,
+		Addr:         c.Addr,
+		Password:     c.Password,
+		DB:           c.DB,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
 	})
 }
 
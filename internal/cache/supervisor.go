@@ -0,0 +1,331 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+// UserStore is the cache surface UserService depends on.
+type UserStore interface {
+	Get(ctx context.Context, id int64) (sqlc.User, bool, error)
+	Set(ctx context.Context, u sqlc.User) error
+	Del(ctx context.Context, id int64) error
+}
+
+var ErrCircuitOpen = errors.New("cache: circuit open")
+
+type State string
+
+const (
+	StateClosed State = "closed" // Redis in use
+	StateOpen   State = "open"   // Redis skipped, waiting for a successful probe
+)
+
+// maxPending bounds the keys remembered for invalidation while open; past it
+// the whole cached keyspace is dropped on recovery instead.
+const maxPending = 10000
+
+// cachedKeyPatterns are the keyspaces dropped when pending overflows.
+var cachedKeyPatterns = []string{userKeyPattern, apiKeyPattern}
+
+// Supervisor wraps a UserCache with a circuit breaker. After threshold
+// consecutive Redis errors it stops calling Redis, so requests no longer wait
+// on ReadTimeout, and Run probes with PING every probeInterval until Redis is
+// back. Writes skipped while open are turned into deletes that run before
+// caching resumes, so no stale user outlives an outage. APIKeys puts the API
+// key cache behind the same circuit, as both live in the same Redis.
+type Supervisor struct {
+	cache         *UserCache
+	threshold     int
+	probeInterval time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	// pending holds the Redis keys to delete before the circuit closes.
+	pending  map[string]struct{}
+	overflow bool
+}
+
+func NewSupervisor(c *UserCache, threshold int, probeInterval time.Duration) *Supervisor {
+	return &Supervisor{
+		cache:         c,
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		state:         StateClosed,
+		pending:       map[string]struct{}{},
+	}
+}
+
+func (s *Supervisor) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Supervisor) Get(ctx context.Context, id int64) (sqlc.User, bool, error) {
+	if !s.allow() {
+		return sqlc.User{}, false, ErrCircuitOpen
+	}
+	u, ok, err := s.cache.Get(ctx, id)
+	s.record(ctx, err)
+	return u, ok, err
+}
+
+func (s *Supervisor) Set(ctx context.Context, u sqlc.User) error {
+	if !s.allow() {
+		s.remember(s.cache.key(u.ID))
+		return ErrCircuitOpen
+	}
+	err := s.cache.Set(ctx, u)
+	s.record(ctx, err)
+	if err != nil {
+		s.remember(s.cache.key(u.ID))
+	}
+	return err
+}
+
+func (s *Supervisor) Del(ctx context.Context, id int64) error {
+	if !s.allow() {
+		s.remember(s.cache.key(id))
+		return ErrCircuitOpen
+	}
+	err := s.cache.Del(ctx, id)
+	s.record(ctx, err)
+	if err != nil {
+		s.remember(s.cache.key(id))
+	}
+	return err
+}
+
+// Run probes Redis while the circuit is open until ctx is cancelled.
+func (s *Supervisor) Run(ctx context.Context) {
+	t := time.NewTicker(s.probeInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if s.State() == StateOpen {
+				s.probe(ctx)
+			}
+		}
+	}
+}
+
+// Trip opens the circuit immediately, e.g. when Redis is unreachable at boot.
+func (s *Supervisor) Trip(reason error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open(reason)
+}
+
+func (s *Supervisor) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state == StateClosed
+}
+
+func (s *Supervisor) record(ctx context.Context, err error) {
+	// A caller giving up is not a Redis failure.
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		s.failures = 0
+		return
+	}
+	s.failures++
+	if s.state == StateClosed && s.failures >= s.threshold {
+		s.open(err)
+	}
+}
+
+func (s *Supervisor) open(reason error) {
+	if s.state == StateOpen {
+		return
+	}
+	s.state = StateOpen
+	slog.Warn("redis circuit opened", "cache_mode", "no-cache", "reason", reason)
+}
+
+func (s *Supervisor) remember(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) >= maxPending {
+		s.overflow = true
+		return
+	}
+	s.pending[key] = struct{}{}
+}
+
+func (s *Supervisor) probe(ctx context.Context) {
+	if err := s.cache.Rdb.Ping(ctx).Err(); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	pending, overflow := s.pending, s.overflow
+	s.pending, s.overflow = map[string]struct{}{}, false
+	s.mu.Unlock()
+
+	if err := s.invalidate(ctx, pending, overflow); err != nil {
+		slog.Warn("redis probe succeeded but invalidation failed, staying open", "err", err)
+		s.mu.Lock()
+		for key := range pending {
+			s.pending[key] = struct{}{}
+		}
+		s.overflow = s.overflow || overflow
+		s.mu.Unlock()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Deletes skipped while we were invalidating are handled on the next probe.
+	if len(s.pending) > 0 || s.overflow {
+		return
+	}
+	s.state = StateClosed
+	s.failures = 0
+	slog.Info("redis circuit closed", "cache_mode", "redis")
+}
+
+func (s *Supervisor) invalidate(ctx context.Context, pending map[string]struct{}, overflow bool) error {
+	if overflow {
+		for _, pattern := range cachedKeyPatterns {
+			if err := s.flush(ctx, pattern); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	return s.cache.Rdb.Del(ctx, keys...).Err()
+}
+
+func (s *Supervisor) flush(ctx context.Context, pattern string) error {
+	iter := s.cache.Rdb.Scan(ctx, 0, pattern, 500).Iterator()
+	var batch []string
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 500 {
+			if err := s.cache.Rdb.Del(ctx, batch...).Err(); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if len(batch) > 0 {
+		return s.cache.Rdb.Del(ctx, batch...).Err()
+	}
+	return nil
+}
+
+// APIKeys returns c behind s's circuit: while it is open API key lookups go
+// straight to Postgres, and skipped writes and deletes are replayed as
+// deletes before caching resumes, so a key revoked during an outage cannot
+// come back from the cache.
+func (s *Supervisor) APIKeys(c *APIKeyCache) *APIKeySupervisor {
+	return &APIKeySupervisor{s: s, cache: c}
+}
+
+// APIKeySupervisor is an APIKeyCache guarded by a Supervisor.
+type APIKeySupervisor struct {
+	s     *Supervisor
+	cache *APIKeyCache
+}
+
+func (a *APIKeySupervisor) Get(ctx context.Context, prefix string) (sqlc.ApiKey, bool, error) {
+	if !a.s.allow() {
+		return sqlc.ApiKey{}, false, ErrCircuitOpen
+	}
+	k, ok, err := a.cache.Get(ctx, prefix)
+	a.s.record(ctx, err)
+	return k, ok, err
+}
+
+func (a *APIKeySupervisor) Set(ctx context.Context, k sqlc.ApiKey) error {
+	if !a.s.allow() {
+		a.s.remember(a.cache.key(k.Prefix))
+		return ErrCircuitOpen
+	}
+	err := a.cache.Set(ctx, k)
+	a.s.record(ctx, err)
+	if err != nil {
+		a.s.remember(a.cache.key(k.Prefix))
+	}
+	return err
+}
+
+func (a *APIKeySupervisor) Del(ctx context.Context, prefix string) error {
+	if !a.s.allow() {
+		a.s.remember(a.cache.key(prefix))
+		return ErrCircuitOpen
+	}
+	err := a.cache.Del(ctx, prefix)
+	a.s.record(ctx, err)
+	if err != nil {
+		a.s.remember(a.cache.key(prefix))
+	}
+	return err
+}
+
+// LoginThrottle returns t behind s's circuit. While it is open the throttle
+// reports nobody as blocked and drops failures: logins fail open rather than
+// locking every account out for the length of a Redis outage. Password
+// hashing still bounds guessing in that window.
+func (s *Supervisor) LoginThrottle(t *LoginThrottle) *LoginThrottleSupervisor {
+	return &LoginThrottleSupervisor{s: s, throttle: t}
+}
+
+// LoginThrottleSupervisor is a LoginThrottle guarded by a Supervisor.
+type LoginThrottleSupervisor struct {
+	s        *Supervisor
+	throttle *LoginThrottle
+}
+
+func (l *LoginThrottleSupervisor) Blocked(ctx context.Context, identifier string) (bool, time.Duration, error) {
+	if !l.s.allow() {
+		return false, 0, ErrCircuitOpen
+	}
+	blocked, retry, err := l.throttle.Blocked(ctx, identifier)
+	l.s.record(ctx, err)
+	return blocked, retry, err
+}
+
+func (l *LoginThrottleSupervisor) Fail(ctx context.Context, identifier string) error {
+	if !l.s.allow() {
+		return ErrCircuitOpen
+	}
+	err := l.throttle.Fail(ctx, identifier)
+	l.s.record(ctx, err)
+	return err
+}
+
+func (l *LoginThrottleSupervisor) Reset(ctx context.Context, identifier string) error {
+	if !l.s.allow() {
+		return ErrCircuitOpen
+	}
+	err := l.throttle.Reset(ctx, identifier)
+	l.s.record(ctx, err)
+	return err
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/tfenng/scaffold/internal/config"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+func TestSupervisorTripsAndRecovers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := NewRedis(config.Redis{
+		Addr:         mr.Addr(),
+		DialTimeout:  100 * time.Millisecond,
+		ReadTimeout:  100 * time.Millisecond,
+		WriteTimeout: 100 * time.Millisecond,
+	})
+	defer rdb.Close()
+
+	ctx := context.Background()
+	s := NewSupervisor(NewUserCache(rdb, time.Minute), 2, time.Hour)
+
+	u := sqlc.User{ID: 7, Uid: "u7", Name: "before"}
+	if err := s.Set(ctx, u); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	addr := mr.Addr()
+	mr.Close()
+	for range 2 {
+		if _, _, err := s.Get(ctx, u.ID); err == nil {
+			t.Fatal("expected redis error")
+		}
+	}
+	if got := s.State(); got != StateOpen {
+		t.Fatalf("expected open circuit, got %s", got)
+	}
+	if _, _, err := s.Get(ctx, u.ID); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen while open, got %v", err)
+	}
+
+	// The user changes while Redis is skipped; the cached copy must not survive recovery.
+	u.Name = "after"
+	_ = s.Set(ctx, u)
+
+	if err := mr.StartAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	mr.Set("user:v2:id:7", `{"ID":7,"Uid":"u7","Name":"before"}`)
+
+	s.probe(ctx)
+	if got := s.State(); got != StateClosed {
+		t.Fatalf("expected closed circuit after probe, got %s", got)
+	}
+	if _, ok, err := s.Get(ctx, u.ID); err != nil || ok {
+		t.Fatalf("expected stale entry to be invalidated, ok=%v err=%v", ok, err)
+	}
+}
+
+func TestSupervisorAPIKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := NewRedis(config.Redis{
+		Addr:         mr.Addr(),
+		DialTimeout:  100 * time.Millisecond,
+		ReadTimeout:  100 * time.Millisecond,
+		WriteTimeout: 100 * time.Millisecond,
+	})
+	defer rdb.Close()
+
+	ctx := context.Background()
+	s := NewSupervisor(NewUserCache(rdb, time.Minute), 2, time.Hour)
+	keys := s.APIKeys(NewAPIKeyCache(rdb, time.Minute))
+
+	k := sqlc.ApiKey{ID: 3, Prefix: "abcd1234"}
+	if err := keys.Set(ctx, k); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	// Both caches share one circuit: user cache failures trip it for API keys.
+	addr := mr.Addr()
+	mr.Close()
+	for range 2 {
+		_, _, _ = s.Get(ctx, 1)
+	}
+	start := time.Now()
+	if _, _, err := keys.Get(ctx, k.Prefix); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen while open, got %v", err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Fatalf("open circuit still waited on redis for %s", d)
+	}
+	throttle := s.LoginThrottle(NewLoginThrottle(rdb, 1, time.Minute))
+	if blocked, _, err := throttle.Blocked(ctx, "alice"); blocked || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open circuit should fail open, blocked=%v err=%v", blocked, err)
+	}
+
+	// The key is revoked during the outage; the cached copy must not survive recovery.
+	_ = keys.Del(ctx, k.Prefix)
+
+	if err := mr.StartAddr(addr); err != nil {
+		t.Fatal(err)
+	}
+	mr.Set("apikey:v1:prefix:abcd1234", `{"ID":3,"Prefix":"abcd1234"}`)
+
+	s.probe(ctx)
+	if _, ok, err := keys.Get(ctx, k.Prefix); err != nil || ok {
+		t.Fatalf("expected revoked key to be invalidated, ok=%v err=%v", ok, err)
+	}
+}
@@ -15,11 +15,13 @@ type UserCache struct {
 	TTL time.Duration
 }
 
-func NewUserCache(rdb *redis.Client) *UserCache {
-	return &UserCache{Rdb: rdb, TTL: 5 * time.Minute}
+func NewUserCache(rdb *redis.Client, ttl time.Duration) *UserCache {
+	return &UserCache{Rdb: rdb, TTL: ttl}
 }
 
-func (c *UserCache) key(id int64) string { return fmt.Sprintf("user:v1:id:%d", id) }
+const userKeyPattern = "user:v2:id:*"
+
+func (c *UserCache) key(id int64) string { return fmt.Sprintf("user:v2:id:%d", id) }
 
 func (c *UserCache) Get(ctx context.Context, id int64) (sqlc.User, bool, error) {
 	val, err := c.Rdb.Get(ctx, c.key(id)).Result()
@@ -0,0 +1,721 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Config is the typed runtime configuration of the API server.
+//
+// Values are resolved in order of increasing precedence: built-in defaults,
+// the optional file named by CONFIG_FILE (YAML or TOML), then environment
+// variables (including those loaded from .env). File keys are nested and map
+// onto env names, e.g. postgres.max_conns <-> POSTGRES_MAX_CONNS.
+type Config struct {
+	HTTP        HTTP
+	Postgres    Postgres
+	Redis       Redis
+	Cache       Cache
+	Health      Health
+	Log         Log
+	Tracing     Tracing
+	Auth        Auth
+	Users       Users
+	Idempotency Idempotency
+}
+
+type HTTP struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// ShutdownTimeout bounds the whole drain: in-flight requests, background work and closers.
+	ShutdownTimeout time.Duration
+	// ErrorFormat is "json" (code/message body) or "problem" (RFC 7807).
+	ErrorFormat string
+}
+
+type Postgres struct {
+	// DSN, when set, is used verbatim and the discrete connection fields are ignored.
+	DSN             string
+	Host            string
+	Port            int
+	DB              string
+	User            string
+	Password        string
+	SSLMode         string
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+}
+
+// ConnString returns DSN if set, otherwise a URL assembled from the discrete fields.
+func (p Postgres) ConnString() string {
+	if p.DSN != "" {
+		return p.DSN
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(p.User, p.Password),
+		Host:     net.JoinHostPort(p.Host, strconv.Itoa(p.Port)),
+		Path:     "/" + p.DB,
+		RawQuery: url.Values{"sslmode": {p.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+type Redis struct {
+	Addr         string
+	Password     string
+	DB           int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+type Cache struct {
+	UserTTL time.Duration
+	// APIKeyTTL bounds how long a revoked key can still be served from
+	// another instance's cache.
+	APIKeyTTL time.Duration
+	// BreakerThreshold consecutive Redis errors open the circuit; while open
+	// Redis is probed every ProbeInterval.
+	BreakerThreshold int
+	ProbeInterval    time.Duration
+}
+
+type Log struct {
+	Level  string // debug, info, warn, error
+	Format string // json, text
+}
+
+type Tracing struct {
+	// Exporter is "none", "stdout" or "otlp".
+	Exporter    string
+	ServiceName string
+	SampleRatio float64
+	// File receives stdout-exporter spans instead of stdout when set.
+	File         string
+	OTLPEndpoint string
+	OTLPInsecure bool
+}
+
+type Auth struct {
+	// Enabled guards the private routes with bearer-token authentication.
+	Enabled bool
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// ClockSkew is the leeway applied to exp, nbf and iat.
+	ClockSkew time.Duration
+	// HS256Secret verifies HS256 tokens that carry no kid.
+	HS256Secret string
+	// JWKSFile is a local JWKS document with RSA (RS256) and oct (HS256) keys
+	// selected by kid. It is re-read when it changes, at most every
+	// JWKSCheckInterval.
+	JWKSFile          string
+	JWKSCheckInterval time.Duration
+
+	// AccessTokenTTL and RefreshTokenTTL apply to tokens issued to local
+	// accounts; issuing requires HS256Secret.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// LoginMaxAttempts failed logins per uid/email lock that identifier out
+	// for LoginLockout.
+	LoginMaxAttempts int
+	LoginLockout     time.Duration
+	// RegistrationEnabled exposes the public POST /auth/register. Off by
+	// default: self-registration writes to the admin-managed users table.
+	RegistrationEnabled bool
+	Password            Password
+}
+
+type Password struct {
+	// Algorithm hashes new passwords: "argon2id" or "bcrypt". Hashes of the
+	// other algorithm, or with outdated parameters, are upgraded on login.
+	Algorithm         string
+	MinLength         int
+	Argon2MemoryKiB   int
+	Argon2Iterations  int
+	Argon2Parallelism int
+	BcryptCost        int
+}
+
+type Users struct {
+	// PurgeRetention is how long soft-deleted users can be restored before
+	// the purge job removes them for good; 0 disables purging.
+	PurgeRetention time.Duration
+	PurgeInterval  time.Duration
+	// RequireIfMatch makes PUT and DELETE /users/:id fail with 428 unless
+	// they carry an If-Match header.
+	RequireIfMatch bool
+	// CursorSecret signs list cursors; all instances must share it. When
+	// empty a random secret is used and cursors die with the process.
+	CursorSecret string
+	// SearchSimilarity is how close (0-1, trigram word similarity) a
+	// misspelt search term must be to still match.
+	SearchSimilarity float64
+	// ImportMaxRows caps the rows of one POST /users:import.
+	ImportMaxRows int
+}
+
+type Idempotency struct {
+	// TTL is how long a completed response is replayed for its key.
+	TTL time.Duration
+	// LockTTL bounds how long a request that never finishes (e.g. the
+	// process died) blocks retries with the same key.
+	LockTTL time.Duration
+	// MaxBodyBytes caps the request body read to fingerprint a keyed
+	// request; larger bodies get 413.
+	MaxBodyBytes int
+}
+
+type Health struct {
+	// Timeout bounds a single /readyz evaluation across all dependency checks.
+	Timeout time.Duration
+}
+
+func Default() Config {
+	return Config{
+		HTTP: HTTP{
+			Addr:              ":8080",
+			ReadTimeout:       10 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+			ErrorFormat:       "json",
+		},
+		Postgres: Postgres{
+			Host:            "localhost",
+			Port:            5432,
+			DB:              "app",
+			User:            "xmap",
+			Password:        "xmap",
+			SSLMode:         "disable",
+			MaxConns:        20,
+			MinConns:        2,
+			MaxConnLifetime: 30 * time.Minute,
+		},
+		Redis: Redis{
+			Addr:         "127.0.0.1:6379",
+			DialTimeout:  2 * time.Second,
+			ReadTimeout:  600 * time.Millisecond,
+			WriteTimeout: 600 * time.Millisecond,
+		},
+		Cache: Cache{
+			UserTTL:          5 * time.Minute,
+			APIKeyTTL:        time.Minute,
+			BreakerThreshold: 5,
+			ProbeInterval:    5 * time.Second,
+		},
+		Health: Health{Timeout: 2 * time.Second},
+		Log:    Log{Level: "info", Format: "json"},
+		Tracing: Tracing{
+			Exporter:     "none",
+			ServiceName:  "scaffold-api",
+			SampleRatio:  1,
+			OTLPEndpoint: "localhost:4318",
+		},
+		Auth: Auth{
+			Enabled:           true,
+			ClockSkew:         30 * time.Second,
+			JWKSCheckInterval: time.Minute,
+			AccessTokenTTL:    15 * time.Minute,
+			RefreshTokenTTL:   30 * 24 * time.Hour,
+			LoginMaxAttempts:  5,
+			LoginLockout:      15 * time.Minute,
+			Password: Password{
+				Algorithm:         "argon2id",
+				MinLength:         12,
+				Argon2MemoryKiB:   64 * 1024,
+				Argon2Iterations:  3,
+				Argon2Parallelism: 2,
+				BcryptCost:        12,
+			},
+		},
+		Users: Users{
+			PurgeRetention:   30 * 24 * time.Hour,
+			PurgeInterval:    time.Hour,
+			SearchSimilarity: 0.3,
+			ImportMaxRows:    10000,
+		},
+		Idempotency: Idempotency{
+			TTL:          24 * time.Hour,
+			LockTTL:      time.Minute,
+			MaxBodyBytes: 1 << 20,
+		},
+	}
+}
+
+// Problem is a single invalid configuration key.
+type Problem struct {
+	Key string
+	Msg string
+}
+
+// Error aggregates every Problem found while loading, so all bad keys are
+// reported at once instead of one per restart.
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, p := range e.Problems {
+		b.WriteString("\n  ")
+		b.WriteString(p.Key)
+		b.WriteString(": ")
+		b.WriteString(p.Msg)
+	}
+	return b.String()
+}
+
+// Load reads .env (if present), the optional CONFIG_FILE and the process
+// environment, and returns a validated Config.
+func Load() (Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return Config{}, fmt.Errorf("load .env: %w", err)
+	}
+
+	file := map[string]string{}
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	return load(file, os.LookupEnv)
+}
+
+func load(file map[string]string, lookupEnv func(string) (string, bool)) (Config, error) {
+	l := &loader{file: file, lookupEnv: lookupEnv, used: map[string]bool{}}
+	cfg := Default()
+
+	l.str("HTTP_ADDR", &cfg.HTTP.Addr)
+	l.duration("HTTP_READ_TIMEOUT", &cfg.HTTP.ReadTimeout)
+	l.duration("HTTP_READ_HEADER_TIMEOUT", &cfg.HTTP.ReadHeaderTimeout)
+	l.duration("HTTP_WRITE_TIMEOUT", &cfg.HTTP.WriteTimeout)
+	l.duration("HTTP_IDLE_TIMEOUT", &cfg.HTTP.IdleTimeout)
+	l.duration("HTTP_SHUTDOWN_TIMEOUT", &cfg.HTTP.ShutdownTimeout)
+	l.str("HTTP_ERROR_FORMAT", &cfg.HTTP.ErrorFormat)
+
+	l.str("POSTGRES_DSN", &cfg.Postgres.DSN)
+	l.str("POSTGRES_HOST", &cfg.Postgres.Host)
+	l.int("POSTGRES_PORT", &cfg.Postgres.Port)
+	l.str("POSTGRES_DB", &cfg.Postgres.DB)
+	l.str("POSTGRES_USER", &cfg.Postgres.User)
+	l.str("POSTGRES_PASSWORD", &cfg.Postgres.Password)
+	l.str("POSTGRES_SSLMODE", &cfg.Postgres.SSLMode)
+	l.int32("POSTGRES_MAX_CONNS", &cfg.Postgres.MaxConns)
+	l.int32("POSTGRES_MIN_CONNS", &cfg.Postgres.MinConns)
+	l.duration("POSTGRES_MAX_CONN_LIFETIME", &cfg.Postgres.MaxConnLifetime)
+
+	l.str("REDIS_ADDR", &cfg.Redis.Addr)
+	l.str("REDIS_PASSWORD", &cfg.Redis.Password)
+	l.int("REDIS_DB", &cfg.Redis.DB)
+	l.duration("REDIS_DIAL_TIMEOUT", &cfg.Redis.DialTimeout)
+	l.duration("REDIS_READ_TIMEOUT", &cfg.Redis.ReadTimeout)
+	l.duration("REDIS_WRITE_TIMEOUT", &cfg.Redis.WriteTimeout)
+
+	l.duration("CACHE_USER_TTL", &cfg.Cache.UserTTL)
+	l.duration("CACHE_API_KEY_TTL", &cfg.Cache.APIKeyTTL)
+	l.int("CACHE_BREAKER_THRESHOLD", &cfg.Cache.BreakerThreshold)
+	l.duration("CACHE_PROBE_INTERVAL", &cfg.Cache.ProbeInterval)
+
+	l.duration("HEALTH_TIMEOUT", &cfg.Health.Timeout)
+
+	l.str("LOG_LEVEL", &cfg.Log.Level)
+	l.str("LOG_FORMAT", &cfg.Log.Format)
+
+	l.str("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	l.str("TRACING_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	l.float64("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+	l.str("TRACING_FILE", &cfg.Tracing.File)
+	l.str("TRACING_OTLP_ENDPOINT", &cfg.Tracing.OTLPEndpoint)
+	l.bool("TRACING_OTLP_INSECURE", &cfg.Tracing.OTLPInsecure)
+
+	l.bool("AUTH_ENABLED", &cfg.Auth.Enabled)
+	l.str("AUTH_ISSUER", &cfg.Auth.Issuer)
+	l.str("AUTH_AUDIENCE", &cfg.Auth.Audience)
+	l.duration("AUTH_CLOCK_SKEW", &cfg.Auth.ClockSkew)
+	l.str("AUTH_HS256_SECRET", &cfg.Auth.HS256Secret)
+	l.str("AUTH_JWKS_FILE", &cfg.Auth.JWKSFile)
+	l.duration("AUTH_JWKS_CHECK_INTERVAL", &cfg.Auth.JWKSCheckInterval)
+	l.duration("AUTH_ACCESS_TOKEN_TTL", &cfg.Auth.AccessTokenTTL)
+	l.duration("AUTH_REFRESH_TOKEN_TTL", &cfg.Auth.RefreshTokenTTL)
+	l.int("AUTH_LOGIN_MAX_ATTEMPTS", &cfg.Auth.LoginMaxAttempts)
+	l.duration("AUTH_LOGIN_LOCKOUT", &cfg.Auth.LoginLockout)
+	l.bool("AUTH_REGISTRATION_ENABLED", &cfg.Auth.RegistrationEnabled)
+	l.str("AUTH_PASSWORD_ALGORITHM", &cfg.Auth.Password.Algorithm)
+	l.int("AUTH_PASSWORD_MIN_LENGTH", &cfg.Auth.Password.MinLength)
+	l.int("AUTH_ARGON2_MEMORY_KIB", &cfg.Auth.Password.Argon2MemoryKiB)
+	l.int("AUTH_ARGON2_ITERATIONS", &cfg.Auth.Password.Argon2Iterations)
+	l.int("AUTH_ARGON2_PARALLELISM", &cfg.Auth.Password.Argon2Parallelism)
+	l.int("AUTH_BCRYPT_COST", &cfg.Auth.Password.BcryptCost)
+
+	l.duration("USERS_PURGE_RETENTION", &cfg.Users.PurgeRetention)
+	l.duration("USERS_PURGE_INTERVAL", &cfg.Users.PurgeInterval)
+	l.bool("USERS_REQUIRE_IF_MATCH", &cfg.Users.RequireIfMatch)
+	l.str("USERS_CURSOR_SECRET", &cfg.Users.CursorSecret)
+	l.float64("USERS_SEARCH_SIMILARITY", &cfg.Users.SearchSimilarity)
+	l.int("USERS_IMPORT_MAX_ROWS", &cfg.Users.ImportMaxRows)
+
+	l.duration("IDEMPOTENCY_TTL", &cfg.Idempotency.TTL)
+	l.duration("IDEMPOTENCY_LOCK_TTL", &cfg.Idempotency.LockTTL)
+	l.int("IDEMPOTENCY_MAX_BODY_BYTES", &cfg.Idempotency.MaxBodyBytes)
+
+	l.unknownFileKeys()
+	l.validate(cfg)
+
+	if len(l.problems) > 0 {
+		return Config{}, &Error{Problems: l.problems}
+	}
+	return cfg, nil
+}
+
+var sslModes = map[string]bool{
+	"disable": true, "allow": true, "prefer": true,
+	"require": true, "verify-ca": true, "verify-full": true,
+}
+
+func (l *loader) validate(cfg Config) {
+	if cfg.HTTP.Addr == "" {
+		l.fail("HTTP_ADDR", "must not be empty")
+	} else if _, _, err := net.SplitHostPort(cfg.HTTP.Addr); err != nil {
+		l.fail("HTTP_ADDR", "must be host:port, e.g. :8080")
+	}
+	if cfg.HTTP.ReadTimeout < 0 {
+		l.fail("HTTP_READ_TIMEOUT", "must not be negative")
+	}
+	if cfg.HTTP.ReadHeaderTimeout < 0 {
+		l.fail("HTTP_READ_HEADER_TIMEOUT", "must not be negative")
+	}
+	if cfg.HTTP.WriteTimeout < 0 {
+		l.fail("HTTP_WRITE_TIMEOUT", "must not be negative")
+	}
+	if cfg.HTTP.IdleTimeout < 0 {
+		l.fail("HTTP_IDLE_TIMEOUT", "must not be negative")
+	}
+	if cfg.HTTP.ShutdownTimeout <= 0 {
+		l.fail("HTTP_SHUTDOWN_TIMEOUT", "must be positive")
+	}
+	if cfg.HTTP.ErrorFormat != "json" && cfg.HTTP.ErrorFormat != "problem" {
+		l.fail("HTTP_ERROR_FORMAT", "must be json or problem")
+	}
+
+	if cfg.Postgres.DSN == "" {
+		if cfg.Postgres.Host == "" {
+			l.fail("POSTGRES_HOST", "must not be empty")
+		}
+		if cfg.Postgres.Port < 1 || cfg.Postgres.Port > 65535 {
+			l.fail("POSTGRES_PORT", "must be between 1 and 65535")
+		}
+		if cfg.Postgres.DB == "" {
+			l.fail("POSTGRES_DB", "must not be empty")
+		}
+		if cfg.Postgres.User == "" {
+			l.fail("POSTGRES_USER", "must not be empty")
+		}
+		if !sslModes[cfg.Postgres.SSLMode] {
+			l.fail("POSTGRES_SSLMODE", "must be one of disable, allow, prefer, require, verify-ca, verify-full")
+		}
+	}
+	if cfg.Postgres.MaxConns < 1 {
+		l.fail("POSTGRES_MAX_CONNS", "must be at least 1")
+	}
+	if cfg.Postgres.MinConns < 0 || cfg.Postgres.MinConns > cfg.Postgres.MaxConns {
+		l.fail("POSTGRES_MIN_CONNS", "must be between 0 and POSTGRES_MAX_CONNS")
+	}
+	if cfg.Postgres.MaxConnLifetime <= 0 {
+		l.fail("POSTGRES_MAX_CONN_LIFETIME", "must be positive")
+	}
+
+	if cfg.Redis.Addr == "" {
+		l.fail("REDIS_ADDR", "must not be empty")
+	}
+	if cfg.Redis.DB < 0 {
+		l.fail("REDIS_DB", "must not be negative")
+	}
+	if cfg.Redis.DialTimeout <= 0 {
+		l.fail("REDIS_DIAL_TIMEOUT", "must be positive")
+	}
+	if cfg.Redis.ReadTimeout <= 0 {
+		l.fail("REDIS_READ_TIMEOUT", "must be positive")
+	}
+	if cfg.Redis.WriteTimeout <= 0 {
+		l.fail("REDIS_WRITE_TIMEOUT", "must be positive")
+	}
+
+	if cfg.Cache.UserTTL <= 0 {
+		l.fail("CACHE_USER_TTL", "must be positive")
+	}
+	if cfg.Cache.APIKeyTTL <= 0 {
+		l.fail("CACHE_API_KEY_TTL", "must be positive")
+	}
+	if cfg.Cache.BreakerThreshold < 1 {
+		l.fail("CACHE_BREAKER_THRESHOLD", "must be at least 1")
+	}
+	if cfg.Cache.ProbeInterval <= 0 {
+		l.fail("CACHE_PROBE_INTERVAL", "must be positive")
+	}
+
+	if cfg.Health.Timeout <= 0 {
+		l.fail("HEALTH_TIMEOUT", "must be positive")
+	}
+
+	switch strings.ToLower(cfg.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		l.fail("LOG_LEVEL", "must be one of debug, info, warn, error")
+	}
+	switch strings.ToLower(cfg.Log.Format) {
+	case "json", "text":
+	default:
+		l.fail("LOG_FORMAT", "must be json or text")
+	}
+
+	switch cfg.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if cfg.Tracing.OTLPEndpoint == "" {
+			l.fail("TRACING_OTLP_ENDPOINT", "must not be empty when TRACING_EXPORTER=otlp")
+		}
+	default:
+		l.fail("TRACING_EXPORTER", "must be one of none, stdout, otlp")
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		l.fail("TRACING_SAMPLE_RATIO", "must be between 0 and 1")
+	}
+	if cfg.Tracing.ServiceName == "" {
+		l.fail("TRACING_SERVICE_NAME", "must not be empty")
+	}
+
+	if cfg.Auth.Enabled && cfg.Auth.HS256Secret == "" && cfg.Auth.JWKSFile == "" {
+		l.fail("AUTH_HS256_SECRET", "AUTH_HS256_SECRET or AUTH_JWKS_FILE must be set when AUTH_ENABLED=true")
+	}
+	if cfg.Auth.HS256Secret != "" && len(cfg.Auth.HS256Secret) < 32 {
+		l.fail("AUTH_HS256_SECRET", "must be at least 32 bytes")
+	}
+	if cfg.Auth.ClockSkew < 0 {
+		l.fail("AUTH_CLOCK_SKEW", "must not be negative")
+	}
+	if cfg.Auth.JWKSCheckInterval <= 0 {
+		l.fail("AUTH_JWKS_CHECK_INTERVAL", "must be positive")
+	}
+	if cfg.Auth.AccessTokenTTL <= 0 {
+		l.fail("AUTH_ACCESS_TOKEN_TTL", "must be positive")
+	}
+	if cfg.Auth.RefreshTokenTTL <= cfg.Auth.AccessTokenTTL {
+		l.fail("AUTH_REFRESH_TOKEN_TTL", "must be longer than AUTH_ACCESS_TOKEN_TTL")
+	}
+	if cfg.Auth.LoginMaxAttempts < 1 {
+		l.fail("AUTH_LOGIN_MAX_ATTEMPTS", "must be at least 1")
+	}
+	if cfg.Auth.LoginLockout <= 0 {
+		l.fail("AUTH_LOGIN_LOCKOUT", "must be positive")
+	}
+	pw := cfg.Auth.Password
+	if pw.Algorithm != "argon2id" && pw.Algorithm != "bcrypt" {
+		l.fail("AUTH_PASSWORD_ALGORITHM", "must be argon2id or bcrypt")
+	}
+	if pw.MinLength < 8 {
+		l.fail("AUTH_PASSWORD_MIN_LENGTH", "must be at least 8")
+	}
+	if pw.Argon2MemoryKiB < 8*1024 {
+		l.fail("AUTH_ARGON2_MEMORY_KIB", "must be at least 8192")
+	}
+	if pw.Argon2Iterations < 1 {
+		l.fail("AUTH_ARGON2_ITERATIONS", "must be at least 1")
+	}
+	if pw.Argon2Parallelism < 1 || pw.Argon2Parallelism > 255 {
+		l.fail("AUTH_ARGON2_PARALLELISM", "must be between 1 and 255")
+	}
+	if pw.BcryptCost < 10 || pw.BcryptCost > 31 {
+		l.fail("AUTH_BCRYPT_COST", "must be between 10 and 31")
+	}
+
+	if cfg.Users.PurgeRetention < 0 {
+		l.fail("USERS_PURGE_RETENTION", "must not be negative")
+	}
+	if cfg.Users.PurgeInterval <= 0 {
+		l.fail("USERS_PURGE_INTERVAL", "must be positive")
+	}
+	if cfg.Users.CursorSecret != "" && len(cfg.Users.CursorSecret) < 32 {
+		l.fail("USERS_CURSOR_SECRET", "must be at least 32 bytes")
+	}
+	if cfg.Users.SearchSimilarity <= 0 || cfg.Users.SearchSimilarity > 1 {
+		l.fail("USERS_SEARCH_SIMILARITY", "must be greater than 0 and at most 1")
+	}
+	if cfg.Users.ImportMaxRows <= 0 {
+		l.fail("USERS_IMPORT_MAX_ROWS", "must be positive")
+	}
+
+	if cfg.Idempotency.LockTTL <= 0 {
+		l.fail("IDEMPOTENCY_LOCK_TTL", "must be positive")
+	}
+	if cfg.Idempotency.TTL <= cfg.Idempotency.LockTTL {
+		l.fail("IDEMPOTENCY_TTL", "must be longer than IDEMPOTENCY_LOCK_TTL")
+	}
+	if cfg.Idempotency.MaxBodyBytes <= 0 {
+		l.fail("IDEMPOTENCY_MAX_BODY_BYTES", "must be positive")
+	}
+}
+
+type loader struct {
+	file      map[string]string
+	lookupEnv func(string) (string, bool)
+	used      map[string]bool
+	problems  []Problem
+}
+
+func (l *loader) fail(key, msg string) {
+	l.problems = append(l.problems, Problem{Key: key, Msg: msg})
+}
+
+func (l *loader) lookup(key string) (string, bool) {
+	l.used[key] = true
+	if v, ok := l.lookupEnv(key); ok && v != "" {
+		return v, true
+	}
+	v, ok := l.file[key]
+	return v, ok
+}
+
+func (l *loader) str(key string, dst *string) {
+	if v, ok := l.lookup(key); ok {
+		*dst = strings.TrimSpace(v)
+	}
+}
+
+func (l *loader) int(key string, dst *int) {
+	v, ok := l.lookup(key)
+	if !ok {
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		l.fail(key, fmt.Sprintf("%q is not an integer", v))
+		return
+	}
+	*dst = n
+}
+
+func (l *loader) int32(key string, dst *int32) {
+	v, ok := l.lookup(key)
+	if !ok {
+		return
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 32)
+	if err != nil {
+		l.fail(key, fmt.Sprintf("%q is not a 32-bit integer", v))
+		return
+	}
+	*dst = int32(n)
+}
+
+func (l *loader) duration(key string, dst *time.Duration) {
+	v, ok := l.lookup(key)
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		l.fail(key, fmt.Sprintf("%q is not a duration (e.g. 500ms, 30s, 5m)", v))
+		return
+	}
+	*dst = d
+}
+
+func (l *loader) float64(key string, dst *float64) {
+	v, ok := l.lookup(key)
+	if !ok {
+		return
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		l.fail(key, fmt.Sprintf("%q is not a number", v))
+		return
+	}
+	*dst = f
+}
+
+func (l *loader) bool(key string, dst *bool) {
+	v, ok := l.lookup(key)
+	if !ok {
+		return
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		l.fail(key, fmt.Sprintf("%q is not a boolean", v))
+		return
+	}
+	*dst = b
+}
+
+func (l *loader) unknownFileKeys() {
+	var unknown []string
+	for key := range l.file {
+		if !l.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		l.fail(key, "unknown key in config file")
+	}
+}
+
+// readFile parses a YAML or TOML file and flattens it into env-style keys.
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &tree)
+	case ".toml":
+		err = toml.Unmarshal(b, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension %q (want .yaml, .yml or .toml)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+
+	out := map[string]string{}
+	flatten("", tree, out)
+	return out, nil
+}
+
+func flatten(prefix string, tree map[string]any, out map[string]string) {
+	for k, v := range tree {
+		key := strings.ToUpper(k)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		if sub, ok := v.(map[string]any); ok {
+			flatten(key, sub, out)
+			continue
+		}
+		out[key] = fmt.Sprint(v)
+	}
+}
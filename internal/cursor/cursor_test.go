@@ -0,0 +1,48 @@
+package cursor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type position struct {
+	T  int64 `json:"t"`
+	ID int64 `json:"i"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := New([]byte("0123456789abcdef0123456789abcdef"))
+	tok, err := c.Encode(position{T: 1700000000000000, ID: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got position
+	if err := c.Decode(tok, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (position{T: 1700000000000000, ID: 42}) {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	c := New([]byte("0123456789abcdef0123456789abcdef"))
+	tok, _ := c.Encode(position{ID: 42})
+	forged, _ := New([]byte("another secret, another secret!!")).Encode(position{ID: 1})
+	payload, sig, _ := strings.Cut(tok, ".")
+
+	for name, in := range map[string]string{
+		"empty":         "",
+		"no signature":  payload,
+		"other secret":  forged,
+		"tampered":      payload[:len(payload)-1] + "A." + sig,
+		"bad base64":    "!!!." + sig,
+		"truncated mac": payload + "." + sig[:4],
+	} {
+		var got position
+		if err := c.Decode(in, &got); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: got %v, want ErrInvalid", name, err)
+		}
+	}
+}
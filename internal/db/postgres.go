@@ -2,19 +2,22 @@ package db
 
 import (
 	"context"
-	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/tfenng/scaffold/internal/config"
+	"github.com/tfenng/scaffold/internal/tracing"
 )
 
-func NewPostgres(ctx context.Context, dsn string) (*pgxpool.Pool, error) {
-	cfg, err := pgxpool.ParseConfig(dsn)
+func NewPostgres(ctx context.Context, c config.Postgres) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(c.ConnString())
 	if err != nil {
 		return nil, err
 	}
-	cfg.MaxConns = 20
-	cfg.MinConns = 2
-	cfg.MaxConnLifetime = 30 * time.Minute
+	cfg.MaxConns = c.MaxConns
+	cfg.MinConns = c.MinConns
+	cfg.MaxConnLifetime = c.MaxConnLifetime
+	cfg.ConnConfig.Tracer = tracing.PgxTracer{}
 
 	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
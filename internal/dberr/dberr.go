@@ -0,0 +1,113 @@
+package dberr
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+// Postgres SQLSTATE
+const (
+	NotNullViolation     = "23502"
+	ForeignKeyViolation  = "23503"
+	UniqueViolation      = "23505"
+	CheckViolation       = "23514"
+	SerializationFailure = "40001"
+	DeadlockDetected     = "40P01"
+	QueryCanceled        = "57014"
+)
+
+// Constraints maps a constraint or index name to the error returned when it
+// is violated. Entities declare their table next to their repo and Register it.
+type Constraints map[string]*domain.AppError
+
+type Registry struct {
+	mu    sync.RWMutex
+	known map[string]*domain.AppError
+}
+
+func NewRegistry() *Registry { return &Registry{known: map[string]*domain.AppError{}} }
+
+func (r *Registry) Register(c Constraints) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, ae := range c {
+		r.known[name] = ae
+	}
+}
+
+var std = NewRegistry()
+
+func Register(c Constraints) { std.Register(c) }
+
+// Map classifies err using the package registry. See Registry.Map.
+func Map(err error) error { return std.Map(err) }
+
+// Map turns a pgx v5 error into a *domain.AppError. Errors that already are
+// AppErrors pass through; anything unrecognised becomes Internal. Nil stays nil.
+func (r *Registry) Map(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var ae *domain.AppError
+	if errors.As(err, &ae) {
+		return ae
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return withCause(domain.NotFound("not found"), err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return withCause(domain.DeadlineExceeded("request canceled or timed out"), err)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return domain.Internal(err)
+	}
+
+	if known := r.lookup(pgErr.ConstraintName); known != nil {
+		return withCause(known, err)
+	}
+
+	switch pgErr.Code {
+	case UniqueViolation:
+		return withCause(domain.Conflict("unique constraint violation"), err)
+	case ForeignKeyViolation:
+		return withCause(domain.Conflict("foreign key constraint violation"), err)
+	case NotNullViolation:
+		if pgErr.ColumnName != "" {
+			return withCause(domain.InvalidField(pgErr.ColumnName, "required", "is required"), err)
+		}
+		return withCause(domain.Invalid("required value is missing"), err)
+	case CheckViolation:
+		return withCause(domain.Invalid("check constraint violation"), err)
+	case SerializationFailure, DeadlockDetected:
+		return withCause(domain.Aborted("concurrent update conflict, please retry"), err)
+	case QueryCanceled:
+		return withCause(domain.DeadlineExceeded("query canceled or timed out"), err)
+	default:
+		return domain.Internal(err)
+	}
+}
+
+func (r *Registry) lookup(constraint string) *domain.AppError {
+	if constraint == "" {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.known[constraint]
+}
+
+// withCause copies tmpl so registered templates are never mutated.
+func withCause(tmpl *domain.AppError, cause error) *domain.AppError {
+	ae := *tmpl
+	ae.Cause = cause
+	return &ae
+}
@@ -0,0 +1,66 @@
+package dberr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+func TestRegistryMap(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Constraints{
+		"orders_user_fk":      domain.Invalid("user does not exist"),
+		"orders_amount_check": domain.Invalid("amount must be positive"),
+	})
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode domain.Code
+		wantMsg  string
+	}{
+		{name: "registered fk", err: &pgconn.PgError{Code: ForeignKeyViolation, ConstraintName: "orders_user_fk"}, wantCode: domain.CodeInvalidArgument, wantMsg: "user does not exist"},
+		{name: "registered check", err: &pgconn.PgError{Code: CheckViolation, ConstraintName: "orders_amount_check"}, wantCode: domain.CodeInvalidArgument, wantMsg: "amount must be positive"},
+		{name: "unregistered unique", err: &pgconn.PgError{Code: UniqueViolation, ConstraintName: "x"}, wantCode: domain.CodeConflict, wantMsg: "unique constraint violation"},
+		{name: "unregistered fk", err: &pgconn.PgError{Code: ForeignKeyViolation}, wantCode: domain.CodeConflict, wantMsg: "foreign key constraint violation"},
+		{name: "not null", err: &pgconn.PgError{Code: NotNullViolation, ColumnName: "name"}, wantCode: domain.CodeInvalidArgument, wantMsg: "name is required"},
+		{name: "check", err: &pgconn.PgError{Code: CheckViolation}, wantCode: domain.CodeInvalidArgument, wantMsg: "check constraint violation"},
+		{name: "serialization", err: &pgconn.PgError{Code: SerializationFailure}, wantCode: domain.CodeAborted, wantMsg: "concurrent update conflict, please retry"},
+		{name: "deadlock", err: &pgconn.PgError{Code: DeadlockDetected}, wantCode: domain.CodeAborted, wantMsg: "concurrent update conflict, please retry"},
+		{name: "query canceled", err: &pgconn.PgError{Code: QueryCanceled}, wantCode: domain.CodeDeadlineExceeded, wantMsg: "query canceled or timed out"},
+		{name: "context deadline", err: fmt.Errorf("acquire: %w", context.DeadlineExceeded), wantCode: domain.CodeDeadlineExceeded, wantMsg: "request canceled or timed out"},
+		{name: "no rows", err: pgx.ErrNoRows, wantCode: domain.CodeNotFound, wantMsg: "not found"},
+		{name: "app error passthrough", err: domain.NotFound("user not found"), wantCode: domain.CodeNotFound, wantMsg: "user not found"},
+		{name: "unknown sqlstate", err: &pgconn.PgError{Code: "XX000"}, wantCode: domain.CodeInternal, wantMsg: "internal error"},
+		{name: "plain error", err: errors.New("boom"), wantCode: domain.CodeInternal, wantMsg: "internal error"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ae *domain.AppError
+			if !errors.As(r.Map(tc.err), &ae) {
+				t.Fatal("expected *domain.AppError")
+			}
+			if ae.Code != tc.wantCode || ae.Message != tc.wantMsg {
+				t.Fatalf("unexpected error: got=%s %q want=%s %q", ae.Code, ae.Message, tc.wantCode, tc.wantMsg)
+			}
+		})
+	}
+}
+
+func TestRegistryDoesNotMutateTemplates(t *testing.T) {
+	tmpl := domain.Conflict("uid already exists")
+	r := NewRegistry()
+	r.Register(Constraints{"users_uid_unique": tmpl})
+
+	_ = r.Map(&pgconn.PgError{Code: UniqueViolation, ConstraintName: "users_uid_unique"})
+	if tmpl.Cause != nil {
+		t.Fatal("registered template was mutated")
+	}
+}
@@ -5,22 +5,69 @@ import "net/http"
 type Code string
 
 const (
-	CodeInvalidArgument Code = "INVALID_ARGUMENT"
-	CodeNotFound        Code = "NOT_FOUND"
-	CodeConflict        Code = "CONFLICT"
-	CodeInternal        Code = "INTERNAL"
+	CodeInvalidArgument      Code = "INVALID_ARGUMENT"
+	CodeUnauthenticated      Code = "UNAUTHENTICATED"
+	CodeForbidden            Code = "FORBIDDEN"
+	CodeNotFound             Code = "NOT_FOUND"
+	CodeConflict             Code = "CONFLICT"
+	CodeAborted              Code = "ABORTED"
+	CodeFailedPrecondition   Code = "FAILED_PRECONDITION"
+	CodePreconditionRequired Code = "PRECONDITION_REQUIRED"
+	CodeResourceExhausted    Code = "RESOURCE_EXHAUSTED"
+	CodeDeadlineExceeded     Code = "DEADLINE_EXCEEDED"
+	CodeInternal             Code = "INTERNAL"
 )
 
+// FieldViolation points at one input that failed validation. Field is the
+// JSON (or query) path as the client sent it, e.g. "birth" or "items[2].uid".
+type FieldViolation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
 type AppError struct {
-	Code       Code   `json:"code"`
-	Message    string `json:"message"`
-	HTTPStatus int    `json:"-"`
-	Cause      error  `json:"-"`
+	Code       Code             `json:"code"`
+	Message    string           `json:"message"`
+	Fields     []FieldViolation `json:"errors,omitempty"`
+	HTTPStatus int              `json:"-"`
+	Cause      error            `json:"-"`
 }
 
 func (e *AppError) Error() string { return string(e.Code) + ": " + e.Message }
 
+func (e *AppError) Unwrap() error { return e.Cause }
+
+// WithField appends a violation and returns e for chaining.
+func (e *AppError) WithField(field, rule, msg string) *AppError {
+	e.Fields = append(e.Fields, FieldViolation{Field: field, Rule: rule, Message: msg})
+	return e
+}
+
+// InvalidField is Invalid with a single violation on field.
+func InvalidField(field, rule, msg string) *AppError {
+	return Invalid(field+" "+msg).WithField(field, rule, msg)
+}
+
+// InvalidFields reports several violations at once; msg summarises them.
+func InvalidFields(msg string, fields ...FieldViolation) *AppError {
+	e := Invalid(msg)
+	e.Fields = fields
+	return e
+}
+
 func Invalid(msg string) *AppError  { return &AppError{Code: CodeInvalidArgument, Message: msg, HTTPStatus: http.StatusBadRequest} }
+func Unauthenticated(msg string) *AppError { return &AppError{Code: CodeUnauthenticated, Message: msg, HTTPStatus: http.StatusUnauthorized} }
+func Forbidden(msg string) *AppError { return &AppError{Code: CodeForbidden, Message: msg, HTTPStatus: http.StatusForbidden} }
 func NotFound(msg string) *AppError { return &AppError{Code: CodeNotFound, Message: msg, HTTPStatus: http.StatusNotFound} }
 func Conflict(msg string) *AppError { return &AppError{Code: CodeConflict, Message: msg, HTTPStatus: http.StatusConflict} }
+func Aborted(msg string) *AppError  { return &AppError{Code: CodeAborted, Message: msg, HTTPStatus: http.StatusConflict} }
+func FailedPrecondition(msg string) *AppError { return &AppError{Code: CodeFailedPrecondition, Message: msg, HTTPStatus: http.StatusPreconditionFailed} }
+func PreconditionRequired(msg string) *AppError { return &AppError{Code: CodePreconditionRequired, Message: msg, HTTPStatus: http.StatusPreconditionRequired} }
+func Unprocessable(msg string) *AppError { return &AppError{Code: CodeInvalidArgument, Message: msg, HTTPStatus: http.StatusUnprocessableEntity} }
+func UnsupportedMediaType(msg string) *AppError { return &AppError{Code: CodeInvalidArgument, Message: msg, HTTPStatus: http.StatusUnsupportedMediaType} }
+func PayloadTooLarge(msg string) *AppError { return &AppError{Code: CodeInvalidArgument, Message: msg, HTTPStatus: http.StatusRequestEntityTooLarge} }
+func NotAcceptable(msg string) *AppError { return &AppError{Code: CodeInvalidArgument, Message: msg, HTTPStatus: http.StatusNotAcceptable} }
+func ResourceExhausted(msg string) *AppError { return &AppError{Code: CodeResourceExhausted, Message: msg, HTTPStatus: http.StatusTooManyRequests} }
+func DeadlineExceeded(msg string) *AppError { return &AppError{Code: CodeDeadlineExceeded, Message: msg, HTTPStatus: http.StatusGatewayTimeout} }
 func Internal(err error) *AppError  { return &AppError{Code: CodeInternal, Message: "internal error", HTTPStatus: http.StatusInternalServerError, Cause: err} }
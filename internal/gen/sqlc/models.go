@@ -8,6 +8,51 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type ApiKey struct {
+	ID         int64
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	CreatedBy  string
+	ExpiresAt  pgtype.Timestamptz
+	LastUsedAt pgtype.Timestamptz
+	RevokedAt  pgtype.Timestamptz
+	CreatedAt  pgtype.Timestamptz
+}
+
+type AuditEvent struct {
+	ID        int64
+	Actor     string
+	Action    string
+	TargetID  int64
+	TargetUid string
+	Changes   []byte
+	RequestID pgtype.Text
+	CreatedAt pgtype.Timestamptz
+}
+
+type IdempotencyKey struct {
+	Key            string
+	RequestHash    string
+	StatusCode     pgtype.Int4
+	ResponseHeader []byte
+	ResponseBody   []byte
+	ExpiresAt      pgtype.Timestamptz
+	CreatedAt      pgtype.Timestamptz
+}
+
+type RefreshToken struct {
+	ID         int64
+	UserID     int64
+	FamilyID   string
+	TokenHash  string
+	ExpiresAt  pgtype.Timestamptz
+	CreatedAt  pgtype.Timestamptz
+	RevokedAt  pgtype.Timestamptz
+	ReplacedBy pgtype.Int8
+}
+
 type User struct {
 	ID        int64
 	Email     pgtype.Text
@@ -18,4 +63,14 @@ type User struct {
 	CreatedAt pgtype.Timestamptz
 	UpdatedAt pgtype.Timestamptz
 	Uid       string
+	DeletedAt pgtype.Timestamptz
+	Version   int64
+}
+
+type UserCredential struct {
+	UserID            int64
+	PasswordHash      string
+	Roles             []string
+	PasswordChangedAt pgtype.Timestamptz
+	CreatedAt         pgtype.Timestamptz
 }
@@ -11,29 +11,10 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const countUsers = `-- name: CountUsers :one
-SELECT COUNT(1)
-FROM users
-WHERE ($1::text IS NULL OR email = $1::text)
-  AND ($2::text IS NULL OR name ILIKE ('%' || $2::text || '%'))
-`
-
-type CountUsersParams struct {
-	Email    pgtype.Text
-	NameLike pgtype.Text
-}
-
-func (q *Queries) CountUsers(ctx context.Context, arg CountUsersParams) (int64, error) {
-	row := q.db.QueryRow(ctx, countUsers, arg.Email, arg.NameLike)
-	var count int64
-	err := row.Scan(&count)
-	return count, err
-}
-
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (uid, name, email, used_name, company, birth)
 VALUES ($1, $2, $6::text, $3, $4, $5)
-RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
 `
 
 type CreateUserParams struct {
@@ -55,6 +36,8 @@ type CreateUserRow struct {
 	Birth     pgtype.Date
 	CreatedAt pgtype.Timestamptz
 	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error) {
@@ -77,23 +60,89 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateU
 		&i.Birth,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
-const deleteUser = `-- name: DeleteUser :exec
-DELETE FROM users WHERE id = $1
+const createUsers = `-- name: CreateUsers :many
+INSERT INTO users (uid, name, email, used_name, company, birth)
+SELECT uid, name, NULLIF(email, ''), NULLIF(used_name, ''), NULLIF(company, ''), NULLIF(birth, '')::date
+FROM unnest(
+  $1::text[], $2::text[], $3::text[],
+  $4::text[], $5::text[], $6::text[]
+) AS t(uid, name, email, used_name, company, birth)
+ON CONFLICT DO NOTHING
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
 `
 
-func (q *Queries) DeleteUser(ctx context.Context, id int64) error {
-	_, err := q.db.Exec(ctx, deleteUser, id)
-	return err
+type CreateUsersParams struct {
+	Uids      []string
+	Names     []string
+	Emails    []string
+	UsedNames []string
+	Companies []string
+	Births    []string
+}
+
+type CreateUsersRow struct {
+	ID        int64
+	Uid       string
+	Email     pgtype.Text
+	Name      string
+	UsedName  pgtype.Text
+	Company   pgtype.Text
+	Birth     pgtype.Date
+	CreatedAt pgtype.Timestamptz
+	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
+}
+
+func (q *Queries) CreateUsers(ctx context.Context, arg CreateUsersParams) ([]CreateUsersRow, error) {
+	rows, err := q.db.Query(ctx, createUsers,
+		arg.Uids,
+		arg.Names,
+		arg.Emails,
+		arg.UsedNames,
+		arg.Companies,
+		arg.Births,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CreateUsersRow
+	for rows.Next() {
+		var i CreateUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Uid,
+			&i.Email,
+			&i.Name,
+			&i.UsedName,
+			&i.Company,
+			&i.Birth,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
 FROM users
-WHERE email = $1
+WHERE email = $1 AND deleted_at IS NULL
 `
 
 type GetUserByEmailRow struct {
@@ -106,6 +155,8 @@ type GetUserByEmailRow struct {
 	Birth     pgtype.Date
 	CreatedAt pgtype.Timestamptz
 	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
 }
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email pgtype.Text) (GetUserByEmailRow, error) {
@@ -121,14 +172,16 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email pgtype.Text) (GetUse
 		&i.Birth,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
 FROM users
-WHERE id = $1
+WHERE id = $1 AND deleted_at IS NULL
 `
 
 type GetUserByIDRow struct {
@@ -141,6 +194,8 @@ type GetUserByIDRow struct {
 	Birth     pgtype.Date
 	CreatedAt pgtype.Timestamptz
 	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
 }
 
 func (q *Queries) GetUserByID(ctx context.Context, id int64) (GetUserByIDRow, error) {
@@ -156,14 +211,56 @@ func (q *Queries) GetUserByID(ctx context.Context, id int64) (GetUserByIDRow, er
 		&i.Birth,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const getUserByIDForUpdate = `-- name: GetUserByIDForUpdate :one
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
+FROM users
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE
+`
+
+type GetUserByIDForUpdateRow struct {
+	ID        int64
+	Uid       string
+	Email     pgtype.Text
+	Name      string
+	UsedName  pgtype.Text
+	Company   pgtype.Text
+	Birth     pgtype.Date
+	CreatedAt pgtype.Timestamptz
+	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
+}
+
+func (q *Queries) GetUserByIDForUpdate(ctx context.Context, id int64) (GetUserByIDForUpdateRow, error) {
+	row := q.db.QueryRow(ctx, getUserByIDForUpdate, id)
+	var i GetUserByIDForUpdateRow
+	err := row.Scan(
+		&i.ID,
+		&i.Uid,
+		&i.Email,
+		&i.Name,
+		&i.UsedName,
+		&i.Company,
+		&i.Birth,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
 const getUserByUID = `-- name: GetUserByUID :one
-SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
 FROM users
-WHERE uid = $1
+WHERE uid = $1 AND deleted_at IS NULL
 `
 
 type GetUserByUIDRow struct {
@@ -176,6 +273,8 @@ type GetUserByUIDRow struct {
 	Birth     pgtype.Date
 	CreatedAt pgtype.Timestamptz
 	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
 }
 
 func (q *Queries) GetUserByUID(ctx context.Context, uid string) (GetUserByUIDRow, error) {
@@ -191,27 +290,86 @@ func (q *Queries) GetUserByUID(ctx context.Context, uid string) (GetUserByUIDRow
 		&i.Birth,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
-const listUsers = `-- name: ListUsers :many
-SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at
+const listTakenUserKeys = `-- name: ListTakenUserKeys :many
+SELECT uid, name, email, deleted_at IS NULL AS live
 FROM users
-WHERE ($3::text IS NULL OR email = $3::text)
-  AND ($4::text IS NULL OR name ILIKE ('%' || $4::text || '%'))
-ORDER BY created_at DESC, id DESC
-LIMIT $1 OFFSET $2
+WHERE uid = ANY($1::text[])
+  OR (deleted_at IS NULL AND (name = ANY($2::text[]) OR email = ANY($3::text[])))
 `
 
-type ListUsersParams struct {
-	Limit    int32
-	Offset   int32
-	Email    pgtype.Text
-	NameLike pgtype.Text
+type ListTakenUserKeysParams struct {
+	Uids   []string
+	Names  []string
+	Emails []string
+}
+
+type ListTakenUserKeysRow struct {
+	Uid   string
+	Name  string
+	Email pgtype.Text
+	Live  bool
+}
+
+func (q *Queries) ListTakenUserKeys(ctx context.Context, arg ListTakenUserKeysParams) ([]ListTakenUserKeysRow, error) {
+	rows, err := q.db.Query(ctx, listTakenUserKeys, arg.Uids, arg.Names, arg.Emails)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTakenUserKeysRow
+	for rows.Next() {
+		var i ListTakenUserKeysRow
+		if err := rows.Scan(
+			&i.Uid,
+			&i.Name,
+			&i.Email,
+			&i.Live,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-type ListUsersRow struct {
+const patchUser = `-- name: PatchUser :one
+UPDATE users
+SET name = CASE WHEN $2::bool THEN $3::text ELSE name END,
+    email = CASE WHEN $4::bool THEN $5::text ELSE email END,
+    used_name = CASE WHEN $6::bool THEN $7::text ELSE used_name END,
+    company = CASE WHEN $8::bool THEN $9::text ELSE company END,
+    birth = CASE WHEN $10::bool THEN $11::date ELSE birth END,
+    updated_at = now(),
+    version = version + 1
+WHERE id = $1 AND deleted_at IS NULL AND version = $12
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
+`
+
+type PatchUserParams struct {
+	ID          int64
+	SetName     bool
+	Name        string
+	SetEmail    bool
+	Email       pgtype.Text
+	SetUsedName bool
+	UsedName    pgtype.Text
+	SetCompany  bool
+	Company     pgtype.Text
+	SetBirth    bool
+	Birth       pgtype.Date
+	Version     int64
+}
+
+type PatchUserRow struct {
 	ID        int64
 	Uid       string
 	Email     pgtype.Text
@@ -221,22 +379,193 @@ type ListUsersRow struct {
 	Birth     pgtype.Date
 	CreatedAt pgtype.Timestamptz
 	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
 }
 
-func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUsersRow, error) {
-	rows, err := q.db.Query(ctx, listUsers,
-		arg.Limit,
-		arg.Offset,
+func (q *Queries) PatchUser(ctx context.Context, arg PatchUserParams) (PatchUserRow, error) {
+	row := q.db.QueryRow(ctx, patchUser,
+		arg.ID,
+		arg.SetName,
+		arg.Name,
+		arg.SetEmail,
 		arg.Email,
-		arg.NameLike,
+		arg.SetUsedName,
+		arg.UsedName,
+		arg.SetCompany,
+		arg.Company,
+		arg.SetBirth,
+		arg.Birth,
+		arg.Version,
+	)
+	var i PatchUserRow
+	err := row.Scan(
+		&i.ID,
+		&i.Uid,
+		&i.Email,
+		&i.Name,
+		&i.UsedName,
+		&i.Company,
+		&i.Birth,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :many
+DELETE FROM users
+WHERE id IN (
+  SELECT id FROM users
+  WHERE deleted_at < $1
+  ORDER BY deleted_at
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
+`
+
+type PurgeDeletedUsersParams struct {
+	DeletedAt pgtype.Timestamptz
+	Limit     int32
+}
+
+type PurgeDeletedUsersRow struct {
+	ID        int64
+	Uid       string
+	Email     pgtype.Text
+	Name      string
+	UsedName  pgtype.Text
+	Company   pgtype.Text
+	Birth     pgtype.Date
+	CreatedAt pgtype.Timestamptz
+	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
+}
+
+func (q *Queries) PurgeDeletedUsers(ctx context.Context, arg PurgeDeletedUsersParams) ([]PurgeDeletedUsersRow, error) {
+	rows, err := q.db.Query(ctx, purgeDeletedUsers, arg.DeletedAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PurgeDeletedUsersRow
+	for rows.Next() {
+		var i PurgeDeletedUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Uid,
+			&i.Email,
+			&i.Name,
+			&i.UsedName,
+			&i.Company,
+			&i.Birth,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const restoreUser = `-- name: RestoreUser :one
+UPDATE users u
+SET deleted_at = NULL, updated_at = now(), version = u.version + 1
+FROM users old
+WHERE u.id = $1 AND old.id = u.id AND u.deleted_at IS NOT NULL
+RETURNING u.id, u.uid, u.email, u.name, u.used_name, u.company, u.birth, u.created_at, u.updated_at, u.deleted_at, u.version, old.deleted_at AS previous_deleted_at
+`
+
+type RestoreUserRow struct {
+	ID                int64
+	Uid               string
+	Email             pgtype.Text
+	Name              string
+	UsedName          pgtype.Text
+	Company           pgtype.Text
+	Birth             pgtype.Date
+	CreatedAt         pgtype.Timestamptz
+	UpdatedAt         pgtype.Timestamptz
+	DeletedAt         pgtype.Timestamptz
+	Version           int64
+	PreviousDeletedAt pgtype.Timestamptz
+}
+
+func (q *Queries) RestoreUser(ctx context.Context, id int64) (RestoreUserRow, error) {
+	row := q.db.QueryRow(ctx, restoreUser, id)
+	var i RestoreUserRow
+	err := row.Scan(
+		&i.ID,
+		&i.Uid,
+		&i.Email,
+		&i.Name,
+		&i.UsedName,
+		&i.Company,
+		&i.Birth,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
+		&i.PreviousDeletedAt,
 	)
+	return i, err
+}
+
+const searchUsers = `-- name: SearchUsers :many
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version,
+  (ts_rank_cd(user_search_vector(name, uid, used_name, email, company), to_tsquery('simple', $2::text), 32) +
+    word_similarity($3::text, user_search_text(name, uid, used_name, email, company)))::float8 AS rank,
+  ts_headline('simple', concat_ws(' · ', name, used_name, company, uid, email), to_tsquery('simple', $2::text),
+    'HighlightAll=true, StartSel=' || chr(57344) || ', StopSel=' || chr(57345)) AS snippet
+FROM users
+WHERE deleted_at IS NULL
+  AND (user_search_vector(name, uid, used_name, email, company) @@ to_tsquery('simple', $2::text)
+    OR $3::text <% user_search_text(name, uid, used_name, email, company))
+ORDER BY rank DESC, id DESC
+LIMIT $1
+`
+
+type SearchUsersParams struct {
+	Limit   int32
+	Tsquery string
+	Query   string
+}
+
+type SearchUsersRow struct {
+	ID        int64
+	Uid       string
+	Email     pgtype.Text
+	Name      string
+	UsedName  pgtype.Text
+	Company   pgtype.Text
+	Birth     pgtype.Date
+	CreatedAt pgtype.Timestamptz
+	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
+	Rank      float64
+	Snippet   string
+}
+
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]SearchUsersRow, error) {
+	rows, err := q.db.Query(ctx, searchUsers, arg.Limit, arg.Tsquery, arg.Query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ListUsersRow
+	var items []SearchUsersRow
 	for rows.Next() {
-		var i ListUsersRow
+		var i SearchUsersRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.Uid,
@@ -247,6 +576,10 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUse
 			&i.Birth,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.DeletedAt,
+			&i.Version,
+			&i.Rank,
+			&i.Snippet,
 		); err != nil {
 			return nil, err
 		}
@@ -258,11 +591,60 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]ListUse
 	return items, nil
 }
 
+const setWordSimilarityThreshold = `-- name: SetWordSimilarityThreshold :exec
+SELECT set_config('pg_trgm.word_similarity_threshold', $1::text, true)
+`
+
+func (q *Queries) SetWordSimilarityThreshold(ctx context.Context, threshold string) error {
+	_, err := q.db.Exec(ctx, setWordSimilarityThreshold, threshold)
+	return err
+}
+
+const softDeleteUser = `-- name: SoftDeleteUser :one
+UPDATE users
+SET deleted_at = now(), updated_at = now(), version = version + 1
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
+`
+
+type SoftDeleteUserRow struct {
+	ID        int64
+	Uid       string
+	Email     pgtype.Text
+	Name      string
+	UsedName  pgtype.Text
+	Company   pgtype.Text
+	Birth     pgtype.Date
+	CreatedAt pgtype.Timestamptz
+	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
+}
+
+func (q *Queries) SoftDeleteUser(ctx context.Context, id int64) (SoftDeleteUserRow, error) {
+	row := q.db.QueryRow(ctx, softDeleteUser, id)
+	var i SoftDeleteUserRow
+	err := row.Scan(
+		&i.ID,
+		&i.Uid,
+		&i.Email,
+		&i.Name,
+		&i.UsedName,
+		&i.Company,
+		&i.Birth,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
-SET name = $2, email = $6::text, used_name = $3, company = $4, birth = $5, updated_at = now()
-WHERE id = $1
-RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at
+SET name = $2, email = $6::text, used_name = $3, company = $4, birth = $5, updated_at = now(), version = version + 1
+WHERE id = $1 AND deleted_at IS NULL AND version = $7
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
 `
 
 type UpdateUserParams struct {
@@ -272,6 +654,7 @@ type UpdateUserParams struct {
 	Company  pgtype.Text
 	Birth    pgtype.Date
 	Email    pgtype.Text
+	Version  int64
 }
 
 type UpdateUserRow struct {
@@ -284,6 +667,8 @@ type UpdateUserRow struct {
 	Birth     pgtype.Date
 	CreatedAt pgtype.Timestamptz
 	UpdatedAt pgtype.Timestamptz
+	DeletedAt pgtype.Timestamptz
+	Version   int64
 }
 
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateUserRow, error) {
@@ -294,6 +679,7 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateU
 		arg.Company,
 		arg.Birth,
 		arg.Email,
+		arg.Version,
 	)
 	var i UpdateUserRow
 	err := row.Scan(
@@ -306,6 +692,8 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (UpdateU
 		&i.Birth,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.DeletedAt,
+		&i.Version,
 	)
 	return i, err
 }
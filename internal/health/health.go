@@ -0,0 +1,131 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/tfenng/scaffold/internal/cache"
+)
+
+type Status string
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+
+	StatusOK          Status = "ok"
+	StatusDegraded    Status = "degraded"
+	StatusUnavailable Status = "unavailable"
+)
+
+type Result struct {
+	Status    Status         `json:"status"`
+	LatencyMS int64          `json:"latency_ms"`
+	Error     string         `json:"error,omitempty"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// Checker probes one dependency. A failing Critical checker makes the service
+// unavailable; a failing non-critical one only degrades it.
+type Checker struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) (map[string]any, error)
+}
+
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+func (r Report) Ready() bool { return r.Status != StatusUnavailable }
+
+// Run executes all checkers concurrently and aggregates their results.
+func Run(ctx context.Context, checkers []Checker) Report {
+	results := make([]Result, len(checkers))
+	var wg sync.WaitGroup
+	for i, c := range checkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			details, err := c.Check(ctx)
+			res := Result{Status: StatusUp, LatencyMS: time.Since(start).Milliseconds(), Details: details}
+			if err != nil {
+				res.Status = StatusDown
+				res.Error = err.Error()
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(checkers))}
+	for i, c := range checkers {
+		report.Checks[c.Name] = results[i]
+		if results[i].Status == StatusUp {
+			continue
+		}
+		if c.Critical {
+			report.Status = StatusUnavailable
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func Postgres(pool *pgxpool.Pool) Checker {
+	return Checker{Name: "postgres", Critical: true, Check: func(ctx context.Context) (map[string]any, error) {
+		err := pool.Ping(ctx)
+		st := pool.Stat()
+		return map[string]any{
+			"total_conns":      st.TotalConns(),
+			"idle_conns":       st.IdleConns(),
+			"acquired_conns":   st.AcquiredConns(),
+			"constructing":     st.ConstructingConns(),
+			"max_conns":        st.MaxConns(),
+			"acquire_count":    st.AcquireCount(),
+			"empty_acquire":    st.EmptyAcquireCount(),
+			"canceled_acquire": st.CanceledAcquireCount(),
+		}, err
+	}}
+}
+
+// Redis pings the server and reports the cache circuit state, which may lag
+// behind reachability until the next supervisor probe.
+func Redis(rdb *redis.Client, sup *cache.Supervisor) Checker {
+	return Checker{Name: "redis", Check: func(ctx context.Context) (map[string]any, error) {
+		details := map[string]any{"circuit": sup.State()}
+		return details, rdb.Ping(ctx).Err()
+	}}
+}
+
+// Migrations reads the golang-migrate bookkeeping table. A dirty or missing
+// schema version means the database is not in a state the code expects.
+func Migrations(pool *pgxpool.Pool) Checker {
+	return Checker{Name: "migrations", Critical: true, Check: func(ctx context.Context) (map[string]any, error) {
+		var (
+			version int64
+			dirty   bool
+		)
+		err := pool.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.New("no migration applied")
+		}
+		if err != nil {
+			return nil, err
+		}
+		details := map[string]any{"version": version, "dirty": dirty}
+		if dirty {
+			return details, errors.New("schema is dirty")
+		}
+		return details, nil
+	}}
+}
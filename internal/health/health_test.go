@@ -0,0 +1,43 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunStatus(t *testing.T) {
+	up := func(context.Context) (map[string]any, error) { return nil, nil }
+	down := func(context.Context) (map[string]any, error) { return nil, errors.New("boom") }
+
+	tests := []struct {
+		name      string
+		postgres  func(context.Context) (map[string]any, error)
+		redis     func(context.Context) (map[string]any, error)
+		want      Status
+		wantReady bool
+	}{
+		{name: "all up", postgres: up, redis: up, want: StatusOK, wantReady: true},
+		{name: "redis down", postgres: up, redis: down, want: StatusDegraded, wantReady: true},
+		{name: "postgres down", postgres: down, redis: up, want: StatusUnavailable, wantReady: false},
+		{name: "both down", postgres: down, redis: down, want: StatusUnavailable, wantReady: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			report := Run(context.Background(), []Checker{
+				{Name: "postgres", Critical: true, Check: tc.postgres},
+				{Name: "redis", Check: tc.redis},
+			})
+			if report.Status != tc.want {
+				t.Fatalf("unexpected status: got=%s want=%s", report.Status, tc.want)
+			}
+			if report.Ready() != tc.wantReady {
+				t.Fatalf("unexpected ready: got=%v want=%v", report.Ready(), tc.wantReady)
+			}
+			if len(report.Checks) != 2 {
+				t.Fatalf("expected a result per checker, got %v", report.Checks)
+			}
+		})
+	}
+}
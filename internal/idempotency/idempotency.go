@@ -0,0 +1,114 @@
+// Package idempotency lets a client retry a request with the same
+// Idempotency-Key and get the first attempt's response back instead of
+// running it twice.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tfenng/scaffold/internal/logging"
+)
+
+var (
+	// ErrInFlight means another request with the same key is still running.
+	ErrInFlight = errors.New("idempotency: request with this key is in flight")
+	// ErrMismatch means the key was first used for a different request.
+	ErrMismatch = errors.New("idempotency: key reused with a different request")
+)
+
+// Response is a stored response, replayed verbatim on retry.
+type Response struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header,omitempty"`
+	Body   []byte            `json:"body,omitempty"`
+}
+
+// Backend keeps claims and responses.
+type Backend interface {
+	// Claim takes key for a request whose content hashes to hash, holding it
+	// for at most lock. It returns the stored response once the key has
+	// completed, ErrInFlight while another request holds it and ErrMismatch
+	// when hash differs from the first request's.
+	Claim(ctx context.Context, key, hash string, lock time.Duration) (*Response, error)
+	// Complete stores the response of the request holding key for ttl.
+	Complete(ctx context.Context, key, hash string, resp Response, ttl time.Duration) error
+	// Release drops an in-flight claim so the request can be retried.
+	Release(ctx context.Context, key, hash string) error
+}
+
+// Store claims keys in Primary (Redis) and falls back to Fallback (Postgres)
+// when Primary fails. A key claimed in one backend is not visible in the
+// other, so a retry that lands after Redis recovers may run again.
+type Store struct {
+	Primary  Backend
+	Fallback Backend
+	// Lock bounds how long a crashed request can hold its key; TTL is how
+	// long completed responses are replayed.
+	Lock time.Duration
+	TTL  time.Duration
+}
+
+// Claim is a key held by the current request, or the response to replay.
+type Claim struct {
+	// Replay is the stored response of an earlier request with the same key;
+	// when nil the current request owns the key and must Complete or Release.
+	Replay *Response
+
+	key, hash string
+	backend   Backend
+	ttl       time.Duration
+}
+
+func (c *Claim) Complete(ctx context.Context, resp Response) error {
+	return c.backend.Complete(ctx, c.key, c.hash, resp, c.ttl)
+}
+
+func (c *Claim) Release(ctx context.Context) error {
+	return c.backend.Release(ctx, c.key, c.hash)
+}
+
+// Begin claims key for a request with content hash hash.
+func (s *Store) Begin(ctx context.Context, key, hash string) (*Claim, error) {
+	replay, err := s.Primary.Claim(ctx, key, hash, s.Lock)
+	if err == nil {
+		return &Claim{Replay: replay, key: key, hash: hash, backend: s.Primary, ttl: s.TTL}, nil
+	}
+	if errors.Is(err, ErrInFlight) || errors.Is(err, ErrMismatch) || s.Fallback == nil {
+		return nil, err
+	}
+
+	logging.From(ctx).Warn("idempotency primary store failed, using fallback", "err", err)
+	replay, err = s.Fallback.Claim(ctx, key, hash, s.Lock)
+	if err != nil {
+		return nil, err
+	}
+	return &Claim{Replay: replay, key: key, hash: hash, backend: s.Fallback, ttl: s.TTL}, nil
+}
+
+// Sweeper deletes expired keys from a backend that does not expire them on
+// its own.
+type Sweeper interface {
+	DeleteExpired(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Sweep calls s every interval until ctx is done.
+func Sweep(ctx context.Context, s Sweeper, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if n, err := s.DeleteExpired(ctx, time.Now()); err != nil {
+			if ctx.Err() == nil {
+				logging.From(ctx).Warn("idempotency sweep failed", "err", err)
+			}
+		} else if n > 0 {
+			logging.From(ctx).Debug("swept expired idempotency keys", "count", n)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type memBackend struct {
+	err  error
+	held map[string]string
+}
+
+func (m *memBackend) Claim(_ context.Context, key, hash string, _ time.Duration) (*Response, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if h, ok := m.held[key]; ok {
+		if h != hash {
+			return nil, ErrMismatch
+		}
+		return nil, ErrInFlight
+	}
+	m.held[key] = hash
+	return nil, nil
+}
+
+func (m *memBackend) Complete(context.Context, string, string, Response, time.Duration) error {
+	return nil
+}
+
+func (m *memBackend) Release(_ context.Context, key, _ string) error {
+	delete(m.held, key)
+	return nil
+}
+
+func TestStoreFallsBack(t *testing.T) {
+	ctx := context.Background()
+	primary := &memBackend{held: map[string]string{}}
+	fallback := &memBackend{held: map[string]string{}}
+	s := &Store{Primary: primary, Fallback: fallback, Lock: time.Minute, TTL: time.Hour}
+
+	if _, err := s.Begin(ctx, "a", "h"); err != nil {
+		t.Fatal(err)
+	}
+	// Logical outcomes of the primary are final.
+	if _, err := s.Begin(ctx, "a", "other"); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("want ErrMismatch, got %v", err)
+	}
+	if len(fallback.held) != 0 {
+		t.Fatalf("fallback used for a healthy primary: %v", fallback.held)
+	}
+
+	primary.err = errors.New("redis down")
+	claim, err := s.Begin(ctx, "b", "h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fallback.held["b"] != "h" {
+		t.Fatalf("claim not taken in fallback: %v", fallback.held)
+	}
+	if err := claim.Release(ctx); err != nil || len(fallback.held) != 0 {
+		t.Fatalf("release did not go to the claiming backend: err=%v held=%v", err, fallback.held)
+	}
+}
@@ -0,0 +1,127 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Manager runs the HTTP server and tears the process down in a fixed order
+// once the run context is cancelled:
+//
+//  1. stop accepting connections and drain in-flight requests
+//  2. cancel background workers started with Go and wait for them
+//  3. run OnStop hooks in registration order (e.g. Redis, then Postgres)
+//
+// All three phases share one DrainTimeout deadline.
+type Manager struct {
+	DrainTimeout time.Duration
+
+	bgCtx    context.Context
+	bgCancel context.CancelFunc
+	bg       sync.WaitGroup
+	hooks    []hook
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func New(drainTimeout time.Duration) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{DrainTimeout: drainTimeout, bgCtx: ctx, bgCancel: cancel}
+}
+
+// Go runs fn in the background. Its context is cancelled after the HTTP
+// server has drained, and shutdown waits for fn to return.
+func (m *Manager) Go(fn func(ctx context.Context)) {
+	m.bg.Add(1)
+	go func() {
+		defer m.bg.Done()
+		fn(m.bgCtx)
+	}()
+}
+
+// OnStop registers a closer that runs after background work has finished.
+func (m *Manager) OnStop(name string, fn func(ctx context.Context) error) {
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// Run serves srv until ctx is cancelled or the server fails, then shuts down.
+func (m *Manager) Run(ctx context.Context, srv *http.Server) error {
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		m.stop(context.Background())
+		return err
+	}
+	return m.Serve(ctx, srv, ln)
+}
+
+// Serve is Run with a caller-provided listener.
+func (m *Manager) Serve(ctx context.Context, srv *http.Server, ln net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("http server listening", "addr", ln.Addr().String())
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+		close(serveErr)
+	}()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		slog.Info("shutdown signal received, draining")
+	case err := <-serveErr:
+		if err != nil {
+			runErr = fmt.Errorf("http server: %w", err)
+		}
+	}
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), m.DrainTimeout)
+	defer cancel()
+
+	var errs []error
+	if runErr != nil {
+		errs = append(errs, runErr)
+	}
+	if err := srv.Shutdown(stopCtx); err != nil {
+		errs = append(errs, fmt.Errorf("http drain: %w", err))
+	}
+	errs = append(errs, m.stop(stopCtx)...)
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	slog.Info("shutdown complete")
+	return nil
+}
+
+func (m *Manager) stop(ctx context.Context) []error {
+	var errs []error
+
+	m.bgCancel()
+	done := make(chan struct{})
+	go func() {
+		m.bg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("background work: %w", ctx.Err()))
+	}
+
+	for _, h := range m.hooks {
+		if err := h.fn(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %w", h.name, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,74 @@
+package lifecycle
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServeDrainsInOrder(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		steps []string
+	)
+	record := func(s string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, s)
+	}
+
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		record("request")
+		_, _ = io.WriteString(w, "ok")
+	})}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(5 * time.Second)
+	m.Go(func(ctx context.Context) {
+		<-ctx.Done()
+		record("background")
+	})
+	m.OnStop("redis", func(context.Context) error { record("redis"); return nil })
+	m.OnStop("postgres", func(context.Context) error { record("postgres"); return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- m.Serve(ctx, srv, ln) }()
+
+	respErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+
+	<-started
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	if err := <-respErr; err != nil {
+		t.Fatalf("in-flight request was dropped: %v", err)
+	}
+
+	want := []string{"request", "background", "redis", "postgres"}
+	if !reflect.DeepEqual(steps, want) {
+		t.Fatalf("unexpected shutdown order: got=%v want=%v", steps, want)
+	}
+}
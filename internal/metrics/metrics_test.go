@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tfenng/scaffold/internal/cache"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+type fakeStore struct {
+	users map[int64]sqlc.User
+	err   error
+}
+
+func (f *fakeStore) Get(_ context.Context, id int64) (sqlc.User, bool, error) {
+	if f.err != nil {
+		return sqlc.User{}, false, f.err
+	}
+	u, ok := f.users[id]
+	return u, ok, nil
+}
+
+func (f *fakeStore) Set(_ context.Context, u sqlc.User) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.users[u.ID] = u
+	return nil
+}
+
+func (f *fakeStore) Del(_ context.Context, id int64) error {
+	delete(f.users, id)
+	return f.err
+}
+
+func TestInstrumentUserCache(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+	store := &fakeStore{users: map[int64]sqlc.User{}}
+	c := m.InstrumentUserCache(store)
+	ctx := context.Background()
+
+	_, _, _ = c.Get(ctx, 1)
+	_ = c.Set(ctx, sqlc.User{ID: 1})
+	_, _, _ = c.Get(ctx, 1)
+	_, _, _ = c.Get(ctx, 1)
+	store.err = errors.New("i/o timeout")
+	_, _, _ = c.Get(ctx, 1)
+	store.err = cache.ErrCircuitOpen
+	_, _, _ = c.Get(ctx, 1)
+
+	tests := []struct {
+		op, result string
+		want       float64
+	}{
+		{"get", "miss", 1},
+		{"get", "hit", 2},
+		{"get", "error", 1},
+		{"get", "bypass", 1},
+		{"set", "ok", 1},
+	}
+	for _, tc := range tests {
+		if got := testutil.ToFloat64(m.userCache.WithLabelValues(tc.op, tc.result)); got != tc.want {
+			t.Errorf("%s/%s: got=%v want=%v", tc.op, tc.result, got, tc.want)
+		}
+	}
+}
+
+func TestHandlerExposesHTTPMetrics(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+	m.ObserveHTTP("GET", "/users/:id", 200, 15*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+
+	want := `http_requests_total{method="GET",route="/users/:id",status="200"} 1`
+	if !strings.Contains(string(body), want) {
+		t.Fatalf("missing %q in:\n%s", want, body)
+	}
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterPool exports pool.Stat() on every scrape.
+func (m *Metrics) RegisterPool(pool *pgxpool.Pool) {
+	m.reg.MustRegister(&poolCollector{pool: pool})
+}
+
+var (
+	poolTotal       = prometheus.NewDesc("pgxpool_total_conns", "Connections currently in the pool.", nil, nil)
+	poolIdle        = prometheus.NewDesc("pgxpool_idle_conns", "Idle connections.", nil, nil)
+	poolAcquired    = prometheus.NewDesc("pgxpool_acquired_conns", "Connections checked out by callers.", nil, nil)
+	poolConstruct   = prometheus.NewDesc("pgxpool_constructing_conns", "Connections being established.", nil, nil)
+	poolMax         = prometheus.NewDesc("pgxpool_max_conns", "Configured maximum pool size.", nil, nil)
+	poolAcquires    = prometheus.NewDesc("pgxpool_acquires_total", "Successful acquires.", nil, nil)
+	poolAcquireWait = prometheus.NewDesc("pgxpool_acquire_duration_seconds_total", "Time spent acquiring connections.", nil, nil)
+	poolEmpty       = prometheus.NewDesc("pgxpool_empty_acquires_total", "Acquires that had to wait for a connection.", nil, nil)
+	poolCanceled    = prometheus.NewDesc("pgxpool_canceled_acquires_total", "Acquires canceled by their context.", nil, nil)
+	poolNew         = prometheus.NewDesc("pgxpool_new_conns_total", "Connections opened.", nil, nil)
+	poolLifetime    = prometheus.NewDesc("pgxpool_max_lifetime_destroys_total", "Connections closed for exceeding MaxConnLifetime.", nil, nil)
+	poolIdleDestroy = prometheus.NewDesc("pgxpool_max_idle_destroys_total", "Connections closed for exceeding MaxConnIdleTime.", nil, nil)
+)
+
+type poolCollector struct{ pool *pgxpool.Pool }
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		poolTotal, poolIdle, poolAcquired, poolConstruct, poolMax,
+		poolAcquires, poolAcquireWait, poolEmpty, poolCanceled, poolNew, poolLifetime, poolIdleDestroy,
+	} {
+		ch <- d
+	}
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	st := c.pool.Stat()
+	gauge := func(d *prometheus.Desc, v float64) { ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v) }
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+
+	gauge(poolTotal, float64(st.TotalConns()))
+	gauge(poolIdle, float64(st.IdleConns()))
+	gauge(poolAcquired, float64(st.AcquiredConns()))
+	gauge(poolConstruct, float64(st.ConstructingConns()))
+	gauge(poolMax, float64(st.MaxConns()))
+	counter(poolAcquires, float64(st.AcquireCount()))
+	counter(poolAcquireWait, st.AcquireDuration().Seconds())
+	counter(poolEmpty, float64(st.EmptyAcquireCount()))
+	counter(poolCanceled, float64(st.CanceledAcquireCount()))
+	counter(poolNew, float64(st.NewConnsCount()))
+	counter(poolLifetime, float64(st.MaxLifetimeDestroyCount()))
+	counter(poolIdleDestroy, float64(st.MaxIdleDestroyCount()))
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tfenng/scaffold/internal/cache"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+// InstrumentUserCache counts hits, misses, errors and writes of next.
+// Calls skipped by an open circuit are counted as "bypass".
+func (m *Metrics) InstrumentUserCache(next cache.UserStore) cache.UserStore {
+	return &userCache{next: next, m: m}
+}
+
+type userCache struct {
+	next cache.UserStore
+	m    *Metrics
+}
+
+func (c *userCache) Get(ctx context.Context, id int64) (sqlc.User, bool, error) {
+	u, ok, err := c.next.Get(ctx, id)
+	switch {
+	case err != nil:
+		c.count("get", err)
+	case ok:
+		c.m.userCache.WithLabelValues("get", "hit").Inc()
+	default:
+		c.m.userCache.WithLabelValues("get", "miss").Inc()
+	}
+	return u, ok, err
+}
+
+func (c *userCache) Set(ctx context.Context, u sqlc.User) error {
+	err := c.next.Set(ctx, u)
+	c.count("set", err)
+	return err
+}
+
+func (c *userCache) Del(ctx context.Context, id int64) error {
+	err := c.next.Del(ctx, id)
+	c.count("del", err)
+	return err
+}
+
+func (c *userCache) count(op string, err error) {
+	result := "ok"
+	if errors.Is(err, cache.ErrCircuitOpen) {
+		result = "bypass"
+	} else if err != nil {
+		result = "error"
+	}
+	c.m.userCache.WithLabelValues(op, result).Inc()
+}
@@ -0,0 +1,40 @@
+// Package patch carries partial updates from the HTTP layer down to SQL.
+package patch
+
+import "encoding/json"
+
+// Field is a tri-state value: absent (the zero Field), explicitly null, or
+// set to Value. Decoded from JSON, a missing key leaves the field absent and
+// null clears it, matching RFC 7396 merge patch semantics.
+type Field[T any] struct {
+	Present bool
+	Null    bool
+	Value   T
+}
+
+// Set returns a field holding v.
+func Set[T any](v T) Field[T] { return Field[T]{Present: true, Value: v} }
+
+// Clear returns an explicitly null field.
+func Clear[T any]() Field[T] { return Field[T]{Present: true, Null: true} }
+
+// IsSet reports whether the field holds a non-null value.
+func (f Field[T]) IsSet() bool { return f.Present && !f.Null }
+
+// Ptr returns the value, or nil when the field is absent or null.
+func (f Field[T]) Ptr() *T {
+	if !f.IsSet() {
+		return nil
+	}
+	v := f.Value
+	return &v
+}
+
+func (f *Field[T]) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*f = Clear[T]()
+		return nil
+	}
+	f.Present, f.Null = true, false
+	return json.Unmarshal(b, &f.Value)
+}
@@ -0,0 +1,26 @@
+package patch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFieldUnmarshal(t *testing.T) {
+	var body struct {
+		Absent  Field[string] `json:"absent"`
+		Cleared Field[string] `json:"cleared"`
+		Set     Field[string] `json:"set"`
+	}
+	if err := json.Unmarshal([]byte(`{"cleared":null,"set":"x"}`), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Absent.Present {
+		t.Fatalf("absent key decoded as present: %+v", body.Absent)
+	}
+	if !body.Cleared.Present || !body.Cleared.Null || body.Cleared.Ptr() != nil {
+		t.Fatalf("null not decoded as cleared: %+v", body.Cleared)
+	}
+	if !body.Set.IsSet() || *body.Set.Ptr() != "x" {
+		t.Fatalf("value not decoded: %+v", body.Set)
+	}
+}
@@ -0,0 +1,62 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+type APIKeyRepo interface {
+	Create(ctx context.Context, name, prefix, keyHash string, scopes []string, createdBy string, expiresAt *time.Time) (sqlc.ApiKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (sqlc.ApiKey, error)
+	List(ctx context.Context) ([]sqlc.ApiKey, error)
+	// Revoke returns the prefix of the revoked key, or pgx.ErrNoRows when the
+	// key does not exist or was already revoked.
+	Revoke(ctx context.Context, id int64) (string, error)
+	Touch(ctx context.Context, id int64) error
+}
+
+type apiKeyRepo struct{ pool *pgxpool.Pool }
+
+func NewAPIKeyRepo(pool *pgxpool.Pool) APIKeyRepo { return &apiKeyRepo{pool: pool} }
+
+func (r *apiKeyRepo) q(ctx context.Context) *sqlc.Queries {
+	if tx, ok := TxFrom(ctx); ok {
+		return sqlc.New(tx)
+	}
+	return sqlc.New(r.pool)
+}
+
+func (r *apiKeyRepo) Create(ctx context.Context, name, prefix, keyHash string, scopes []string, createdBy string, expiresAt *time.Time) (sqlc.ApiKey, error) {
+	exp := pgtype.Timestamptz{}
+	if expiresAt != nil {
+		exp = pgtype.Timestamptz{Time: *expiresAt, Valid: true}
+	}
+	return r.q(ctx).CreateApiKey(ctx, sqlc.CreateApiKeyParams{
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   keyHash,
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		ExpiresAt: exp,
+	})
+}
+
+func (r *apiKeyRepo) GetByPrefix(ctx context.Context, prefix string) (sqlc.ApiKey, error) {
+	return r.q(ctx).GetApiKeyByPrefix(ctx, prefix)
+}
+
+func (r *apiKeyRepo) List(ctx context.Context) ([]sqlc.ApiKey, error) {
+	return r.q(ctx).ListApiKeys(ctx)
+}
+
+func (r *apiKeyRepo) Revoke(ctx context.Context, id int64) (string, error) {
+	return r.q(ctx).RevokeApiKey(ctx, id)
+}
+
+func (r *apiKeyRepo) Touch(ctx context.Context, id int64) error {
+	return r.q(ctx).TouchApiKey(ctx, id)
+}
@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+type AuditEvent struct {
+	Actor     string
+	Action    string
+	TargetID  int64
+	TargetUID string
+	// Changes is the JSON-encoded field diff.
+	Changes   []byte
+	RequestID string
+}
+
+type AuditRepo interface {
+	// Record writes e in the transaction carried by ctx, if any.
+	Record(ctx context.Context, e AuditEvent) error
+	// RecordMany writes events with COPY, for bulk mutations.
+	RecordMany(ctx context.Context, events []AuditEvent) error
+	ListByTarget(ctx context.Context, targetID int64, page, pageSize int32) (Page[sqlc.AuditEvent], error)
+}
+
+type auditRepo struct{ pool *pgxpool.Pool }
+
+func NewAuditRepo(pool *pgxpool.Pool) AuditRepo { return &auditRepo{pool: pool} }
+
+func (r *auditRepo) q(ctx context.Context) *sqlc.Queries {
+	if tx, ok := TxFrom(ctx); ok {
+		return sqlc.New(tx)
+	}
+	return sqlc.New(r.pool)
+}
+
+func (r *auditRepo) Record(ctx context.Context, e AuditEvent) error {
+	return r.q(ctx).CreateAuditEvent(ctx, sqlc.CreateAuditEventParams(e.params()))
+}
+
+func (r *auditRepo) RecordMany(ctx context.Context, events []AuditEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	rows := make([]sqlc.CreateAuditEventsParams, len(events))
+	for i, e := range events {
+		rows[i] = e.params()
+	}
+	_, err := r.q(ctx).CreateAuditEvents(ctx, rows)
+	return err
+}
+
+func (e AuditEvent) params() sqlc.CreateAuditEventsParams {
+	reqID := &e.RequestID
+	if e.RequestID == "" {
+		reqID = nil
+	}
+	return sqlc.CreateAuditEventsParams{
+		Actor:     e.Actor,
+		Action:    e.Action,
+		TargetID:  e.TargetID,
+		TargetUid: e.TargetUID,
+		Changes:   e.Changes,
+		RequestID: toPgtypeText(reqID),
+	}
+}
+
+func (r *auditRepo) ListByTarget(ctx context.Context, targetID int64, page, pageSize int32) (Page[sqlc.AuditEvent], error) {
+	page, pageSize = normalizePage(page, pageSize)
+
+	total, err := r.q(ctx).CountAuditEventsByTarget(ctx, targetID)
+	if err != nil {
+		return Page[sqlc.AuditEvent]{}, err
+	}
+	items, err := r.q(ctx).ListAuditEventsByTarget(ctx, sqlc.ListAuditEventsByTargetParams{
+		TargetID: targetID,
+		Limit:    pageSize,
+		Offset:   (page - 1) * pageSize,
+	})
+	if err != nil {
+		return Page[sqlc.AuditEvent]{}, err
+	}
+	if items == nil {
+		items = []sqlc.AuditEvent{}
+	}
+
+	totalPages := int32((total + int64(pageSize) - 1) / int64(pageSize))
+	return Page[sqlc.AuditEvent]{Items: items, Total: total, Page: page, PageSize: pageSize, TotalPages: totalPages}, nil
+}
@@ -0,0 +1,82 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+// CredentialRepo stores password hashes and refresh tokens of local accounts.
+type CredentialRepo interface {
+	Get(ctx context.Context, userID int64) (sqlc.UserCredential, error)
+	Create(ctx context.Context, userID int64, passwordHash string, roles []string) error
+	// SetPassword replaces the hash and records the change time.
+	SetPassword(ctx context.Context, userID int64, passwordHash string) error
+	// Rehash upgrades the stored hash of an unchanged password.
+	Rehash(ctx context.Context, userID int64, passwordHash string) error
+
+	CreateRefreshToken(ctx context.Context, userID int64, familyID, tokenHash string, expiresAt time.Time) (sqlc.RefreshToken, error)
+	// RefreshTokenForUpdate locks the token row for the rest of the transaction.
+	RefreshTokenForUpdate(ctx context.Context, tokenHash string) (sqlc.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, id, replacedBy int64) error
+	RevokeRefreshFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int64) error
+}
+
+type credentialRepo struct{ pool *pgxpool.Pool }
+
+func NewCredentialRepo(pool *pgxpool.Pool) CredentialRepo { return &credentialRepo{pool: pool} }
+
+func (r *credentialRepo) q(ctx context.Context) *sqlc.Queries {
+	if tx, ok := TxFrom(ctx); ok {
+		return sqlc.New(tx)
+	}
+	return sqlc.New(r.pool)
+}
+
+func (r *credentialRepo) Get(ctx context.Context, userID int64) (sqlc.UserCredential, error) {
+	return r.q(ctx).GetCredential(ctx, userID)
+}
+
+func (r *credentialRepo) Create(ctx context.Context, userID int64, passwordHash string, roles []string) error {
+	if roles == nil {
+		roles = []string{}
+	}
+	return r.q(ctx).CreateCredential(ctx, sqlc.CreateCredentialParams{UserID: userID, PasswordHash: passwordHash, Roles: roles})
+}
+
+func (r *credentialRepo) SetPassword(ctx context.Context, userID int64, passwordHash string) error {
+	return r.q(ctx).UpdatePasswordHash(ctx, sqlc.UpdatePasswordHashParams{UserID: userID, PasswordHash: passwordHash})
+}
+
+func (r *credentialRepo) Rehash(ctx context.Context, userID int64, passwordHash string) error {
+	return r.q(ctx).RehashPassword(ctx, sqlc.RehashPasswordParams{UserID: userID, PasswordHash: passwordHash})
+}
+
+func (r *credentialRepo) CreateRefreshToken(ctx context.Context, userID int64, familyID, tokenHash string, expiresAt time.Time) (sqlc.RefreshToken, error) {
+	return r.q(ctx).CreateRefreshToken(ctx, sqlc.CreateRefreshTokenParams{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	})
+}
+
+func (r *credentialRepo) RefreshTokenForUpdate(ctx context.Context, tokenHash string) (sqlc.RefreshToken, error) {
+	return r.q(ctx).GetRefreshTokenForUpdate(ctx, tokenHash)
+}
+
+func (r *credentialRepo) RotateRefreshToken(ctx context.Context, id, replacedBy int64) error {
+	return r.q(ctx).RotateRefreshToken(ctx, sqlc.RotateRefreshTokenParams{ID: id, ReplacedBy: pgtype.Int8{Int64: replacedBy, Valid: true}})
+}
+
+func (r *credentialRepo) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	return r.q(ctx).RevokeRefreshTokenFamily(ctx, familyID)
+}
+
+func (r *credentialRepo) RevokeUserRefreshTokens(ctx context.Context, userID int64) error {
+	return r.q(ctx).RevokeUserRefreshTokens(ctx, userID)
+}
@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/idempotency"
+)
+
+// IdempotencyRepo is the Postgres idempotency.Backend. Rows are not expired
+// by the database, so DeleteExpired must run periodically.
+type IdempotencyRepo interface {
+	idempotency.Backend
+	idempotency.Sweeper
+}
+
+type idempotencyRepo struct{ pool *pgxpool.Pool }
+
+func NewIdempotencyRepo(pool *pgxpool.Pool) IdempotencyRepo { return &idempotencyRepo{pool: pool} }
+
+func (r *idempotencyRepo) q() *sqlc.Queries { return sqlc.New(r.pool) }
+
+func (r *idempotencyRepo) Claim(ctx context.Context, key, hash string, lock time.Duration) (*idempotency.Response, error) {
+	n, err := r.q().ClaimIdempotencyKey(ctx, sqlc.ClaimIdempotencyKeyParams{
+		Key:         key,
+		RequestHash: hash,
+		ExpiresAt:   pgtype.Timestamptz{Time: time.Now().Add(lock), Valid: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n == 1 {
+		return nil, nil
+	}
+
+	row, err := r.q().GetIdempotencyKey(ctx, key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, idempotency.ErrInFlight
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case row.RequestHash != hash:
+		return nil, idempotency.ErrMismatch
+	case !row.StatusCode.Valid:
+		return nil, idempotency.ErrInFlight
+	}
+	resp := &idempotency.Response{Status: int(row.StatusCode.Int32), Body: row.ResponseBody}
+	if len(row.ResponseHeader) > 0 {
+		if err := json.Unmarshal(row.ResponseHeader, &resp.Header); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (r *idempotencyRepo) Complete(ctx context.Context, key, _ string, resp idempotency.Response, ttl time.Duration) error {
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+	return r.q().CompleteIdempotencyKey(ctx, sqlc.CompleteIdempotencyKeyParams{
+		Key:            key,
+		StatusCode:     pgtype.Int4{Int32: int32(resp.Status), Valid: true},
+		ResponseHeader: header,
+		ResponseBody:   resp.Body,
+		ExpiresAt:      pgtype.Timestamptz{Time: time.Now().Add(ttl), Valid: true},
+	})
+}
+
+func (r *idempotencyRepo) Release(ctx context.Context, key, _ string) error {
+	return r.q().ReleaseIdempotencyKey(ctx, key)
+}
+
+func (r *idempotencyRepo) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	return r.q().DeleteExpiredIdempotencyKeys(ctx, pgtype.Timestamptz{Time: before, Valid: true})
+}
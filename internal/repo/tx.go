@@ -2,13 +2,23 @@ package repo
 
 import (
 	"context"
+	"errors"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+
+	"github.com/tfenng/scaffold/internal/logging"
+	"github.com/tfenng/scaffold/internal/tracing"
 )
 
 type TxManager interface {
 	WithinTx(ctx context.Context, fn func(ctx context.Context) error) error
+	// WithinSnapshot runs fn in a read-only REPEATABLE READ transaction:
+	// everything fn reads comes from the snapshot of its first query,
+	// however long it runs.
+	WithinSnapshot(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 type txKey struct{}
@@ -16,17 +26,36 @@ type txKey struct{}
 type PgxTxManager struct{ Pool *pgxpool.Pool }
 
 func (m PgxTxManager) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error {
-	tx, err := m.Pool.BeginTx(ctx, pgx.TxOptions{})
+	return m.within(ctx, pgx.TxOptions{}, fn)
+}
+
+func (m PgxTxManager) WithinSnapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	return m.within(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly}, fn)
+}
+
+func (m PgxTxManager) within(ctx context.Context, opts pgx.TxOptions, fn func(ctx context.Context) error) error {
+	ctx, span := tracing.Tracer().Start(ctx, "db.tx")
+	defer span.End()
+
+	tx, err := m.Pool.BeginTx(ctx, opts)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 	ctx = context.WithValue(ctx, txKey{}, tx)
 
 	if err := fn(ctx); err != nil {
-		_ = tx.Rollback(ctx)
+		span.SetAttributes(attribute.Bool("db.tx.rolled_back", true))
+		if rbErr := tx.Rollback(ctx); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+			logging.From(ctx).Warn("rollback failed", "err", rbErr, "cause", err)
+		}
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
-	return tx.Commit(ctx)
+	return nil
 }
 
 func TxFrom(ctx context.Context) (pgx.Tx, bool) {
@@ -0,0 +1,316 @@
+package repo
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+// FilterOp compares a user field with the condition's values.
+type FilterOp string
+
+const (
+	OpEq     FilterOp = "eq"
+	OpIn     FilterOp = "in"
+	OpPrefix FilterOp = "prefix"
+	// OpLike matches a case-insensitive substring.
+	OpLike FilterOp = "like"
+	OpGt   FilterOp = "gt"
+	OpGte  FilterOp = "gte"
+	OpLt   FilterOp = "lt"
+	OpLte  FilterOp = "lte"
+)
+
+// UserCond compares one field. Values are typed by the field: string for
+// text fields, time.Time for dates and timestamps, bool for has_email.
+// Only OpIn takes more than one value.
+type UserCond struct {
+	Field  string
+	Op     FilterOp
+	Values []any
+}
+
+// UserFilter selects users: every condition in All must hold, and when Any
+// is set so must every condition of at least one of its groups. The zero
+// value matches everyone.
+type UserFilter struct {
+	All []UserCond
+	Any [][]UserCond
+}
+
+// IsZero reports whether f matches everyone.
+func (f UserFilter) IsZero() bool { return len(f.All) == 0 && len(f.Any) == 0 }
+
+type filterKind int
+
+const (
+	kindText filterKind = iota
+	kindDate
+	kindTimestamp
+	kindBool
+)
+
+type filterField struct {
+	kind filterKind
+	ops  []FilterOp
+}
+
+var (
+	textOps  = []FilterOp{OpEq, OpIn, OpPrefix, OpLike}
+	rangeOps = []FilterOp{OpEq, OpGt, OpGte, OpLt, OpLte}
+)
+
+// userFilterFields is the whitelist of filterable fields; each one is also
+// the column it reads, except has_email.
+var userFilterFields = map[string]filterField{
+	"uid":        {kind: kindText, ops: textOps},
+	"email":      {kind: kindText, ops: textOps},
+	"name":       {kind: kindText, ops: textOps},
+	"used_name":  {kind: kindText, ops: textOps},
+	"company":    {kind: kindText, ops: textOps},
+	"birth":      {kind: kindDate, ops: rangeOps},
+	"created_at": {kind: kindTimestamp, ops: rangeOps[1:]},
+	"updated_at": {kind: kindTimestamp, ops: rangeOps[1:]},
+	"has_email":  {kind: kindBool, ops: []FilterOp{OpEq}},
+}
+
+const (
+	maxFilterConds  = 20
+	maxFilterGroups = 10
+	maxFilterValues = 100
+)
+
+// filterKey matches "field", "field[op]" and "or[n][field][op]".
+var filterKey = regexp.MustCompile(`^(?:or\[(\d+)\]\[([a-z_]+)\]|([a-z_]+))(?:\[([a-z]+)\])?$`)
+
+// ParseUserFilter reads the filter from query parameters such as
+// birth[gte]=1990-01-01&company[in]=a,b. A bare field means eq, and
+// conditions under or[n] form the n-th OR group. name_like=x is kept as
+// an alias of name[like]=x. Parameters that are not filters (page, sort...)
+// are ignored; invalid filters come back as one error with a violation per
+// parameter.
+func ParseUserFilter(q url.Values) (UserFilter, error) {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	// Map order is random; keep violations and cursor digests stable.
+	slices.Sort(keys)
+
+	var (
+		f          UserFilter
+		violations []domain.FieldViolation
+		groups     = map[int][]UserCond{}
+		count      int
+	)
+	fail := func(param, rule, msg string) {
+		violations = append(violations, domain.FieldViolation{Field: param, Rule: rule, Message: msg})
+	}
+keys:
+	for _, key := range keys {
+		m := filterKey.FindStringSubmatch(key)
+		if m == nil {
+			continue
+		}
+		group, name, op := m[1], m[2]+m[3], FilterOp(m[4])
+		if name == "name_like" && group == "" && op == "" {
+			name, op = "name", OpLike
+		}
+		field, ok := userFilterFields[name]
+		if !ok {
+			if group != "" || op != "" {
+				fail(key, "oneof", "is not a filterable field")
+			}
+			continue
+		}
+		if op == "" {
+			op = OpEq
+		}
+		if !slices.Contains(field.ops, op) {
+			fail(key, "operator", "does not support "+string(op))
+			continue
+		}
+
+		for _, raw := range q[key] {
+			if (op == OpLike || op == OpPrefix) && strings.TrimSpace(raw) == "" {
+				// Matches everyone, as an empty name_like always has.
+				continue
+			}
+			c, err := parseUserCond(name, field, op, raw)
+			if err != nil {
+				fail(key, err.rule, err.msg)
+				continue
+			}
+			if count++; count > maxFilterConds {
+				// Reported once; the conditions past it are not looked at.
+				fail(key, "max", fmt.Sprintf("filters are limited to %d conditions", maxFilterConds))
+				break keys
+			}
+			if group == "" {
+				f.All = append(f.All, c)
+				continue
+			}
+			n, err2 := strconv.Atoi(group)
+			if err2 != nil || n >= maxFilterGroups {
+				fail(key, "max", fmt.Sprintf("group must be between 0 and %d", maxFilterGroups-1))
+				break
+			}
+			groups[n] = append(groups[n], c)
+		}
+	}
+	if len(violations) > 0 {
+		parts := make([]string, len(violations))
+		for i, v := range violations {
+			parts[i] = v.Field + " " + v.Message
+		}
+		return UserFilter{}, domain.InvalidFields(strings.Join(parts, "; "), violations...)
+	}
+
+	for n := range maxFilterGroups {
+		if g, ok := groups[n]; ok {
+			f.Any = append(f.Any, g)
+		}
+	}
+	return f, nil
+}
+
+type condError struct{ rule, msg string }
+
+func parseUserCond(name string, field filterField, op FilterOp, raw string) (UserCond, *condError) {
+	raws := []string{raw}
+	if op == OpIn {
+		raws = strings.Split(raw, ",")
+		if len(raws) > maxFilterValues {
+			return UserCond{}, &condError{"max", fmt.Sprintf("must list at most %d values", maxFilterValues)}
+		}
+	}
+
+	c := UserCond{Field: name, Op: op, Values: make([]any, 0, len(raws))}
+	for _, r := range raws {
+		r = strings.TrimSpace(r)
+		switch field.kind {
+		case kindText:
+			if r == "" && op == OpIn {
+				return UserCond{}, &condError{"required", "must not contain empty values"}
+			}
+			c.Values = append(c.Values, r)
+		case kindDate:
+			t, err := time.Parse(time.DateOnly, r)
+			if err != nil {
+				return UserCond{}, &condError{"date", "must be in YYYY-MM-DD format"}
+			}
+			c.Values = append(c.Values, t)
+		case kindTimestamp:
+			t, err := time.Parse(time.RFC3339Nano, r)
+			if err != nil {
+				if t, err = time.Parse(time.DateOnly, r); err != nil {
+					return UserCond{}, &condError{"datetime", "must be an RFC 3339 timestamp or YYYY-MM-DD"}
+				}
+			}
+			c.Values = append(c.Values, t)
+		case kindBool:
+			b, err := strconv.ParseBool(r)
+			if err != nil {
+				return UserCond{}, &condError{"boolean", "must be true or false"}
+			}
+			c.Values = append(c.Values, b)
+		}
+	}
+	return c, nil
+}
+
+// String renders f canonically: equal filters render the same whatever the
+// order their parameters came in.
+func (f UserFilter) String() string {
+	render := func(conds []UserCond) string {
+		parts := make([]string, len(conds))
+		for i, c := range conds {
+			vals := make([]string, len(c.Values))
+			for j, v := range c.Values {
+				if t, ok := v.(time.Time); ok {
+					v = t.UTC().Format(time.RFC3339Nano)
+				}
+				vals[j] = strconv.Quote(fmt.Sprint(v))
+			}
+			parts[i] = c.Field + "[" + string(c.Op) + "]=" + strings.Join(vals, ",")
+		}
+		slices.Sort(parts)
+		return strings.Join(parts, "&")
+	}
+	groups := make([]string, len(f.Any))
+	for i, g := range f.Any {
+		groups[i] = "(" + render(g) + ")"
+	}
+	slices.Sort(groups)
+	return render(f.All) + "|" + strings.Join(groups, "|")
+}
+
+// where binds f's values and returns its conditions, to be ANDed.
+func (q *sqlQuery) where(f UserFilter) []string {
+	conds := make([]string, 0, len(f.All)+1)
+	for _, c := range f.All {
+		conds = append(conds, q.cond(c))
+	}
+	if len(f.Any) > 0 {
+		groups := make([]string, len(f.Any))
+		for i, g := range f.Any {
+			terms := make([]string, len(g))
+			for j, c := range g {
+				terms[j] = q.cond(c)
+			}
+			groups[i] = "(" + strings.Join(terms, " AND ") + ")"
+		}
+		conds = append(conds, "("+strings.Join(groups, " OR ")+")")
+	}
+	return conds
+}
+
+func (q *sqlQuery) cond(c UserCond) string {
+	if c.Field == "has_email" {
+		if c.Values[0].(bool) {
+			return "email IS NOT NULL"
+		}
+		return "email IS NULL"
+	}
+
+	col := quoteIdent(c.Field)
+	cast := ""
+	switch userFilterFields[c.Field].kind {
+	case kindDate:
+		cast = "::date"
+	case kindTimestamp:
+		cast = "::timestamptz"
+	}
+	switch c.Op {
+	case OpIn:
+		vals := make([]string, len(c.Values))
+		for i, v := range c.Values {
+			vals[i] = v.(string)
+		}
+		return col + " = ANY(" + q.arg(vals) + "::text[])"
+	case OpPrefix:
+		return col + " LIKE " + q.arg(escapeLike(c.Values[0].(string))+"%")
+	case OpLike:
+		return col + " ILIKE " + q.arg("%"+escapeLike(c.Values[0].(string))+"%")
+	case OpGt:
+		return col + " > " + q.arg(c.Values[0]) + cast
+	case OpGte:
+		return col + " >= " + q.arg(c.Values[0]) + cast
+	case OpLt:
+		return col + " < " + q.arg(c.Values[0]) + cast
+	case OpLte:
+		return col + " <= " + q.arg(c.Values[0]) + cast
+	}
+	return col + " = " + q.arg(c.Values[0]) + cast
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike makes s match literally inside a LIKE pattern.
+func escapeLike(s string) string { return likeEscaper.Replace(s) }
@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tfenng/scaffold/internal/domain"
+)
+
+func TestParseUserFilter(t *testing.T) {
+	q, _ := url.ParseQuery("birth[gte]=1990-01-01&company[in]=a,b&uid[prefix]=emp_&name_like=jo" +
+		"&or[0][has_email]=false&or[1][used_name][like]=50%25&or[1][created_at][lt]=2026-01-01T00:00:00Z&page=2&sort=-name")
+	f, err := ParseUserFilter(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := UserFilter{
+		All: []UserCond{
+			{Field: "birth", Op: OpGte, Values: []any{time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)}},
+			{Field: "company", Op: OpIn, Values: []any{"a", "b"}},
+			{Field: "name", Op: OpLike, Values: []any{"jo"}},
+			{Field: "uid", Op: OpPrefix, Values: []any{"emp_"}},
+		},
+		Any: [][]UserCond{
+			{{Field: "has_email", Op: OpEq, Values: []any{false}}},
+			{
+				{Field: "created_at", Op: OpLt, Values: []any{time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}},
+				{Field: "used_name", Op: OpLike, Values: []any{"50%"}},
+			},
+		},
+	}
+	if !reflect.DeepEqual(f, want) {
+		t.Fatalf("got  %+v\nwant %+v", f, want)
+	}
+
+	var q2 sqlQuery
+	q2.whereUsers(UserListFilter{Where: f})
+	wantSQL := ` WHERE "birth" >= $1::date AND "company" = ANY($2::text[]) AND "name" ILIKE $3 AND "uid" LIKE $4` +
+		` AND ((email IS NULL) OR ("created_at" < $5::timestamptz AND "used_name" ILIKE $6)) AND deleted_at IS NULL`
+	if q2.String() != wantSQL {
+		t.Fatalf("got\n%s\nwant\n%s", q2.String(), wantSQL)
+	}
+	wantArgs := []any{want.All[0].Values[0], []string{"a", "b"}, "%jo%", `emp\_%`, want.Any[1][0].Values[0], `%50\%%`}
+	if !reflect.DeepEqual(q2.args, wantArgs) {
+		t.Fatalf("args: got %#v", q2.args)
+	}
+}
+
+func TestParseUserFilterViolations(t *testing.T) {
+	q, _ := url.ParseQuery("birth[gte]=01/01/1990&created_at[eq]=2026-01-01&has_email=maybe&password[eq]=x&or[10][uid]=a&include_deleted=true")
+	_, err := ParseUserFilter(q)
+
+	var ae *domain.AppError
+	if !errors.As(err, &ae) || ae.Code != domain.CodeInvalidArgument {
+		t.Fatalf("got %v", err)
+	}
+	got := map[string]string{}
+	for _, v := range ae.Fields {
+		got[v.Field] = v.Rule
+	}
+	want := map[string]string{
+		"birth[gte]":     "date",
+		"created_at[eq]": "operator",
+		"has_email":      "boolean",
+		"password[eq]":   "oneof",
+		"or[10][uid]":    "max",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("violations: got %v, want %v", got, want)
+	}
+}
+
+func TestParseUserFilterLimitReportedOnce(t *testing.T) {
+	q := url.Values{}
+	for i := range maxFilterConds + 5 {
+		q.Add("uid[prefix]", fmt.Sprint(i))
+		q.Add("name[like]", fmt.Sprint(i))
+	}
+	_, err := ParseUserFilter(q)
+
+	var ae *domain.AppError
+	if !errors.As(err, &ae) || len(ae.Fields) != 1 || ae.Fields[0].Rule != "max" {
+		t.Fatalf("want a single max violation, got %v", err)
+	}
+}
+
+func TestUserFilterStringIsCanonical(t *testing.T) {
+	a, _ := url.ParseQuery("company=x&uid[prefix]=a&or[0][name]=n&or[1][name]=m")
+	b, _ := url.ParseQuery("or[3][name]=m&uid[prefix]=a&or[1][name]=n&company=x")
+	fa, _ := ParseUserFilter(a)
+	fb, _ := ParseUserFilter(b)
+	if fa.String() != fb.String() {
+		t.Fatalf("%q != %q", fa.String(), fb.String())
+	}
+}
@@ -0,0 +1,282 @@
+package repo
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+// A user list's WHERE and ORDER BY depend on the request, which sqlc cannot
+// express, so the query repo builds its SQL here. Identifiers only ever come from
+// userSortColumns and userFilterFields, and every value is a bind parameter.
+
+// userColumns is the select list of a user listing, in scanUsers order.
+const userColumns = "id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version"
+
+// sortColumn is a column the user list can be sorted by.
+type sortColumn struct {
+	// cast is the column type; keyset values travel as text and are cast
+	// back in SQL.
+	cast     string
+	nullable bool
+	// text renders a row's value in a form cast accepts, nil for NULL.
+	text func(u sqlc.User) *string
+}
+
+var userSortColumns = map[string]sortColumn{
+	"name":       {cast: "text", text: func(u sqlc.User) *string { return &u.Name }},
+	"uid":        {cast: "text", text: func(u sqlc.User) *string { return &u.Uid }},
+	"company":    {cast: "text", nullable: true, text: func(u sqlc.User) *string { return pgText(u.Company) }},
+	"birth":      {cast: "date", nullable: true, text: func(u sqlc.User) *string { return pgDateText(u.Birth) }},
+	"created_at": {cast: "timestamptz", text: func(u sqlc.User) *string { return pgTimestamptzText(u.CreatedAt) }},
+	"updated_at": {cast: "timestamptz", text: func(u sqlc.User) *string { return pgTimestamptzText(u.UpdatedAt) }},
+}
+
+// userSortFields lists userSortColumns for error messages.
+const userSortFields = "name, uid, company, birth, created_at, updated_at"
+
+// SortKey orders by one column, ascending unless Desc.
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// UserSort orders a user list. id always breaks ties, in the direction of
+// the last key, so a sort is total and keyset pages never skip rows. NULLs
+// sort as if larger than any value: last ascending, first descending.
+type UserSort []SortKey
+
+// DefaultUserSort lists the newest users first.
+var DefaultUserSort = UserSort{{Field: "created_at", Desc: true}}
+
+// ParseUserSort parses a sort parameter such as "-updated_at,name": a
+// comma-separated list of fields, each descending when prefixed with "-".
+// An empty string returns nil, which means DefaultUserSort.
+func ParseUserSort(s string) (UserSort, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var out UserSort
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		k := SortKey{Field: part}
+		if f, ok := strings.CutPrefix(part, "-"); ok {
+			k = SortKey{Field: f, Desc: true}
+		} else if f, ok := strings.CutPrefix(part, "+"); ok {
+			k.Field = f
+		}
+		if _, ok := userSortColumns[k.Field]; !ok {
+			return nil, domain.InvalidField("sort", "oneof", "must be a comma-separated list of "+userSortFields+", each optionally prefixed with -")
+		}
+		if seen[k.Field] {
+			return nil, domain.InvalidField("sort", "unique", "must not repeat "+k.Field)
+		}
+		seen[k.Field] = true
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+func (s UserSort) orDefault() UserSort {
+	if len(s) == 0 {
+		return DefaultUserSort
+	}
+	return s
+}
+
+// String formats s the way ParseUserSort reads it.
+func (s UserSort) String() string {
+	parts := make([]string, len(s.orDefault()))
+	for i, k := range s.orDefault() {
+		parts[i] = k.Field
+		if k.Desc {
+			parts[i] = "-" + k.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// Keyset returns u's position in the list sorted by s.
+func (s UserSort) Keyset(u sqlc.User) Keyset {
+	s = s.orDefault()
+	k := Keyset{Values: make([]*string, len(s)), ID: u.ID}
+	for i, key := range s {
+		k.Values[i] = userSortColumns[key.Field].text(u)
+	}
+	return k
+}
+
+// sqlQuery accumulates SQL text and its bind parameters.
+type sqlQuery struct {
+	strings.Builder
+	args []any
+}
+
+// arg binds v and returns its placeholder.
+func (q *sqlQuery) arg(v any) string {
+	q.args = append(q.args, v)
+	return "$" + strconv.Itoa(len(q.args))
+}
+
+func (q *sqlQuery) whereUsers(f UserListFilter) {
+	conds := q.where(f.Where)
+	if !f.IncludeDeleted {
+		// Spelled out rather than bound so the partial sort indexes apply.
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	if len(conds) > 0 {
+		q.WriteString(" WHERE ")
+		q.WriteString(strings.Join(conds, " AND "))
+	}
+}
+
+// keysetArg binds the text form of a sort value, cast back to the column.
+func (q *sqlQuery) keysetArg(c sortColumn, v string) string {
+	p := q.arg(v) + "::text"
+	if c.cast != "text" {
+		p += "::" + c.cast
+	}
+	return p
+}
+
+// orderBy writes the ORDER BY for s, or its exact reverse.
+func (q *sqlQuery) orderBy(s UserSort, reverse bool) {
+	dir := func(desc bool) string {
+		if desc != reverse {
+			return " DESC"
+		}
+		return " ASC"
+	}
+	q.WriteString(" ORDER BY ")
+	for _, k := range s {
+		q.WriteString(quoteIdent(k.Field))
+		q.WriteString(dir(k.Desc))
+		q.WriteString(", ")
+	}
+	q.WriteString("id")
+	q.WriteString(dir(s[len(s)-1].Desc))
+}
+
+// after writes a condition matching the rows that follow k in the order s
+// (precede it when reverse).
+func (q *sqlQuery) after(s UserSort, k Keyset, reverse bool) {
+	desc := s[len(s)-1].Desc != reverse
+	uniform, nullable := true, false
+	for i, key := range s {
+		uniform = uniform && key.Desc == s[0].Desc
+		nullable = nullable || userSortColumns[key.Field].nullable || k.Values[i] == nil
+	}
+
+	// A row comparison is what lets the planner seek the index.
+	if uniform && !nullable {
+		cols, vals := make([]string, 0, len(s)+1), make([]string, 0, len(s)+1)
+		for i, key := range s {
+			cols = append(cols, quoteIdent(key.Field))
+			vals = append(vals, q.keysetArg(userSortColumns[key.Field], *k.Values[i]))
+		}
+		cols, vals = append(cols, "id"), append(vals, q.arg(k.ID))
+		op := " > "
+		if desc {
+			op = " < "
+		}
+		q.WriteString(" AND (" + strings.Join(cols, ", ") + ")" + op + "(" + strings.Join(vals, ", ") + ")")
+		return
+	}
+
+	// Otherwise: a OR (a = x AND b) OR (a = x AND b = y AND id), where each
+	// term says the column follows k's value in its own direction.
+	var terms []string
+	var equal []string
+	for i, key := range s {
+		col := quoteIdent(key.Field)
+		c := userSortColumns[key.Field]
+		var val string
+		if k.Values[i] != nil {
+			val = q.keysetArg(c, *k.Values[i])
+		}
+
+		var follows string
+		switch {
+		case key.Desc != reverse && val == "":
+			follows = col + " IS NOT NULL"
+		case key.Desc != reverse:
+			follows = col + " < " + val
+		case val == "":
+			// Nothing follows NULL ascending.
+		case c.nullable:
+			follows = "(" + col + " > " + val + " OR " + col + " IS NULL)"
+		default:
+			follows = col + " > " + val
+		}
+		if follows != "" {
+			terms = append(terms, "("+strings.Join(append(equal[:len(equal):len(equal)], follows), " AND ")+")")
+		}
+
+		if val == "" {
+			equal = append(equal, col+" IS NULL")
+		} else {
+			equal = append(equal, col+" = "+val)
+		}
+	}
+	op := " > "
+	if desc {
+		op = " < "
+	}
+	terms = append(terms, "("+strings.Join(append(equal, "id"+op+q.arg(k.ID)), " AND ")+")")
+	q.WriteString(" AND (" + strings.Join(terms, " OR ") + ")")
+}
+
+// quoteIdent quotes a whitelisted column name.
+func quoteIdent(name string) string { return pgx.Identifier{name}.Sanitize() }
+
+func scanUsers(rows pgx.Rows) ([]sqlc.User, error) {
+	defer rows.Close()
+	var users []sqlc.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// scanUser reads a row selected with userColumns.
+func scanUser(rows pgx.Rows) (sqlc.User, error) {
+	var u sqlc.User
+	err := rows.Scan(
+		&u.ID, &u.Uid, &u.Email, &u.Name, &u.UsedName, &u.Company, &u.Birth,
+		&u.CreatedAt, &u.UpdatedAt, &u.DeletedAt, &u.Version,
+	)
+	return u, err
+}
+
+func pgText(v pgtype.Text) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
+
+func pgDateText(v pgtype.Date) *string {
+	if !v.Valid {
+		return nil
+	}
+	s := v.Time.Format("2006-01-02")
+	return &s
+}
+
+func pgTimestamptzText(v pgtype.Timestamptz) *string {
+	if !v.Valid {
+		return nil
+	}
+	s := v.Time.UTC().Format(time.RFC3339Nano)
+	return &s
+}
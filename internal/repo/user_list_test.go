@@ -0,0 +1,84 @@
+package repo
+
+import (
+	"testing"
+)
+
+func TestParseUserSort(t *testing.T) {
+	s, err := ParseUserSort("-updated_at, +name,uid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.String(); got != "-updated_at,name,uid" {
+		t.Fatalf("got %q", got)
+	}
+	if s, _ := ParseUserSort(""); s.String() != "-created_at" {
+		t.Fatalf("empty sort: got %q", s.String())
+	}
+	for _, in := range []string{"email", "name,-name", "name,", "name;drop table users"} {
+		if _, err := ParseUserSort(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestUserListSQL(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	cases := []struct {
+		name    string
+		sort    UserSort
+		key     Keyset
+		reverse bool
+		want    string
+	}{
+		{
+			name: "row comparison",
+			sort: DefaultUserSort,
+			key:  Keyset{Values: []*string{str("2026-01-01T00:00:00Z")}, ID: 7},
+			want: ` AND ("created_at", id) < ($1::text::timestamptz, $2) ORDER BY "created_at" DESC, id DESC`,
+		},
+		{
+			name:    "row comparison backwards",
+			sort:    UserSort{{Field: "name"}, {Field: "uid"}},
+			key:     Keyset{Values: []*string{str("Ann"), str("ann")}, ID: 7},
+			reverse: true,
+			want:    ` AND ("name", "uid", id) < ($1::text, $2::text, $3) ORDER BY "name" DESC, "uid" DESC, id DESC`,
+		},
+		{
+			name: "mixed directions",
+			sort: UserSort{{Field: "updated_at", Desc: true}, {Field: "name"}},
+			key:  Keyset{Values: []*string{str("2026-01-01T00:00:00Z"), str("Ann")}, ID: 7},
+			want: ` AND (("updated_at" < $1::text::timestamptz) OR ("updated_at" = $1::text::timestamptz AND "name" > $2::text)` +
+				` OR ("updated_at" = $1::text::timestamptz AND "name" = $2::text AND id > $3)) ORDER BY "updated_at" DESC, "name" ASC, id ASC`,
+		},
+		{
+			name: "nullable ascending",
+			sort: UserSort{{Field: "company"}},
+			key:  Keyset{Values: []*string{str("Acme")}, ID: 7},
+			want: ` AND ((("company" > $1::text OR "company" IS NULL)) OR ("company" = $1::text AND id > $2)) ORDER BY "company" ASC, id ASC`,
+		},
+		{
+			name: "null key ascending",
+			sort: UserSort{{Field: "birth"}},
+			key:  Keyset{Values: []*string{nil}, ID: 7},
+			want: ` AND (("birth" IS NULL AND id > $1)) ORDER BY "birth" ASC, id ASC`,
+		},
+		{
+			name: "null key descending",
+			sort: UserSort{{Field: "birth", Desc: true}},
+			key:  Keyset{Values: []*string{nil}, ID: 7},
+			want: ` AND (("birth" IS NOT NULL) OR ("birth" IS NULL AND id < $1)) ORDER BY "birth" DESC, id DESC`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var q sqlQuery
+			q.after(tc.sort, tc.key, tc.reverse)
+			q.orderBy(tc.sort, tc.reverse)
+			if q.String() != tc.want {
+				t.Fatalf("got\n%s\nwant\n%s", q.String(), tc.want)
+			}
+		})
+	}
+}
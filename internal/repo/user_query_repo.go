@@ -2,9 +2,10 @@ package repo
 
 import (
 	"context"
+	"slices"
 
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
 )
 
@@ -16,21 +17,84 @@ type Page[T any] struct {
 	TotalPages int32 `json:"total_pages"`
 }
 
+// CursorPage is a keyset page. NextCursor and PrevCursor are nil at either
+// end of the list; Total is only filled in when asked for.
+type CursorPage[T any] struct {
+	Items      []T     `json:"items"`
+	PageSize   int32   `json:"page_size"`
+	NextCursor *string `json:"next_cursor"`
+	PrevCursor *string `json:"prev_cursor"`
+	Total      *int64  `json:"total,omitempty"`
+}
+
+// Keyset is a row's position in a sorted user list: its sort column values
+// in text form (nil for NULL), then its id.
+type Keyset struct {
+	Values []*string
+	ID     int64
+}
+
+// UserScroll selects a keyset page. With neither After nor Before set it
+// returns the first page.
+type UserScroll struct {
+	// After lists the users that follow the key, Before the ones preceding
+	// it; the key itself is excluded.
+	After  *Keyset
+	Before *Keyset
+	// WithTotal also counts all matching users.
+	WithTotal bool
+}
+
+// Window is one keyset page in list order. More reports whether users
+// remain past it in the direction scrolled.
+type Window[T any] struct {
+	Items    []T
+	More     bool
+	PageSize int32
+	Total    *int64
+}
+
 type UserListFilter struct {
-	Email    *string
-	NameLike *string
+	Where UserFilter
+	// IncludeDeleted also lists soft-deleted users.
+	IncludeDeleted bool
+	// Sort defaults to DefaultUserSort when empty.
+	Sort     UserSort
 	Page     int32
 	PageSize int32
 }
 
 type UserQueryRepo interface {
 	List(ctx context.Context, f UserListFilter) (Page[sqlc.User], error)
+	// Scroll pages by keyset, seeking through the sort index instead of
+	// skipping rows; f.Page is ignored.
+	Scroll(ctx context.Context, f UserListFilter, s UserScroll) (Window[sqlc.User], error)
+	// Search ranks live users against free text with full-text and trigram
+	// matching. similarity sets pg_trgm.word_similarity_threshold, which
+	// `<%` uses, for the caller's transaction; 0 leaves it as the server has
+	// it.
+	Search(ctx context.Context, query string, limit int32, similarity float64) ([]UserSearchHit, error)
+	// Each calls fn for every user matching f, in f.Sort order, scanning
+	// rows as the database sends them rather than loading the list. f.Page
+	// and f.PageSize are ignored. It stops at fn's first error.
+	Each(ctx context.Context, f UserListFilter, fn func(sqlc.User) error) error
 }
 
 type userQueryRepo struct{ pool *pgxpool.Pool }
 
 func NewUserQueryRepo(pool *pgxpool.Pool) UserQueryRepo { return &userQueryRepo{pool: pool} }
 
+// normalizePage defaults page to 1 and page size to 20 (max 200).
+func normalizePage(page, size int32) (int32, int32) {
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 || size > 200 {
+		size = 20
+	}
+	return page, size
+}
+
 func toPgtypeText(s *string) pgtype.Text {
 	if s == nil {
 		return pgtype.Text{}
@@ -38,57 +102,123 @@ func toPgtypeText(s *string) pgtype.Text {
 	return pgtype.Text{String: *s, Valid: true}
 }
 
-func (r *userQueryRepo) q(ctx context.Context) *sqlc.Queries {
+func (r *userQueryRepo) db(ctx context.Context) sqlc.DBTX {
 	if tx, ok := TxFrom(ctx); ok {
-		return sqlc.New(tx)
+		return tx
 	}
-	return sqlc.New(r.pool)
+	return r.pool
 }
 
+func (r *userQueryRepo) q(ctx context.Context) *sqlc.Queries { return sqlc.New(r.db(ctx)) }
+
 func (r *userQueryRepo) List(ctx context.Context, f UserListFilter) (Page[sqlc.User], error) {
-	if f.Page <= 0 {
-		f.Page = 1
-	}
-	if f.PageSize <= 0 || f.PageSize > 200 {
-		f.PageSize = 20
-	}
+	f.Page, f.PageSize = normalizePage(f.Page, f.PageSize)
 	limit := f.PageSize
 	offset := (f.Page - 1) * f.PageSize
 
-	total, err := r.q(ctx).CountUsers(ctx, sqlc.CountUsersParams{
-		Email:    toPgtypeText(f.Email),
-		NameLike: toPgtypeText(f.NameLike),
-	})
+	total, err := r.count(ctx, f)
 	if err != nil {
 		return Page[sqlc.User]{}, err
 	}
 
-	items, err := r.q(ctx).ListUsers(ctx, sqlc.ListUsersParams{
-		Limit:    limit,
-		Offset:   offset,
-		Email:    toPgtypeText(f.Email),
-		NameLike: toPgtypeText(f.NameLike),
-	})
+	var q sqlQuery
+	// Named like a sqlc query so the span is too (tracing.PgxTracer).
+	q.WriteString("-- name: ListUsers :many\nSELECT " + userColumns + " FROM users")
+	q.whereUsers(f)
+	q.orderBy(f.Sort.orDefault(), false)
+	q.WriteString(" LIMIT " + q.arg(limit) + " OFFSET " + q.arg(offset))
+	rows, err := r.db(ctx).Query(ctx, q.String(), q.args...)
+	if err != nil {
+		return Page[sqlc.User]{}, err
+	}
+	users, err := scanUsers(rows)
 	if err != nil {
 		return Page[sqlc.User]{}, err
 	}
 
 	totalPages := int32((total + int64(limit) - 1) / int64(limit))
 
-	users := make([]sqlc.User, len(items))
-	for i, item := range items {
-		users[i] = sqlc.User{
-			ID:        item.ID,
-			Uid:       item.Uid,
-			Email:     item.Email,
-			Name:      item.Name,
-			UsedName:  item.UsedName,
-			Company:   item.Company,
-			Birth:     item.Birth,
-			CreatedAt: item.CreatedAt,
-			UpdatedAt: item.UpdatedAt,
+	return Page[sqlc.User]{Items: users, Total: total, Page: f.Page, PageSize: limit, TotalPages: totalPages}, nil
+}
+
+func (r *userQueryRepo) Scroll(ctx context.Context, f UserListFilter, s UserScroll) (Window[sqlc.User], error) {
+	_, size := normalizePage(f.Page, f.PageSize)
+	// One extra row tells whether there is another page.
+	limit := size + 1
+
+	sort := f.Sort.orDefault()
+	var q sqlQuery
+	q.WriteString("-- name: ScrollUsers :many\nSELECT " + userColumns + " FROM users")
+	q.whereUsers(f)
+	switch {
+	case s.After != nil:
+		q.after(sort, *s.After, false)
+	case s.Before != nil:
+		// Walk the index backwards from the key, then flip to list order.
+		q.after(sort, *s.Before, true)
+	}
+	q.orderBy(sort, s.Before != nil)
+	q.WriteString(" LIMIT " + q.arg(limit))
+	rows, err := r.db(ctx).Query(ctx, q.String(), q.args...)
+	if err != nil {
+		return Window[sqlc.User]{}, err
+	}
+	items, err := scanUsers(rows)
+	if err != nil {
+		return Window[sqlc.User]{}, err
+	}
+	if s.Before != nil {
+		slices.Reverse(items)
+	}
+
+	w := Window[sqlc.User]{PageSize: size}
+	if w.More = len(items) > int(size); w.More {
+		// Drop the probe row, which sits on the far side of the page.
+		if s.Before != nil {
+			items = items[1:]
+		} else {
+			items = items[:size]
 		}
 	}
+	w.Items = items
 
-	return Page[sqlc.User]{Items: users, Total: total, Page: f.Page, PageSize: limit, TotalPages: totalPages}, nil
+	if s.WithTotal {
+		total, err := r.count(ctx, f)
+		if err != nil {
+			return Window[sqlc.User]{}, err
+		}
+		w.Total = &total
+	}
+	return w, nil
+}
+
+func (r *userQueryRepo) Each(ctx context.Context, f UserListFilter, fn func(sqlc.User) error) error {
+	var q sqlQuery
+	q.WriteString("-- name: EachUser :many\nSELECT " + userColumns + " FROM users")
+	q.whereUsers(f)
+	q.orderBy(f.Sort.orDefault(), false)
+	rows, err := r.db(ctx).Query(ctx, q.String(), q.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *userQueryRepo) count(ctx context.Context, f UserListFilter) (int64, error) {
+	var q sqlQuery
+	q.WriteString("-- name: CountUsers :one\nSELECT COUNT(1) FROM users")
+	q.whereUsers(f)
+	var total int64
+	err := r.db(ctx).QueryRow(ctx, q.String(), q.args...).Scan(&total)
+	return total, err
 }
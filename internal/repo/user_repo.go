@@ -6,16 +6,87 @@ import (
 
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/tfenng/scaffold/internal/dberr"
+	"github.com/tfenng/scaffold/internal/domain"
 	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/patch"
 )
 
+// userConstraints names the users table constraints and indexes that can
+// surface to API callers.
+var userConstraints = dberr.Constraints{
+	"users_uid_unique":            domain.Conflict("uid already exists"),
+	"users_name_unique":           domain.Conflict("name already exists"),
+	"users_email_unique_not_null": domain.Conflict("email already exists"),
+	"users_email_key":             domain.Conflict("email already exists"),
+	"users_name_unique_live":      domain.Conflict("name already exists"),
+	"users_email_unique_live":     domain.Conflict("email already exists"),
+}
+
+func init() { dberr.Register(userConstraints) }
+
+// UserPatch lists the columns of a partial update; absent fields keep their
+// stored value and null ones are cleared.
+type UserPatch struct {
+	Email    patch.Field[string]
+	Name     patch.Field[string]
+	UsedName patch.Field[string]
+	Company  patch.Field[string]
+	Birth    patch.Field[time.Time]
+}
+
+// Empty reports whether the patch changes nothing.
+func (p UserPatch) Empty() bool {
+	return !p.Email.Present && !p.Name.Present && !p.UsedName.Present && !p.Company.Present && !p.Birth.Present
+}
+
+// NewUser is one user of a CreateMany.
+type NewUser struct {
+	Uid      string
+	Email    *string
+	Name     string
+	UsedName *string
+	Company  *string
+	Birth    *time.Time
+}
+
+// UserKeys holds the unique keys found taken by TakenKeys.
+type UserKeys struct {
+	UIDs   map[string]bool
+	Names  map[string]bool
+	Emails map[string]bool
+}
+
+// UserRepo only sees live users; deleted ones are reachable through Restore
+// and PurgeDeleted alone.
 type UserRepo interface {
 	GetByID(ctx context.Context, id int64) (sqlc.User, error)
+	// GetByIDForUpdate locks the row for the rest of the transaction.
+	GetByIDForUpdate(ctx context.Context, id int64) (sqlc.User, error)
 	GetByEmail(ctx context.Context, email string) (sqlc.User, error)
 	GetByUID(ctx context.Context, uid string) (sqlc.User, error)
 	Create(ctx context.Context, uid string, email *string, name string, usedName, company *string, birth *time.Time) (sqlc.User, error)
-	Update(ctx context.Context, id int64, email *string, name string, usedName, company *string, birth *time.Time) (sqlc.User, error)
-	Delete(ctx context.Context, id int64) error
+	// CreateMany inserts users in one statement, skipping those that would
+	// break a unique constraint, and returns the ones it inserted. Empty
+	// optional fields are stored as NULL.
+	CreateMany(ctx context.Context, users []NewUser) ([]sqlc.User, error)
+	// TakenKeys reports which of uids, names and emails already belong to
+	// a user. Deleted users keep their uid but free their name and email.
+	TakenKeys(ctx context.Context, uids, names, emails []string) (UserKeys, error)
+	// Update applies only while the row is still at version and returns
+	// pgx.ErrNoRows otherwise.
+	Update(ctx context.Context, id, version int64, email *string, name string, usedName, company *string, birth *time.Time) (sqlc.User, error)
+	// Patch writes only the fields present in p, with the same version
+	// check as Update.
+	Patch(ctx context.Context, id, version int64, p UserPatch) (sqlc.User, error)
+	// Delete soft-deletes a live user and returns it with DeletedAt set.
+	Delete(ctx context.Context, id int64) (sqlc.User, error)
+	// Restore undeletes a soft-deleted user and also returns when it had
+	// been deleted.
+	Restore(ctx context.Context, id int64) (sqlc.User, time.Time, error)
+	// PurgeDeleted hard-deletes at most limit users deleted before the
+	// cutoff and returns them. Rows locked by another purge are skipped.
+	PurgeDeleted(ctx context.Context, before time.Time, limit int32) ([]sqlc.User, error)
 }
 
 type userRepo struct{ pool *pgxpool.Pool }
@@ -36,6 +107,13 @@ func (r *userRepo) GetByID(ctx context.Context, id int64) (sqlc.User, error) {
 	}
 	return toUser(row)
 }
+func (r *userRepo) GetByIDForUpdate(ctx context.Context, id int64) (sqlc.User, error) {
+	row, err := r.q(ctx).GetUserByIDForUpdate(ctx, id)
+	if err != nil {
+		return sqlc.User{}, err
+	}
+	return toUser(sqlc.GetUserByIDRow(row))
+}
 func (r *userRepo) GetByEmail(ctx context.Context, email string) (sqlc.User, error) {
 	row, err := r.q(ctx).GetUserByEmail(ctx, pgtype.Text{String: email, Valid: true})
 	if err != nil {
@@ -65,7 +143,79 @@ func (r *userRepo) Create(ctx context.Context, uid string, email *string, name s
 	return toUserFromCreate(row)
 }
 
-func (r *userRepo) Update(ctx context.Context, id int64, email *string, name string, usedName, company *string, birth *time.Time) (sqlc.User, error) {
+func (r *userRepo) CreateMany(ctx context.Context, users []NewUser) ([]sqlc.User, error) {
+	arg := sqlc.CreateUsersParams{
+		Uids:      make([]string, len(users)),
+		Names:     make([]string, len(users)),
+		Emails:    make([]string, len(users)),
+		UsedNames: make([]string, len(users)),
+		Companies: make([]string, len(users)),
+		Births:    make([]string, len(users)),
+	}
+	for i, u := range users {
+		arg.Uids[i] = u.Uid
+		arg.Names[i] = u.Name
+		arg.Emails[i] = deref(u.Email)
+		arg.UsedNames[i] = deref(u.UsedName)
+		arg.Companies[i] = deref(u.Company)
+		if u.Birth != nil {
+			arg.Births[i] = u.Birth.Format(time.DateOnly)
+		}
+	}
+	rows, err := r.q(ctx).CreateUsers(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]sqlc.User, 0, len(rows))
+	for _, row := range rows {
+		u, err := toUser(sqlc.GetUserByIDRow(row))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+func (r *userRepo) TakenKeys(ctx context.Context, uids, names, emails []string) (UserKeys, error) {
+	rows, err := r.q(ctx).ListTakenUserKeys(ctx, sqlc.ListTakenUserKeysParams{Uids: uids, Names: names, Emails: emails})
+	if err != nil {
+		return UserKeys{}, err
+	}
+	want := func(vals []string) map[string]bool {
+		m := make(map[string]bool, len(vals))
+		for _, v := range vals {
+			m[v] = false
+		}
+		return m
+	}
+	wantUIDs, wantNames, wantEmails := want(uids), want(names), want(emails)
+	keys := UserKeys{UIDs: map[string]bool{}, Names: map[string]bool{}, Emails: map[string]bool{}}
+	for _, row := range rows {
+		if _, ok := wantUIDs[row.Uid]; ok {
+			keys.UIDs[row.Uid] = true
+		}
+		if !row.Live {
+			continue
+		}
+		if _, ok := wantNames[row.Name]; ok {
+			keys.Names[row.Name] = true
+		}
+		if _, ok := wantEmails[row.Email.String]; ok && row.Email.Valid {
+			keys.Emails[row.Email.String] = true
+		}
+	}
+	return keys, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (r *userRepo) Update(ctx context.Context, id, version int64, email *string, name string, usedName, company *string, birth *time.Time) (sqlc.User, error) {
 	row, err := r.q(ctx).UpdateUser(ctx, sqlc.UpdateUserParams{
 		ID:       id,
 		Name:     name,
@@ -73,6 +223,7 @@ func (r *userRepo) Update(ctx context.Context, id int64, email *string, name str
 		UsedName: toPgtypeText(usedName),
 		Company:  toPgtypeText(company),
 		Birth:    toPgtypeDate(birth),
+		Version:  version,
 	})
 	if err != nil {
 		return sqlc.User{}, err
@@ -80,8 +231,73 @@ func (r *userRepo) Update(ctx context.Context, id int64, email *string, name str
 	return toUserFromUpdate(row)
 }
 
-func (r *userRepo) Delete(ctx context.Context, id int64) error {
-	return r.q(ctx).DeleteUser(ctx, id)
+func (r *userRepo) Patch(ctx context.Context, id, version int64, p UserPatch) (sqlc.User, error) {
+	row, err := r.q(ctx).PatchUser(ctx, sqlc.PatchUserParams{
+		ID:          id,
+		SetName:     p.Name.Present,
+		Name:        p.Name.Value,
+		SetEmail:    p.Email.Present,
+		Email:       toPgtypeText(p.Email.Ptr()),
+		SetUsedName: p.UsedName.Present,
+		UsedName:    toPgtypeText(p.UsedName.Ptr()),
+		SetCompany:  p.Company.Present,
+		Company:     toPgtypeText(p.Company.Ptr()),
+		SetBirth:    p.Birth.Present,
+		Birth:       toPgtypeDate(p.Birth.Ptr()),
+		Version:     version,
+	})
+	if err != nil {
+		return sqlc.User{}, err
+	}
+	return toUser(sqlc.GetUserByIDRow(row))
+}
+
+func (r *userRepo) Delete(ctx context.Context, id int64) (sqlc.User, error) {
+	row, err := r.q(ctx).SoftDeleteUser(ctx, id)
+	if err != nil {
+		return sqlc.User{}, err
+	}
+	return toUser(sqlc.GetUserByIDRow(row))
+}
+
+func (r *userRepo) Restore(ctx context.Context, id int64) (sqlc.User, time.Time, error) {
+	row, err := r.q(ctx).RestoreUser(ctx, id)
+	if err != nil {
+		return sqlc.User{}, time.Time{}, err
+	}
+	u, err := toUser(sqlc.GetUserByIDRow{
+		ID:        row.ID,
+		Uid:       row.Uid,
+		Email:     row.Email,
+		Name:      row.Name,
+		UsedName:  row.UsedName,
+		Company:   row.Company,
+		Birth:     row.Birth,
+		CreatedAt: row.CreatedAt,
+		UpdatedAt: row.UpdatedAt,
+		DeletedAt: row.DeletedAt,
+		Version:   row.Version,
+	})
+	return u, row.PreviousDeletedAt.Time, err
+}
+
+func (r *userRepo) PurgeDeleted(ctx context.Context, before time.Time, limit int32) ([]sqlc.User, error) {
+	rows, err := r.q(ctx).PurgeDeletedUsers(ctx, sqlc.PurgeDeletedUsersParams{
+		DeletedAt: pgtype.Timestamptz{Time: before, Valid: true},
+		Limit:     limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	users := make([]sqlc.User, 0, len(rows))
+	for _, row := range rows {
+		u, err := toUser(sqlc.GetUserByIDRow(row))
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, nil
 }
 
 func toPgtypeDate(t *time.Time) pgtype.Date {
@@ -102,6 +318,8 @@ func toUser(row sqlc.GetUserByIDRow) (sqlc.User, error) {
 		Birth:     row.Birth,
 		CreatedAt: row.CreatedAt,
 		UpdatedAt: row.UpdatedAt,
+		DeletedAt: row.DeletedAt,
+		Version:   row.Version,
 	}, nil
 }
 
@@ -116,6 +334,8 @@ func toUserFromEmail(row sqlc.GetUserByEmailRow) (sqlc.User, error) {
 		Birth:     row.Birth,
 		CreatedAt: row.CreatedAt,
 		UpdatedAt: row.UpdatedAt,
+		DeletedAt: row.DeletedAt,
+		Version:   row.Version,
 	}, nil
 }
 
@@ -130,6 +350,8 @@ func toUserFromUID(row sqlc.GetUserByUIDRow) (sqlc.User, error) {
 		Birth:     row.Birth,
 		CreatedAt: row.CreatedAt,
 		UpdatedAt: row.UpdatedAt,
+		DeletedAt: row.DeletedAt,
+		Version:   row.Version,
 	}, nil
 }
 
@@ -144,6 +366,8 @@ func toUserFromCreate(row sqlc.CreateUserRow) (sqlc.User, error) {
 		Birth:     row.Birth,
 		CreatedAt: row.CreatedAt,
 		UpdatedAt: row.UpdatedAt,
+		DeletedAt: row.DeletedAt,
+		Version:   row.Version,
 	}, nil
 }
 
@@ -158,5 +382,7 @@ func toUserFromUpdate(row sqlc.UpdateUserRow) (sqlc.User, error) {
 		Birth:     row.Birth,
 		CreatedAt: row.CreatedAt,
 		UpdatedAt: row.UpdatedAt,
+		DeletedAt: row.DeletedAt,
+		Version:   row.Version,
 	}, nil
 }
@@ -0,0 +1,87 @@
+package repo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"unicode"
+
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+// SearchUsers marks the matches in a snippet with these private-use runes,
+// which cannot clash with user data the way HTML tags would; callers swap
+// them for whatever markup they render.
+const (
+	SnippetStart = "\uE000"
+	SnippetStop  = "\uE001"
+)
+
+// maxSearchTerms bounds the tsquery built from a search string.
+const maxSearchTerms = 8
+
+// UserSearchHit is one search result.
+type UserSearchHit struct {
+	User sqlc.User
+	// Rank combines full-text and trigram relevance; higher is better.
+	Rank float64
+	// Snippet is the user's name, used_name, company, uid and email joined
+	// by " · ", with matches between SnippetStart and SnippetStop.
+	Snippet string
+}
+
+// searchTSQuery turns free text into a prefix query over its words, so
+// "ann sm" matches "Anne Smith"; it returns "" when there are no words.
+func searchTSQuery(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) > maxSearchTerms {
+		words = words[:maxSearchTerms]
+	}
+	terms := make([]string, len(words))
+	for i, w := range words {
+		// Only letters and digits are left, so quoting cannot be broken.
+		terms[i] = "'" + w + "':*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+func (r *userQueryRepo) Search(ctx context.Context, query string, limit int32, similarity float64) ([]UserSearchHit, error) {
+	if similarity > 0 {
+		// Local to the caller's transaction; without one it has no effect.
+		if err := r.q(ctx).SetWordSimilarityThreshold(ctx, strconv.FormatFloat(similarity, 'f', -1, 64)); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := r.q(ctx).SearchUsers(ctx, sqlc.SearchUsersParams{
+		Limit:   limit,
+		Tsquery: searchTSQuery(query),
+		Query:   strings.ToLower(query),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]UserSearchHit, len(rows))
+	for i, row := range rows {
+		hits[i] = UserSearchHit{
+			User: sqlc.User{
+				ID:        row.ID,
+				Uid:       row.Uid,
+				Email:     row.Email,
+				Name:      row.Name,
+				UsedName:  row.UsedName,
+				Company:   row.Company,
+				Birth:     row.Birth,
+				CreatedAt: row.CreatedAt,
+				UpdatedAt: row.UpdatedAt,
+				DeletedAt: row.DeletedAt,
+				Version:   row.Version,
+			},
+			Rank:    row.Rank,
+			Snippet: row.Snippet,
+		}
+	}
+	return hits, nil
+}
@@ -0,0 +1,18 @@
+package repo
+
+import "testing"
+
+func TestSearchTSQuery(t *testing.T) {
+	for in, want := range map[string]string{
+		"Ann Sm":              "'ann':* & 'sm':*",
+		"o'brien & co | !x":   "'o':* & 'brien':* & 'co':* & 'x':*",
+		"emp_001":             "'emp':* & '001':*",
+		"张伟":                  "'张伟':*",
+		"@@@":                 "",
+		"a b c d e f g h i j": "'a':* & 'b':* & 'c':* & 'd':* & 'e':* & 'f':* & 'g':* & 'h':*",
+	} {
+		if got := searchTSQuery(in); got != want {
+			t.Errorf("searchTSQuery(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/cache"
+	"github.com/tfenng/scaffold/internal/dberr"
+	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/logging"
+	"github.com/tfenng/scaffold/internal/repo"
+	"github.com/tfenng/scaffold/internal/tracing"
+)
+
+// APIKeyStore is the cache-aside store for API key records, keyed by prefix.
+type APIKeyStore interface {
+	Get(ctx context.Context, prefix string) (sqlc.ApiKey, bool, error)
+	Set(ctx context.Context, k sqlc.ApiKey) error
+	Del(ctx context.Context, prefix string) error
+}
+
+// APIKeyService issues API keys to non-interactive callers and
+// authenticates requests that present them.
+type APIKeyService struct {
+	Keys  repo.APIKeyRepo
+	Cache APIKeyStore
+	// TouchInterval limits last_used_at writes to one per key per interval.
+	TouchInterval time.Duration
+
+	touched sync.Map // key id -> time.Time
+}
+
+var errInvalidAPIKey = domain.Unauthenticated("invalid api key")
+
+// Create returns the stored record and the key itself, which is shown once
+// and cannot be recovered later.
+func (s *APIKeyService) Create(ctx context.Context, name string, scopes []string, expiresAt *time.Time) (sqlc.ApiKey, string, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "APIKeyService.Create")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermAPIKeysManage); err != nil {
+		return sqlc.ApiKey{}, "", err
+	}
+	p, _ := auth.PrincipalFrom(ctx)
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return sqlc.ApiKey{}, "", domain.InvalidField("name", "required", "is required")
+	}
+	if len(scopes) == 0 {
+		return sqlc.ApiKey{}, "", domain.InvalidField("scopes", "required", "must list at least one scope")
+	}
+	for i, sc := range scopes {
+		if !slices.Contains(auth.KeyScopes, auth.Permission(sc)) {
+			return sqlc.ApiKey{}, "", domain.InvalidField("scopes["+strconv.Itoa(i)+"]", "oneof", "is not a known scope")
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return sqlc.ApiKey{}, "", domain.InvalidField("expires_at", "future", "must be in the future")
+	}
+
+	key, prefix, digest, err := auth.NewAPIKey()
+	if err != nil {
+		return sqlc.ApiKey{}, "", domain.Internal(err)
+	}
+	k, err := s.Keys.Create(ctx, name, prefix, digest, slices.Compact(slices.Sorted(slices.Values(scopes))), p.Subject, expiresAt)
+	if err != nil {
+		return sqlc.ApiKey{}, "", dberr.Map(err)
+	}
+	return k, key, nil
+}
+
+func (s *APIKeyService) List(ctx context.Context) ([]sqlc.ApiKey, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "APIKeyService.List")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermAPIKeysManage); err != nil {
+		return nil, err
+	}
+	keys, err := s.Keys.List(ctx)
+	if err != nil {
+		return nil, dberr.Map(err)
+	}
+	return keys, nil
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, id int64) error {
+	ctx, span := tracing.Tracer().Start(ctx, "APIKeyService.Revoke")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermAPIKeysManage); err != nil {
+		return err
+	}
+	if id <= 0 {
+		return domain.InvalidField("id", "positive", "must be positive")
+	}
+	prefix, err := s.Keys.Revoke(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.NotFound("api key not found")
+	}
+	if err != nil {
+		return dberr.Map(err)
+	}
+	if s.Cache != nil {
+		if err := s.Cache.Del(ctx, prefix); err != nil && !errors.Is(err, cache.ErrCircuitOpen) {
+			logging.From(ctx).Warn("api key cache del failed", "prefix", prefix, "err", err)
+		}
+	}
+	return nil
+}
+
+// Authenticate resolves key to a service principal whose scopes are the
+// key's scopes.
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) (auth.Principal, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "APIKeyService.Authenticate")
+	defer span.End()
+
+	prefix, ok := auth.ParseAPIKey(key)
+	if !ok {
+		return auth.Principal{}, errInvalidAPIKey
+	}
+	k, err := s.lookup(ctx, prefix)
+	if err != nil {
+		return auth.Principal{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashOpaqueToken(key)), []byte(k.KeyHash)) != 1 {
+		return auth.Principal{}, errInvalidAPIKey
+	}
+	if k.RevokedAt.Valid {
+		return auth.Principal{}, domain.Unauthenticated("api key has been revoked")
+	}
+	if k.ExpiresAt.Valid && !k.ExpiresAt.Time.After(time.Now()) {
+		return auth.Principal{}, domain.Unauthenticated("api key has expired")
+	}
+
+	s.touch(ctx, k.ID)
+	return auth.Principal{
+		Subject: "api_key:" + strconv.FormatInt(k.ID, 10),
+		Roles:   []string{auth.RoleService},
+		Scopes:  k.Scopes,
+	}, nil
+}
+
+func (s *APIKeyService) lookup(ctx context.Context, prefix string) (sqlc.ApiKey, error) {
+	if s.Cache != nil {
+		k, ok, err := s.Cache.Get(ctx, prefix)
+		if err == nil && ok {
+			return k, nil
+		}
+		if err != nil && !errors.Is(err, cache.ErrCircuitOpen) {
+			logging.From(ctx).Warn("api key cache get failed", "prefix", prefix, "err", err)
+		}
+	}
+
+	k, err := s.Keys.GetByPrefix(ctx, prefix)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.ApiKey{}, errInvalidAPIKey
+	}
+	if err != nil {
+		return sqlc.ApiKey{}, dberr.Map(err)
+	}
+	if s.Cache != nil {
+		_ = s.Cache.Set(ctx, k)
+	}
+	return k, nil
+}
+
+func (s *APIKeyService) touch(ctx context.Context, id int64) {
+	now := time.Now()
+	if last, ok := s.touched.Load(id); ok && now.Sub(last.(time.Time)) < s.TouchInterval {
+		return
+	}
+	s.touched.Store(id, now)
+	if err := s.Keys.Touch(ctx, id); err != nil {
+		logging.From(ctx).Warn("api key touch failed", "id", id, "err", err)
+	}
+}
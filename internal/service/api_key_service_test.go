@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+)
+
+type memAPIKeys struct {
+	keys    []sqlc.ApiKey
+	lookups int
+	touches int
+}
+
+func (m *memAPIKeys) Create(_ context.Context, name, prefix, keyHash string, scopes []string, createdBy string, expiresAt *time.Time) (sqlc.ApiKey, error) {
+	k := sqlc.ApiKey{ID: int64(len(m.keys) + 1), Name: name, Prefix: prefix, KeyHash: keyHash, Scopes: scopes, CreatedBy: createdBy}
+	if expiresAt != nil {
+		k.ExpiresAt = pgtype.Timestamptz{Time: *expiresAt, Valid: true}
+	}
+	m.keys = append(m.keys, k)
+	return k, nil
+}
+
+func (m *memAPIKeys) GetByPrefix(_ context.Context, prefix string) (sqlc.ApiKey, error) {
+	m.lookups++
+	for _, k := range m.keys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return sqlc.ApiKey{}, pgx.ErrNoRows
+}
+
+func (m *memAPIKeys) List(context.Context) ([]sqlc.ApiKey, error) { return m.keys, nil }
+
+func (m *memAPIKeys) Revoke(_ context.Context, id int64) (string, error) {
+	if id < 1 || int(id) > len(m.keys) || m.keys[id-1].RevokedAt.Valid {
+		return "", pgx.ErrNoRows
+	}
+	m.keys[id-1].RevokedAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	return m.keys[id-1].Prefix, nil
+}
+
+func (m *memAPIKeys) Touch(context.Context, int64) error { m.touches++; return nil }
+
+type memKeyCache map[string]sqlc.ApiKey
+
+func (m memKeyCache) Get(_ context.Context, prefix string) (sqlc.ApiKey, bool, error) {
+	k, ok := m[prefix]
+	return k, ok, nil
+}
+func (m memKeyCache) Set(_ context.Context, k sqlc.ApiKey) error { m[k.Prefix] = k; return nil }
+func (m memKeyCache) Del(_ context.Context, prefix string) error { delete(m, prefix); return nil }
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	keys := &memAPIKeys{}
+	s := &APIKeyService{Keys: keys, Cache: memKeyCache{}, TouchInterval: time.Minute}
+	admin := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+
+	_, _, err := s.Create(admin, "batch", []string{"users:read", "users:admin"}, nil)
+	wantCode(t, err, domain.CodeInvalidArgument)
+	_, _, err = s.Create(auth.WithPrincipal(context.Background(), auth.Principal{Subject: "2", Roles: []string{auth.RoleSupport}}), "batch", []string{"users:read"}, nil)
+	wantCode(t, err, domain.CodeForbidden)
+
+	rec, key, err := s.Create(admin, "batch", []string{"users:read"}, nil)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if rec.KeyHash == key || rec.CreatedBy != "1" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+
+	for range 2 {
+		p, err := s.Authenticate(context.Background(), key)
+		if err != nil {
+			t.Fatalf("authenticate: %v", err)
+		}
+		if !p.Can(auth.PermUsersRead) || p.Can(auth.PermUsersWrite) {
+			t.Fatalf("scopes not applied: %+v", p)
+		}
+	}
+	if keys.lookups != 1 || keys.touches != 1 {
+		t.Fatalf("want 1 db lookup and 1 touch, got %d and %d", keys.lookups, keys.touches)
+	}
+
+	_, err = s.Authenticate(context.Background(), key[:len(key)-1]+"x")
+	wantCode(t, err, domain.CodeUnauthenticated)
+
+	if err := s.Revoke(admin, rec.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	_, err = s.Authenticate(context.Background(), key)
+	wantCode(t, err, domain.CodeUnauthenticated)
+	wantCode(t, s.Revoke(admin, rec.ID), domain.CodeNotFound)
+}
+
+func TestAPIKeyExpired(t *testing.T) {
+	keys := &memAPIKeys{}
+	s := &APIKeyService{Keys: keys}
+	key, prefix, digest, err := auth.NewAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if _, err := keys.Create(context.Background(), "old", prefix, digest, []string{"users:read"}, "1", &past); err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Authenticate(context.Background(), key)
+	var ae *domain.AppError
+	if !errors.As(err, &ae) || ae.Message != "api key has expired" {
+		t.Fatalf("got %v, want expired", err)
+	}
+}
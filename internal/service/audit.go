@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/repo"
+	"github.com/tfenng/scaffold/internal/requestid"
+)
+
+const (
+	AuditUserCreate  = "user.create"
+	AuditUserUpdate  = "user.update"
+	AuditUserDelete  = "user.delete"
+	AuditUserRestore = "user.restore"
+	// AuditUserPurge marks the hard delete of a user after its retention.
+	AuditUserPurge = "user.purge"
+)
+
+type fieldChange struct {
+	Before *string `json:"before"`
+	After  *string `json:"after"`
+}
+
+// userChanges lists the fields that differ between before and after; a nil
+// side stands for a user that does not exist (create / purge).
+func userChanges(before, after *sqlc.User) map[string]fieldChange {
+	fields := []struct {
+		name string
+		get  func(sqlc.User) *string
+	}{
+		{"uid", func(u sqlc.User) *string { return &u.Uid }},
+		{"email", func(u sqlc.User) *string { return textValue(u.Email) }},
+		{"name", func(u sqlc.User) *string { return &u.Name }},
+		{"used_name", func(u sqlc.User) *string { return textValue(u.UsedName) }},
+		{"company", func(u sqlc.User) *string { return textValue(u.Company) }},
+		{"birth", func(u sqlc.User) *string { return dateValue(u.Birth) }},
+		{"deleted_at", func(u sqlc.User) *string { return timestampValue(u.DeletedAt) }},
+	}
+
+	out := map[string]fieldChange{}
+	for _, f := range fields {
+		var b, a *string
+		if before != nil {
+			b = f.get(*before)
+		}
+		if after != nil {
+			a = f.get(*after)
+		}
+		if (b == nil) != (a == nil) || (b != nil && *b != *a) {
+			out[f.name] = fieldChange{Before: b, After: a}
+		}
+	}
+	return out
+}
+
+func textValue(v pgtype.Text) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
+
+func dateValue(v pgtype.Date) *string {
+	if !v.Valid {
+		return nil
+	}
+	s := v.Time.Format("2006-01-02")
+	return &s
+}
+
+func timestampValue(v pgtype.Timestamptz) *string {
+	if !v.Valid {
+		return nil
+	}
+	s := v.Time.UTC().Format(time.RFC3339)
+	return &s
+}
+
+// recordAudit writes an audit event for a user mutation in ctx's
+// transaction, so the event commits or rolls back with the change itself.
+func (s *UserService) recordAudit(ctx context.Context, action string, before, after *sqlc.User) error {
+	return recordAudit(ctx, s.Audit, action, before, after)
+}
+
+func recordAudit(ctx context.Context, audit repo.AuditRepo, action string, before, after *sqlc.User) error {
+	if audit == nil {
+		return nil
+	}
+	e, err := auditEvent(ctx, action, before, after)
+	if err != nil {
+		return err
+	}
+	return audit.Record(ctx, e)
+}
+
+func auditEvent(ctx context.Context, action string, before, after *sqlc.User) (repo.AuditEvent, error) {
+	target := after
+	if target == nil {
+		target = before
+	}
+	changes, err := json.Marshal(userChanges(before, after))
+	if err != nil {
+		return repo.AuditEvent{}, err
+	}
+	actor := "anonymous"
+	if p, ok := auth.PrincipalFrom(ctx); ok {
+		actor = p.Subject
+	}
+	return repo.AuditEvent{
+		Actor:     actor,
+		Action:    action,
+		TargetID:  target.ID,
+		TargetUID: target.Uid,
+		Changes:   changes,
+		RequestID: requestid.From(ctx),
+	}, nil
+}
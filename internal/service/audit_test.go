@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/repo"
+	"github.com/tfenng/scaffold/internal/requestid"
+)
+
+func str(s string) *string { return &s }
+
+func TestUserChanges(t *testing.T) {
+	alice := sqlc.User{ID: 1, Uid: "alice", Name: "Alice", Email: pgtype.Text{String: "a@x.io", Valid: true}}
+	renamed := alice
+	renamed.Name = "Alicia"
+	renamed.Email = pgtype.Text{}
+
+	tests := []struct {
+		name          string
+		before, after *sqlc.User
+		want          map[string]fieldChange
+	}{
+		{
+			name:  "create",
+			after: &alice,
+			want: map[string]fieldChange{
+				"uid":   {After: str("alice")},
+				"name":  {After: str("Alice")},
+				"email": {After: str("a@x.io")},
+			},
+		},
+		{
+			name:   "update",
+			before: &alice,
+			after:  &renamed,
+			want: map[string]fieldChange{
+				"name":  {Before: str("Alice"), After: str("Alicia")},
+				"email": {Before: str("a@x.io")},
+			},
+		},
+		{name: "no-op update", before: &alice, after: &alice, want: map[string]fieldChange{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := userChanges(tc.before, tc.after); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+type memAudit struct {
+	repo.AuditRepo
+	events []repo.AuditEvent
+}
+
+func (m *memAudit) Record(_ context.Context, e repo.AuditEvent) error {
+	m.events = append(m.events, e)
+	return nil
+}
+
+func (m *memAudit) RecordMany(_ context.Context, events []repo.AuditEvent) error {
+	m.events = append(m.events, events...)
+	return nil
+}
+
+type auditUsers struct {
+	repo.UserRepo
+	u sqlc.User
+}
+
+func (a *auditUsers) GetByIDForUpdate(context.Context, int64) (sqlc.User, error) { return a.u, nil }
+
+func (a *auditUsers) Update(_ context.Context, id, _ int64, email *string, name string, usedName, company *string, _ *time.Time) (sqlc.User, error) {
+	a.u.Name = name
+	return a.u, nil
+}
+
+func TestUpdateRecordsAudit(t *testing.T) {
+	audit := &memAudit{}
+	s := &UserService{
+		Tx:    noTx{},
+		Users: &auditUsers{u: sqlc.User{ID: 9, Uid: "bob", Name: "Bob"}},
+		Audit: audit,
+	}
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+	ctx = requestid.With(ctx, "req-7")
+
+	if _, err := s.Update(ctx, 9, nil, nil, "Robert", nil, nil, nil); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if len(audit.events) != 1 {
+		t.Fatalf("want 1 event, got %d", len(audit.events))
+	}
+	e := audit.events[0]
+	if e.Actor != "1" || e.Action != AuditUserUpdate || e.TargetID != 9 || e.TargetUID != "bob" || e.RequestID != "req-7" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	var changes map[string]fieldChange
+	if err := json.Unmarshal(e.Changes, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || *changes["name"].Before != "Bob" || *changes["name"].After != "Robert" {
+		t.Fatalf("unexpected changes: %s", e.Changes)
+	}
+}
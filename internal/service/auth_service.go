@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/cache"
+	"github.com/tfenng/scaffold/internal/dberr"
+	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/logging"
+	"github.com/tfenng/scaffold/internal/repo"
+	"github.com/tfenng/scaffold/internal/tracing"
+)
+
+// LoginThrottle locks out identifiers after repeated failed logins.
+type LoginThrottle interface {
+	Blocked(ctx context.Context, identifier string) (bool, time.Duration, error)
+	Fail(ctx context.Context, identifier string) error
+	Reset(ctx context.Context, identifier string) error
+}
+
+// Tokens is the result of a login or refresh.
+type Tokens struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// AuthService manages local accounts: registration, password login and
+// rotating refresh tokens.
+type AuthService struct {
+	Tx          repo.TxManager
+	Users       repo.UserRepo
+	Credentials repo.CredentialRepo
+	Hasher      *auth.Hasher
+	Issuer      *auth.Issuer
+	Throttle    LoginThrottle
+	// Audit and UCache, when set, get the users Register creates, as
+	// UserService.Create does.
+	Audit  repo.AuditRepo
+	UCache cache.UserStore
+
+	MinPasswordLength int
+	RefreshTTL        time.Duration
+}
+
+var errInvalidCredentials = domain.Unauthenticated("invalid credentials")
+
+func (s *AuthService) Register(ctx context.Context, uid string, email *string, name, password string) (sqlc.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AuthService.Register")
+	defer span.End()
+
+	uid = strings.TrimSpace(uid)
+	name = strings.TrimSpace(name)
+	var fields []domain.FieldViolation
+	if uid == "" {
+		fields = append(fields, domain.FieldViolation{Field: "uid", Rule: "required", Message: "is required"})
+	}
+	if name == "" {
+		fields = append(fields, domain.FieldViolation{Field: "name", Rule: "required", Message: "is required"})
+	}
+	if v := s.checkPassword("password", password); v != nil {
+		fields = append(fields, *v)
+	}
+	if len(fields) > 0 {
+		return sqlc.User{}, domain.InvalidFields("invalid registration", fields...)
+	}
+	normalizedEmail, err := normalizeEmail(email)
+	if err != nil {
+		return sqlc.User{}, domain.InvalidField("email", "email", "must be a valid email address")
+	}
+
+	hash, err := s.Hasher.Hash(password)
+	if err != nil {
+		return sqlc.User{}, domain.Internal(err)
+	}
+
+	var out sqlc.User
+	err = s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		u, err := s.Users.Create(ctx, uid, normalizedEmail, name, nil, nil, nil)
+		if err != nil {
+			return dberr.Map(err)
+		}
+		if err := s.Credentials.Create(ctx, u.ID, hash, nil); err != nil {
+			return dberr.Map(err)
+		}
+		out = u
+		return recordAudit(ctx, s.Audit, AuditUserCreate, nil, &u)
+	})
+	if err != nil {
+		return sqlc.User{}, dberr.Map(err)
+	}
+
+	if s.UCache != nil {
+		_ = s.UCache.Set(ctx, out)
+	}
+	return out, nil
+}
+
+// throttleUnavailable logs a throttle error, except for an open circuit,
+// which the cache supervisor already reports.
+func throttleUnavailable(ctx context.Context, err error) {
+	if !errors.Is(err, cache.ErrCircuitOpen) {
+		logging.From(ctx).Warn("login throttle unavailable", "err", err)
+	}
+}
+
+// Login accepts a uid or an email as identifier. Failures are counted per
+// identifier and all look alike to the caller. When the throttle is
+// unavailable Login fails open: it is skipped, not treated as a lockout.
+func (s *AuthService) Login(ctx context.Context, identifier, password string) (Tokens, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AuthService.Login")
+	defer span.End()
+
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" || password == "" {
+		return Tokens{}, errInvalidCredentials
+	}
+
+	if blocked, retry, err := s.Throttle.Blocked(ctx, identifier); err != nil {
+		throttleUnavailable(ctx, err)
+	} else if blocked {
+		return Tokens{}, domain.ResourceExhausted(fmt.Sprintf("too many failed logins, retry in %ds", int(retry.Seconds())+1))
+	}
+
+	u, cred, err := s.lookupAccount(ctx, identifier)
+	if err != nil {
+		return Tokens{}, err
+	}
+	ok := false
+	rehash := false
+	if cred != nil {
+		ok, rehash, err = s.Hasher.Verify(cred.PasswordHash, password)
+		if err != nil {
+			return Tokens{}, domain.Internal(err)
+		}
+	} else {
+		// Spend the same time as a real check so unknown identifiers
+		// cannot be told apart by latency.
+		_, _ = s.Hasher.Hash(password)
+	}
+	if !ok {
+		if err := s.Throttle.Fail(ctx, identifier); err != nil {
+			throttleUnavailable(ctx, err)
+		}
+		return Tokens{}, errInvalidCredentials
+	}
+	if err := s.Throttle.Reset(ctx, identifier); err != nil {
+		throttleUnavailable(ctx, err)
+	}
+
+	if rehash {
+		if h, err := s.Hasher.Hash(password); err == nil {
+			if err := s.Credentials.Rehash(ctx, u.ID, h); err != nil {
+				logging.From(ctx).Warn("password rehash failed", "user_id", u.ID, "err", err)
+			}
+		}
+	}
+
+	var out Tokens
+	err = s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		family, err := newFamilyID()
+		if err != nil {
+			return err
+		}
+		out, _, err = s.issue(ctx, u.ID, cred.Roles, family)
+		return err
+	})
+	if err != nil {
+		return Tokens{}, dberr.Map(err)
+	}
+	return out, nil
+}
+
+// Refresh exchanges a refresh token for a new pair. Each refresh token is
+// single-use: presenting one that was already rotated or revoked revokes
+// every token descended from the same login.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "AuthService.Refresh")
+	defer span.End()
+
+	var out Tokens
+	var reused bool
+	err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		rt, err := s.Credentials.RefreshTokenForUpdate(ctx, auth.HashOpaqueToken(refreshToken))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return domain.Unauthenticated("invalid refresh token")
+		}
+		if err != nil {
+			return err
+		}
+		if rt.RevokedAt.Valid {
+			reused = true
+			return s.Credentials.RevokeRefreshFamily(ctx, rt.FamilyID)
+		}
+		if !rt.ExpiresAt.Time.After(time.Now()) {
+			return domain.Unauthenticated("refresh token has expired")
+		}
+
+		// A soft-deleted user keeps its refresh tokens until the purge, but
+		// must not be able to use them.
+		if _, err := s.Users.GetByID(ctx, rt.UserID); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return domain.Unauthenticated("invalid refresh token")
+			}
+			return err
+		}
+		cred, err := s.Credentials.Get(ctx, rt.UserID)
+		if err != nil {
+			return err
+		}
+		tokens, next, err := s.issue(ctx, rt.UserID, cred.Roles, rt.FamilyID)
+		if err != nil {
+			return err
+		}
+		out = tokens
+		return s.Credentials.RotateRefreshToken(ctx, rt.ID, next.ID)
+	})
+	if err != nil {
+		return Tokens{}, dberr.Map(err)
+	}
+	if reused {
+		logging.From(ctx).Warn("refresh token reuse detected, family revoked")
+		return Tokens{}, domain.Unauthenticated("invalid refresh token")
+	}
+	return out, nil
+}
+
+// Logout revokes the refresh token and its family. Unknown tokens are
+// ignored so logout is idempotent. Access tokens stay valid until they expire.
+func (s *AuthService) Logout(ctx context.Context, refreshToken string) error {
+	ctx, span := tracing.Tracer().Start(ctx, "AuthService.Logout")
+	defer span.End()
+
+	err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		rt, err := s.Credentials.RefreshTokenForUpdate(ctx, auth.HashOpaqueToken(refreshToken))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return s.Credentials.RevokeRefreshFamily(ctx, rt.FamilyID)
+	})
+	return dberr.Map(err)
+}
+
+// ChangePassword sets a new password for the calling principal and revokes
+// all of its refresh tokens.
+func (s *AuthService) ChangePassword(ctx context.Context, current, next string) error {
+	ctx, span := tracing.Tracer().Start(ctx, "AuthService.ChangePassword")
+	defer span.End()
+
+	p, ok := auth.PrincipalFrom(ctx)
+	if !ok {
+		return domain.Unauthenticated("authentication required")
+	}
+	userID, err := strconv.ParseInt(p.Subject, 10, 64)
+	if err != nil {
+		return domain.Forbidden("only local accounts have a password")
+	}
+	if v := s.checkPassword("new_password", next); v != nil {
+		return domain.InvalidFields("new_password "+v.Message, *v)
+	}
+
+	cred, err := s.Credentials.Get(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Forbidden("only local accounts have a password")
+	}
+	if err != nil {
+		return dberr.Map(err)
+	}
+	ok, _, err = s.Hasher.Verify(cred.PasswordHash, current)
+	if err != nil {
+		return domain.Internal(err)
+	}
+	if !ok {
+		return domain.InvalidField("current_password", "match", "is incorrect")
+	}
+
+	hash, err := s.Hasher.Hash(next)
+	if err != nil {
+		return domain.Internal(err)
+	}
+	err = s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		if err := s.Credentials.SetPassword(ctx, userID, hash); err != nil {
+			return err
+		}
+		return s.Credentials.RevokeUserRefreshTokens(ctx, userID)
+	})
+	return dberr.Map(err)
+}
+
+func (s *AuthService) checkPassword(field, password string) *domain.FieldViolation {
+	if len([]rune(password)) < s.MinPasswordLength {
+		return &domain.FieldViolation{Field: field, Rule: "min", Message: fmt.Sprintf("must be at least %d characters", s.MinPasswordLength)}
+	}
+	if len(password) > 1024 {
+		return &domain.FieldViolation{Field: field, Rule: "max", Message: "must be at most 1024 bytes"}
+	}
+	return nil
+}
+
+// lookupAccount resolves identifier as an email when it contains "@",
+// otherwise as a uid. A missing user or credential yields a nil credential.
+func (s *AuthService) lookupAccount(ctx context.Context, identifier string) (sqlc.User, *sqlc.UserCredential, error) {
+	var u sqlc.User
+	var err error
+	if strings.Contains(identifier, "@") {
+		u, err = s.Users.GetByEmail(ctx, identifier)
+	} else {
+		u, err = s.Users.GetByUID(ctx, identifier)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.User{}, nil, nil
+	}
+	if err != nil {
+		return sqlc.User{}, nil, dberr.Map(err)
+	}
+	cred, err := s.Credentials.Get(ctx, u.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, nil, nil
+	}
+	if err != nil {
+		return sqlc.User{}, nil, dberr.Map(err)
+	}
+	return u, &cred, nil
+}
+
+// issue signs an access token and stores a new refresh token in family.
+func (s *AuthService) issue(ctx context.Context, userID int64, roles []string, family string) (Tokens, sqlc.RefreshToken, error) {
+	access, accessExp, err := s.Issuer.Issue(auth.Principal{Subject: strconv.FormatInt(userID, 10), Roles: roles})
+	if err != nil {
+		return Tokens{}, sqlc.RefreshToken{}, err
+	}
+	refresh, digest, err := auth.NewOpaqueToken()
+	if err != nil {
+		return Tokens{}, sqlc.RefreshToken{}, err
+	}
+	refreshExp := time.Now().Add(s.RefreshTTL)
+	rt, err := s.Credentials.CreateRefreshToken(ctx, userID, family, digest, refreshExp)
+	if err != nil {
+		return Tokens{}, sqlc.RefreshToken{}, err
+	}
+	return Tokens{
+		AccessToken:      access,
+		AccessExpiresAt:  accessExp,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExp,
+	}, rt, nil
+}
+
+func newFamilyID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
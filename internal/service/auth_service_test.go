@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/cache"
+	"github.com/tfenng/scaffold/internal/config"
+	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/repo"
+)
+
+type noTx struct{}
+
+func (noTx) WithinTx(ctx context.Context, fn func(ctx context.Context) error) error { return fn(ctx) }
+
+func (noTx) WithinSnapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+type memUsers struct {
+	repo.UserRepo
+	byUID map[string]sqlc.User
+}
+
+func (m *memUsers) GetByUID(_ context.Context, uid string) (sqlc.User, error) {
+	u, ok := m.byUID[uid]
+	if !ok {
+		return sqlc.User{}, pgx.ErrNoRows
+	}
+	return u, nil
+}
+
+func (m *memUsers) GetByID(_ context.Context, id int64) (sqlc.User, error) {
+	for _, u := range m.byUID {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return sqlc.User{}, pgx.ErrNoRows
+}
+
+func (m *memUsers) Create(_ context.Context, uid string, email *string, name string, _, _ *string, _ *time.Time) (sqlc.User, error) {
+	u := sqlc.User{ID: int64(len(m.byUID) + 1), Uid: uid, Name: name}
+	if email != nil {
+		u.Email = pgtype.Text{String: *email, Valid: true}
+	}
+	m.byUID[uid] = u
+	return u, nil
+}
+
+type memCredentials struct {
+	repo.CredentialRepo
+	creds  map[int64]sqlc.UserCredential
+	tokens []sqlc.RefreshToken
+}
+
+func (m *memCredentials) Create(_ context.Context, userID int64, passwordHash string, roles []string) error {
+	m.creds[userID] = sqlc.UserCredential{UserID: userID, PasswordHash: passwordHash, Roles: roles}
+	return nil
+}
+
+func (m *memCredentials) Get(_ context.Context, userID int64) (sqlc.UserCredential, error) {
+	c, ok := m.creds[userID]
+	if !ok {
+		return sqlc.UserCredential{}, pgx.ErrNoRows
+	}
+	return c, nil
+}
+
+func (m *memCredentials) CreateRefreshToken(_ context.Context, userID int64, familyID, tokenHash string, expiresAt time.Time) (sqlc.RefreshToken, error) {
+	rt := sqlc.RefreshToken{
+		ID: int64(len(m.tokens) + 1), UserID: userID, FamilyID: familyID, TokenHash: tokenHash,
+		ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	}
+	m.tokens = append(m.tokens, rt)
+	return rt, nil
+}
+
+func (m *memCredentials) RefreshTokenForUpdate(_ context.Context, tokenHash string) (sqlc.RefreshToken, error) {
+	for _, rt := range m.tokens {
+		if rt.TokenHash == tokenHash {
+			return rt, nil
+		}
+	}
+	return sqlc.RefreshToken{}, pgx.ErrNoRows
+}
+
+func (m *memCredentials) RotateRefreshToken(_ context.Context, id, replacedBy int64) error {
+	m.tokens[id-1].RevokedAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	m.tokens[id-1].ReplacedBy = pgtype.Int8{Int64: replacedBy, Valid: true}
+	return nil
+}
+
+func (m *memCredentials) RevokeRefreshFamily(_ context.Context, familyID string) error {
+	for i := range m.tokens {
+		if m.tokens[i].FamilyID == familyID && !m.tokens[i].RevokedAt.Valid {
+			m.tokens[i].RevokedAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+		}
+	}
+	return nil
+}
+
+type memThrottle struct{ fails map[string]int }
+
+func (m *memThrottle) Blocked(_ context.Context, id string) (bool, time.Duration, error) {
+	return m.fails[id] >= 2, time.Minute, nil
+}
+func (m *memThrottle) Fail(_ context.Context, id string) error  { m.fails[id]++; return nil }
+func (m *memThrottle) Reset(_ context.Context, id string) error { delete(m.fails, id); return nil }
+
+func newAuthService(t *testing.T) (*AuthService, *memCredentials, *memUsers) {
+	t.Helper()
+	pw := config.Password{Algorithm: "argon2id", Argon2MemoryKiB: 8 * 1024, Argon2Iterations: 1, Argon2Parallelism: 1, BcryptCost: 10}
+	hasher := auth.NewHasher(pw)
+	hash, err := hasher.Hash("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := &memCredentials{creds: map[int64]sqlc.UserCredential{
+		1: {UserID: 1, PasswordHash: hash, Roles: []string{auth.RoleSupport}},
+	}}
+	users := &memUsers{byUID: map[string]sqlc.User{"alice": {ID: 1, Uid: "alice"}}}
+	return &AuthService{
+		Tx:          noTx{},
+		Users:       users,
+		Credentials: creds,
+		Hasher:      hasher,
+		Issuer:      auth.NewIssuer(config.Auth{HS256Secret: "0123456789abcdef0123456789abcdef", AccessTokenTTL: time.Minute}),
+		Throttle:    &memThrottle{fails: map[string]int{}},
+		RefreshTTL:  time.Hour,
+	}, creds, users
+}
+
+func wantCode(t *testing.T, err error, code domain.Code) {
+	t.Helper()
+	var ae *domain.AppError
+	if !errors.As(err, &ae) || ae.Code != code {
+		t.Fatalf("got %v, want %s", err, code)
+	}
+}
+
+// memUserStore is a UserStore that only remembers what was set.
+type memUserStore struct {
+	cache.UserStore
+	set []sqlc.User
+}
+
+func (m *memUserStore) Set(_ context.Context, u sqlc.User) error {
+	m.set = append(m.set, u)
+	return nil
+}
+
+func TestRegisterIsAudited(t *testing.T) {
+	s, creds, _ := newAuthService(t)
+	audit, store := &memAudit{}, &memUserStore{}
+	s.Audit, s.UCache = audit, store
+
+	email := "Bob@Example.com"
+	u, err := s.Register(context.Background(), "bob", &email, "Bob", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := creds.creds[u.ID]; !ok {
+		t.Fatal("no credential created")
+	}
+	if len(audit.events) != 1 {
+		t.Fatalf("got %d audit events, want 1", len(audit.events))
+	}
+	e := audit.events[0]
+	if e.Action != AuditUserCreate || e.TargetID != u.ID || e.TargetUID != "bob" || e.Actor != "anonymous" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	if len(store.set) != 1 || store.set[0].ID != u.ID {
+		t.Fatalf("user not cached: %+v", store.set)
+	}
+}
+
+func TestLoginThrottlesFailures(t *testing.T) {
+	s, _, _ := newAuthService(t)
+	ctx := context.Background()
+
+	for range 2 {
+		_, err := s.Login(ctx, "alice", "wrong password!")
+		wantCode(t, err, domain.CodeUnauthenticated)
+	}
+	_, err := s.Login(ctx, "alice", "correct horse battery")
+	wantCode(t, err, domain.CodeResourceExhausted)
+}
+
+// downThrottle is a throttle whose Redis circuit is open.
+type downThrottle struct{}
+
+func (downThrottle) Blocked(context.Context, string) (bool, time.Duration, error) {
+	return false, 0, cache.ErrCircuitOpen
+}
+func (downThrottle) Fail(context.Context, string) error  { return cache.ErrCircuitOpen }
+func (downThrottle) Reset(context.Context, string) error { return cache.ErrCircuitOpen }
+
+func TestLoginFailsOpenWithoutThrottle(t *testing.T) {
+	s, _, _ := newAuthService(t)
+	s.Throttle = downThrottle{}
+	ctx := context.Background()
+
+	_, err := s.Login(ctx, "alice", "wrong password!")
+	wantCode(t, err, domain.CodeUnauthenticated)
+	if _, err := s.Login(ctx, "alice", "correct horse battery"); err != nil {
+		t.Fatalf("login should proceed without the throttle, got %v", err)
+	}
+}
+
+func TestRefreshRotatesAndDetectsReuse(t *testing.T) {
+	s, creds, _ := newAuthService(t)
+	ctx := context.Background()
+
+	first, err := s.Login(ctx, "alice", "correct horse battery")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	second, err := s.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	// Replaying the rotated token revokes the whole family, including the
+	// token issued by the legitimate refresh.
+	_, err = s.Refresh(ctx, first.RefreshToken)
+	wantCode(t, err, domain.CodeUnauthenticated)
+	for _, rt := range creds.tokens {
+		if !rt.RevokedAt.Valid {
+			t.Fatalf("token %d still active after reuse", rt.ID)
+		}
+	}
+	_, err = s.Refresh(ctx, second.RefreshToken)
+	wantCode(t, err, domain.CodeUnauthenticated)
+}
+
+func TestRefreshRejectsDeletedUser(t *testing.T) {
+	s, _, users := newAuthService(t)
+	ctx := context.Background()
+
+	tokens, err := s.Login(ctx, "alice", "correct horse battery")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	delete(users.byUID, "alice")
+
+	_, err = s.Refresh(ctx, tokens.RefreshToken)
+	wantCode(t, err, domain.CodeUnauthenticated)
+}
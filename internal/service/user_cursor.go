@@ -0,0 +1,116 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/tfenng/scaffold/internal/cursor"
+	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/repo"
+)
+
+const (
+	cursorNext = "n"
+	cursorPrev = "p"
+)
+
+// userCursor is the payload of a keyset page token. It pins the filters it
+// was issued for, so a token cannot be replayed against another result set.
+type userCursor struct {
+	Dir    string    `json:"d"`
+	Values []*string `json:"v"`
+	ID     int64     `json:"i"`
+	Filter string    `json:"f"`
+}
+
+var errInvalidCursor = domain.InvalidField("cursor", "cursor", "is invalid or was issued for different filters or sort")
+
+func userSort(f repo.UserListFilter) repo.UserSort {
+	if len(f.Sort) == 0 {
+		return repo.DefaultUserSort
+	}
+	return f.Sort
+}
+
+// filterDigest identifies the filters and sort a cursor belongs to.
+func filterDigest(f repo.UserListFilter) string {
+	h := sha256.New()
+	h.Write([]byte(userSort(f).String()))
+	h.Write([]byte{0})
+	h.Write([]byte(f.Where.String()))
+	h.Write([]byte{0})
+	if f.IncludeDeleted {
+		h.Write([]byte{1})
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:9])
+}
+
+func decodeUserCursor(c *cursor.Codec, token string, f repo.UserListFilter) (repo.UserScroll, error) {
+	var uc userCursor
+	if err := c.Decode(token, &uc); err != nil || uc.Filter != filterDigest(f) || len(uc.Values) != len(userSort(f)) {
+		return repo.UserScroll{}, errInvalidCursor
+	}
+	k := &repo.Keyset{Values: uc.Values, ID: uc.ID}
+	switch uc.Dir {
+	case cursorNext:
+		return repo.UserScroll{After: k}, nil
+	case cursorPrev:
+		return repo.UserScroll{Before: k}, nil
+	}
+	return repo.UserScroll{}, errInvalidCursor
+}
+
+func encodeUserCursor(c *cursor.Codec, dir string, k repo.Keyset, f repo.UserListFilter) (*string, error) {
+	token, err := c.Encode(userCursor{Dir: dir, Values: k.Values, ID: k.ID, Filter: filterDigest(f)})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// pageCursors works out the tokens around a window fetched with s.
+func pageCursors(c *cursor.Codec, f repo.UserListFilter, s repo.UserScroll, w repo.Window[sqlc.User]) (next, prev *string, err error) {
+	var hasNext, hasPrev bool
+	switch {
+	case s.Before != nil:
+		hasNext, hasPrev = true, w.More
+	case s.After != nil:
+		hasNext, hasPrev = w.More, true
+	default:
+		hasNext = w.More
+	}
+
+	if len(w.Items) == 0 {
+		// Nothing left on this side of the key: point back at it, moving
+		// the id one step past the key so its own row is included again.
+		sort := userSort(f)
+		step := int64(1)
+		if sort[len(sort)-1].Desc {
+			step = -1
+		}
+		switch {
+		case s.After != nil:
+			k := *s.After
+			k.ID += step
+			prev, err = encodeUserCursor(c, cursorPrev, k, f)
+		case s.Before != nil:
+			k := *s.Before
+			k.ID -= step
+			next, err = encodeUserCursor(c, cursorNext, k, f)
+		}
+		return next, prev, err
+	}
+
+	if hasNext {
+		if next, err = encodeUserCursor(c, cursorNext, userSort(f).Keyset(w.Items[len(w.Items)-1]), f); err != nil {
+			return nil, nil, err
+		}
+	}
+	if hasPrev {
+		if prev, err = encodeUserCursor(c, cursorPrev, userSort(f).Keyset(w.Items[0]), f); err != nil {
+			return nil, nil, err
+		}
+	}
+	return next, prev, nil
+}
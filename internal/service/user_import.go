@@ -0,0 +1,370 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/dberr"
+	"github.com/tfenng/scaffold/internal/domain"
+	"github.com/tfenng/scaffold/internal/repo"
+	"github.com/tfenng/scaffold/internal/tracing"
+)
+
+// ImportMode says what becomes of the valid rows of an import when others
+// fail.
+type ImportMode string
+
+const (
+	// ImportAllOrNothing creates no user unless every row can be created.
+	ImportAllOrNothing ImportMode = "all_or_nothing"
+	// ImportBestEffort creates the rows it can and reports the others.
+	ImportBestEffort ImportMode = "best_effort"
+)
+
+// UserImportRow is one record of an import file, before validation.
+type UserImportRow struct {
+	// Line is where the record starts in the file.
+	Line                                       int
+	Uid, Email, Name, UsedName, Company, Birth string
+	// Err is set instead of the fields when the record could not be decoded.
+	Err error
+}
+
+// UserImportSource yields the rows of an import file as it is read.
+type UserImportSource interface {
+	// Next returns the next row, or io.EOF after the last one. Any other
+	// error aborts the import.
+	Next() (UserImportRow, error)
+}
+
+// Statuses of an import row.
+const (
+	ImportStatusCreated = "created"
+	ImportStatusFailed  = "failed"
+	// ImportStatusRolledBack marks a valid row of an all-or-nothing import
+	// that was undone because another row failed.
+	ImportStatusRolledBack = "rolled_back"
+)
+
+// Error codes of a failed import row.
+const (
+	ImportErrInvalid       = "invalid"
+	ImportErrInvalidEmail  = "invalid_email"
+	ImportErrUIDConflict   = "uid_conflict"
+	ImportErrNameConflict  = "name_conflict"
+	ImportErrEmailConflict = "email_conflict"
+	// ImportErrConflict means another request took one of the row's unique
+	// keys while the import ran.
+	ImportErrConflict = "conflict"
+)
+
+type UserImportError struct {
+	// Field is empty when the record as a whole is malformed.
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type UserImportResult struct {
+	Line   int               `json:"line"`
+	Uid    string            `json:"uid,omitempty"`
+	Status string            `json:"status"`
+	ID     int64             `json:"id,omitempty"`
+	Errors []UserImportError `json:"errors,omitempty"`
+}
+
+// UserImportReport has a result for every row, in file order.
+type UserImportReport struct {
+	Mode ImportMode `json:"mode"`
+	// Committed is false when an all-or-nothing import was rolled back.
+	Committed bool               `json:"committed"`
+	Total     int                `json:"total"`
+	Created   int                `json:"created"`
+	Failed    int                `json:"failed"`
+	Rows      []UserImportResult `json:"rows"`
+}
+
+const (
+	// defaultImportMaxRows applies when ImportMaxRows is unset.
+	defaultImportMaxRows = 10000
+	// importBatchSize is how many rows are checked and inserted at once.
+	importBatchSize = 500
+)
+
+// errImportRejected rolls back an all-or-nothing import with failed rows.
+var errImportRejected = errors.New("import has failed rows")
+
+// Import creates the users read from src, validating each row as Create
+// does. Rows that are invalid or whose uid, name or email is taken (by an
+// existing user or an earlier row) fail; in ImportAllOrNothing mode any
+// failure rolls back the whole import. Either way the report comes back
+// without an error; errors are kept for src failures, exceeding
+// ImportMaxRows and the database.
+func (s *UserService) Import(ctx context.Context, src UserImportSource, mode ImportMode) (UserImportReport, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Import")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersWrite); err != nil {
+		return UserImportReport{}, err
+	}
+	switch mode {
+	case "":
+		mode = ImportAllOrNothing
+	case ImportAllOrNothing, ImportBestEffort:
+	default:
+		return UserImportReport{}, domain.InvalidField("mode", "oneof", "must be all_or_nothing or best_effort")
+	}
+	maxRows := s.ImportMaxRows
+	if maxRows <= 0 {
+		maxRows = defaultImportMaxRows
+	}
+
+	imp := &userImport{
+		svc:    s,
+		report: UserImportReport{Mode: mode, Rows: []UserImportResult{}},
+		uids:   map[string]int{},
+		names:  map[string]int{},
+		emails: map[string]int{},
+	}
+	err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		for {
+			row, err := src.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if len(imp.report.Rows) == maxRows {
+				return domain.Invalid(fmt.Sprintf("imports are limited to %d rows", maxRows))
+			}
+			imp.add(row)
+			if len(imp.pending) == importBatchSize {
+				if err := imp.flush(ctx); err != nil {
+					return err
+				}
+			}
+		}
+		if err := imp.flush(ctx); err != nil {
+			return err
+		}
+		if mode == ImportAllOrNothing && imp.report.Failed > 0 {
+			return errImportRejected
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errImportRejected):
+		imp.rollBack()
+	case err != nil:
+		return UserImportReport{}, dberr.Map(err)
+	default:
+		imp.report.Committed = true
+	}
+
+	r := imp.report
+	r.Total = len(r.Rows)
+	span.SetAttributes(
+		attribute.Int("users.import.rows", r.Total),
+		attribute.Int("users.import.created", r.Created),
+		attribute.Bool("users.import.committed", r.Committed),
+	)
+	return r, nil
+}
+
+type pendingUser struct {
+	// row indexes report.Rows.
+	row  int
+	user repo.NewUser
+}
+
+// userImport is the state of one Import call.
+type userImport struct {
+	svc     *UserService
+	report  UserImportReport
+	pending []pendingUser
+	// uids, names and emails map the keys of the valid rows so far to
+	// their line, to catch duplicates within the file.
+	uids, names, emails map[string]int
+}
+
+// add validates row and queues it for the next flush.
+func (imp *userImport) add(row UserImportRow) {
+	imp.report.Rows = append(imp.report.Rows, UserImportResult{Line: row.Line, Uid: strings.TrimSpace(row.Uid)})
+	i := len(imp.report.Rows) - 1
+	if row.Err != nil {
+		imp.fail(i, UserImportError{Code: ImportErrInvalid, Message: row.Err.Error()})
+		return
+	}
+	u, errs := newImportUser(row)
+	if len(errs) == 0 {
+		errs = imp.duplicates(u)
+	}
+	if len(errs) > 0 {
+		imp.fail(i, errs...)
+		return
+	}
+
+	imp.uids[u.Uid] = row.Line
+	imp.names[u.Name] = row.Line
+	if u.Email != nil {
+		imp.emails[*u.Email] = row.Line
+	}
+	imp.pending = append(imp.pending, pendingUser{row: i, user: u})
+}
+
+// newImportUser applies Create's rules to row.
+func newImportUser(row UserImportRow) (repo.NewUser, []UserImportError) {
+	var errs []UserImportError
+	u := repo.NewUser{
+		Uid:      strings.TrimSpace(row.Uid),
+		Name:     strings.TrimSpace(row.Name),
+		UsedName: optionalField(row.UsedName),
+		Company:  optionalField(row.Company),
+	}
+	if u.Uid == "" {
+		errs = append(errs, UserImportError{Field: "uid", Code: ImportErrInvalid, Message: "is required"})
+	}
+	if u.Name == "" {
+		errs = append(errs, UserImportError{Field: "name", Code: ImportErrInvalid, Message: "is required"})
+	}
+	email, err := normalizeEmail(&row.Email)
+	if err != nil {
+		errs = append(errs, UserImportError{Field: "email", Code: ImportErrInvalidEmail, Message: "must be a valid email address"})
+	}
+	u.Email = email
+	if b := strings.TrimSpace(row.Birth); b != "" {
+		t, err := time.Parse(time.DateOnly, b)
+		if err != nil {
+			errs = append(errs, UserImportError{Field: "birth", Code: ImportErrInvalid, Message: "must be in YYYY-MM-DD format"})
+		} else {
+			u.Birth = &t
+		}
+	}
+	return u, errs
+}
+
+func optionalField(v string) *string {
+	if v == "" {
+		return nil
+	}
+	return &v
+}
+
+// duplicates reports the keys of u that an earlier row already has.
+func (imp *userImport) duplicates(u repo.NewUser) []UserImportError {
+	var errs []UserImportError
+	if line, ok := imp.uids[u.Uid]; ok {
+		errs = append(errs, UserImportError{Field: "uid", Code: ImportErrUIDConflict, Message: fmt.Sprintf("duplicates line %d", line)})
+	}
+	if line, ok := imp.names[u.Name]; ok {
+		errs = append(errs, UserImportError{Field: "name", Code: ImportErrNameConflict, Message: fmt.Sprintf("duplicates line %d", line)})
+	}
+	if u.Email != nil {
+		if line, ok := imp.emails[*u.Email]; ok {
+			errs = append(errs, UserImportError{Field: "email", Code: ImportErrEmailConflict, Message: fmt.Sprintf("duplicates line %d", line)})
+		}
+	}
+	return errs
+}
+
+// flush creates the pending rows whose keys are still free.
+func (imp *userImport) flush(ctx context.Context) error {
+	if len(imp.pending) == 0 {
+		return nil
+	}
+	pending := imp.pending
+	imp.pending = imp.pending[:0:0]
+
+	var uids, names, emails []string
+	for _, p := range pending {
+		uids = append(uids, p.user.Uid)
+		names = append(names, p.user.Name)
+		if p.user.Email != nil {
+			emails = append(emails, *p.user.Email)
+		}
+	}
+	taken, err := imp.svc.Users.TakenKeys(ctx, uids, names, emails)
+	if err != nil {
+		return err
+	}
+
+	creating := make([]pendingUser, 0, len(pending))
+	users := make([]repo.NewUser, 0, len(pending))
+	for _, p := range pending {
+		var errs []UserImportError
+		if taken.UIDs[p.user.Uid] {
+			errs = append(errs, UserImportError{Field: "uid", Code: ImportErrUIDConflict, Message: "already exists"})
+		}
+		if taken.Names[p.user.Name] {
+			errs = append(errs, UserImportError{Field: "name", Code: ImportErrNameConflict, Message: "already exists"})
+		}
+		if p.user.Email != nil && taken.Emails[*p.user.Email] {
+			errs = append(errs, UserImportError{Field: "email", Code: ImportErrEmailConflict, Message: "already exists"})
+		}
+		if len(errs) > 0 {
+			imp.fail(p.row, errs...)
+			continue
+		}
+		creating = append(creating, p)
+		users = append(users, p.user)
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	created, err := imp.svc.Users.CreateMany(ctx, users)
+	if err != nil {
+		return err
+	}
+	byUID := make(map[string]int, len(created))
+	for i, u := range created {
+		byUID[u.Uid] = i
+	}
+	events := make([]repo.AuditEvent, 0, len(created))
+	for _, p := range creating {
+		i, ok := byUID[p.user.Uid]
+		if !ok {
+			// CreateMany skipped it: a concurrent request won the key.
+			imp.fail(p.row, UserImportError{Code: ImportErrConflict, Message: "uid, name or email was taken during the import"})
+			continue
+		}
+		res := &imp.report.Rows[p.row]
+		res.Status, res.ID = ImportStatusCreated, created[i].ID
+		imp.report.Created++
+		if imp.svc.Audit != nil {
+			e, err := auditEvent(ctx, AuditUserCreate, nil, &created[i])
+			if err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+	}
+	if imp.svc.Audit == nil {
+		return nil
+	}
+	return imp.svc.Audit.RecordMany(ctx, events)
+}
+
+func (imp *userImport) fail(row int, errs ...UserImportError) {
+	res := &imp.report.Rows[row]
+	res.Status, res.Errors = ImportStatusFailed, errs
+	imp.report.Failed++
+}
+
+// rollBack updates the report after the transaction was rolled back.
+func (imp *userImport) rollBack() {
+	for i := range imp.report.Rows {
+		if res := &imp.report.Rows[i]; res.Status == ImportStatusCreated {
+			res.Status, res.ID = ImportStatusRolledBack, 0
+		}
+	}
+	imp.report.Created = 0
+}
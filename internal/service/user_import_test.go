@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/repo"
+)
+
+type sliceImportSource []UserImportRow
+
+func (s *sliceImportSource) Next() (UserImportRow, error) {
+	if len(*s) == 0 {
+		return UserImportRow{}, io.EOF
+	}
+	row := (*s)[0]
+	*s = (*s)[1:]
+	return row, nil
+}
+
+// importUsers holds existing users by uid; CreateMany skips uids in race
+// as if a concurrent request had just taken them.
+type importUsers struct {
+	repo.UserRepo
+	users map[string]sqlc.User
+	race  map[string]bool
+}
+
+func (m *importUsers) TakenKeys(_ context.Context, uids, names, emails []string) (repo.UserKeys, error) {
+	keys := repo.UserKeys{UIDs: map[string]bool{}, Names: map[string]bool{}, Emails: map[string]bool{}}
+	for _, u := range m.users {
+		keys.UIDs[u.Uid] = true
+		keys.Names[u.Name] = true
+		if u.Email.Valid {
+			keys.Emails[u.Email.String] = true
+		}
+	}
+	return keys, nil
+}
+
+func (m *importUsers) CreateMany(_ context.Context, users []repo.NewUser) ([]sqlc.User, error) {
+	var out []sqlc.User
+	for _, nu := range users {
+		if m.race[nu.Uid] {
+			continue
+		}
+		u := sqlc.User{ID: int64(len(m.users) + 1), Uid: nu.Uid, Name: nu.Name}
+		m.users[u.Uid] = u
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+func TestImport(t *testing.T) {
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+	rows := func() *sliceImportSource {
+		return &sliceImportSource{
+			{Line: 2, Uid: " ann ", Name: "Ann", Email: "ann@example.com", Birth: "1990-01-02"},
+			{Line: 3, Uid: "bob", Name: "Bob", Email: "not an email"},
+			{Line: 4, Uid: "carol", Name: "Taken"},
+			{Line: 5, Uid: "ann", Name: "Ann", Email: "ann@example.com"},
+			{Line: 6, Uid: "dave", Name: "", Birth: "02/01/1990"},
+			{Line: 7, Err: errors.New("has 2 fields, the header has 3")},
+			{Line: 8, Uid: "erin", Name: "Erin"},
+			{Line: 9, Uid: "frank", Name: "Frank"},
+		}
+	}
+	newService := func() (*UserService, *memAudit) {
+		audit := &memAudit{}
+		users := &importUsers{
+			users: map[string]sqlc.User{"taken": {ID: 100, Uid: "taken", Name: "Taken"}},
+			race:  map[string]bool{"frank": true},
+		}
+		return &UserService{Tx: noTx{}, Users: users, Audit: audit}, audit
+	}
+
+	s, audit := newService()
+	report, err := s.Import(ctx, rows(), ImportBestEffort)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if !report.Committed || report.Total != 8 || report.Created != 2 || report.Failed != 6 {
+		t.Fatalf("unexpected totals: %+v", report)
+	}
+	want := []struct {
+		status string
+		codes  []string
+	}{
+		{ImportStatusCreated, nil},
+		{ImportStatusFailed, []string{ImportErrInvalidEmail}},
+		{ImportStatusFailed, []string{ImportErrNameConflict}},
+		{ImportStatusFailed, []string{ImportErrUIDConflict, ImportErrNameConflict, ImportErrEmailConflict}},
+		{ImportStatusFailed, []string{ImportErrInvalid, ImportErrInvalid}},
+		{ImportStatusFailed, []string{ImportErrInvalid}},
+		{ImportStatusCreated, nil},
+		{ImportStatusFailed, []string{ImportErrConflict}},
+	}
+	for i, w := range want {
+		got := report.Rows[i]
+		var codes []string
+		for _, e := range got.Errors {
+			codes = append(codes, e.Code)
+		}
+		if got.Status != w.status || len(codes) != len(w.codes) {
+			t.Fatalf("row %d: got %+v, want %s %v", i, got, w.status, w.codes)
+		}
+		for j := range codes {
+			if codes[j] != w.codes[j] {
+				t.Fatalf("row %d: got codes %v, want %v", i, codes, w.codes)
+			}
+		}
+	}
+	if r := report.Rows[0]; r.Uid != "ann" || r.ID == 0 {
+		t.Fatalf("created row should carry the trimmed uid and the id: %+v", r)
+	}
+	if len(audit.events) != 2 || audit.events[0].Action != AuditUserCreate || audit.events[0].TargetUID != "ann" {
+		t.Fatalf("unexpected audit events: %+v", audit.events)
+	}
+
+	s, _ = newService()
+	report, err = s.Import(ctx, rows(), ImportAllOrNothing)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if report.Committed || report.Created != 0 || report.Rows[0].Status != ImportStatusRolledBack || report.Rows[0].ID != 0 {
+		t.Fatalf("all-or-nothing import with failures should roll back: %+v", report)
+	}
+}
+
+func TestImportLimitsRows(t *testing.T) {
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+	s := &UserService{Tx: noTx{}, Users: &importUsers{users: map[string]sqlc.User{}}, ImportMaxRows: 1}
+
+	_, err := s.Import(ctx, &sliceImportSource{{Line: 1, Uid: "a", Name: "A"}, {Line: 2, Uid: "b", Name: "B"}}, ImportBestEffort)
+	var ae *domain.AppError
+	if !errors.As(err, &ae) || ae.Code != domain.CodeInvalidArgument {
+		t.Fatalf("got %v, want INVALID_ARGUMENT", err)
+	}
+	if _, err := s.Import(ctx, &sliceImportSource{}, "some"); err == nil {
+		t.Fatal("unknown mode should be rejected")
+	}
+}
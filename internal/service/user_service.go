@@ -3,44 +3,69 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/mail"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/tfenng/scaffold/internal/auth"
 	"github.com/tfenng/scaffold/internal/cache"
+	"github.com/tfenng/scaffold/internal/cursor"
+	"github.com/tfenng/scaffold/internal/dberr"
 	"github.com/tfenng/scaffold/internal/domain"
 	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/logging"
+	"github.com/tfenng/scaffold/internal/patch"
 	"github.com/tfenng/scaffold/internal/repo"
+	"github.com/tfenng/scaffold/internal/tracing"
 )
 
+// UserService enforces the users:* permissions on every call, so callers
+// outside HTTP must put a principal (e.g. auth.System()) in the context.
 type UserService struct {
 	Tx     repo.TxManager
 	Users  repo.UserRepo
 	Query  repo.UserQueryRepo
-	UCache *cache.UserCache
+	UCache cache.UserStore
+	// Audit, when set, records every mutation in the mutation's transaction.
+	Audit repo.AuditRepo
+	// Cursors signs the page tokens handed out by Scroll.
+	Cursors *cursor.Codec
+	// SearchSimilarity is the trigram word similarity (0-1) a typo needs
+	// to still match in Search, i.e. pg_trgm.word_similarity_threshold for
+	// the `<%` operator. 0 sets nothing, leaving the server's setting.
+	SearchSimilarity float64
+	// ImportMaxRows caps the rows of one Import; 0 means 10000.
+	ImportMaxRows int
 }
 
-// Postgres SQLSTATE
-const (
-	sqlStateUniqueViolation = "23505"
-	constraintUsersUIDUnique       = "users_uid_unique"
-	constraintUsersNameUnique      = "users_name_unique"
-	constraintUsersEmailUnique     = "users_email_unique_not_null"
-	constraintUsersEmailUniqueOld  = "users_email_key"
-)
-
 func (s *UserService) GetByID(ctx context.Context, id int64) (sqlc.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.GetByID", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersRead); err != nil {
+		return sqlc.User{}, err
+	}
 	if id <= 0 {
-		return sqlc.User{}, domain.Invalid("id must be positive")
+		return sqlc.User{}, domain.InvalidField("id", "positive", "must be positive")
 	}
 
 	if s.UCache != nil {
-		if u, ok, err := s.UCache.Get(ctx, id); err == nil && ok {
+		u, ok, err := s.UCache.Get(ctx, id)
+		span.SetAttributes(attribute.Bool("cache.hit", err == nil && ok))
+		if err == nil && ok {
 			return u, nil
 		}
+		if err != nil && !errors.Is(err, cache.ErrCircuitOpen) {
+			logging.From(ctx).Warn("user cache get failed", "id", id, "err", err)
+		}
 	}
 
 	u, err := s.Users.GetByID(ctx, id)
@@ -48,7 +73,7 @@ func (s *UserService) GetByID(ctx context.Context, id int64) (sqlc.User, error)
 		if errors.Is(err, pgx.ErrNoRows) {
 			return sqlc.User{}, domain.NotFound("user not found")
 		}
-		return sqlc.User{}, domain.Internal(err)
+		return sqlc.User{}, dberr.Map(err)
 	}
 
 	if s.UCache != nil {
@@ -58,36 +83,41 @@ func (s *UserService) GetByID(ctx context.Context, id int64) (sqlc.User, error)
 }
 
 func (s *UserService) Create(ctx context.Context, uid string, email *string, name string, usedName, company *string, birth *time.Time) (sqlc.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Create")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersWrite); err != nil {
+		return sqlc.User{}, err
+	}
 	uid = strings.TrimSpace(uid)
 	name = strings.TrimSpace(name)
 	if uid == "" || name == "" {
-		return sqlc.User{}, domain.Invalid("uid and name are required")
+		var fields []domain.FieldViolation
+		if uid == "" {
+			fields = append(fields, domain.FieldViolation{Field: "uid", Rule: "required", Message: "is required"})
+		}
+		if name == "" {
+			fields = append(fields, domain.FieldViolation{Field: "name", Rule: "required", Message: "is required"})
+		}
+		return sqlc.User{}, domain.InvalidFields("uid and name are required", fields...)
 	}
 
 	normalizedEmail, err := normalizeEmail(email)
 	if err != nil {
-		return sqlc.User{}, domain.Invalid("email must be a valid email address")
+		return sqlc.User{}, domain.InvalidField("email", "email", "must be a valid email address")
 	}
 
 	var out sqlc.User
 	err = s.Tx.WithinTx(ctx, func(ctx context.Context) error {
 		u, err := s.Users.Create(ctx, uid, normalizedEmail, name, usedName, company, birth)
 		if err != nil {
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) && pgErr.Code == sqlStateUniqueViolation {
-				return mapUniqueViolation(pgErr)
-			}
-			return domain.Internal(err)
+			return dberr.Map(err)
 		}
 		out = u
-		return nil
+		return s.recordAudit(ctx, AuditUserCreate, nil, &u)
 	})
 	if err != nil {
-		var ae *domain.AppError
-		if errors.As(err, &ae) {
-			return sqlc.User{}, ae
-		}
-		return sqlc.User{}, domain.Internal(err)
+		return sqlc.User{}, dberr.Map(err)
 	}
 
 	if s.UCache != nil {
@@ -97,48 +127,184 @@ func (s *UserService) Create(ctx context.Context, uid string, email *string, nam
 }
 
 func (s *UserService) List(ctx context.Context, f repo.UserListFilter) (repo.Page[sqlc.User], error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.List")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersRead); err != nil {
+		return repo.Page[sqlc.User]{}, err
+	}
+	if f.IncludeDeleted {
+		if err := auth.Require(ctx, auth.PermUsersReadDeleted); err != nil {
+			return repo.Page[sqlc.User]{}, err
+		}
+	}
 	out, err := s.Query.List(ctx, f)
 	if err != nil {
-		return repo.Page[sqlc.User]{}, domain.Internal(err)
+		return repo.Page[sqlc.User]{}, dberr.Map(err)
 	}
 	return out, nil
 }
 
-func (s *UserService) Update(ctx context.Context, id int64, email *string, name string, usedName, company *string, birth *time.Time) (sqlc.User, error) {
+// Scroll lists users by keyset. token is a cursor from a previous page
+// (empty for the first page) and must come with the same filters; the total
+// is only counted when withTotal is set.
+func (s *UserService) Scroll(ctx context.Context, f repo.UserListFilter, token string, withTotal bool) (repo.CursorPage[sqlc.User], error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Scroll")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersRead); err != nil {
+		return repo.CursorPage[sqlc.User]{}, err
+	}
+	if f.IncludeDeleted {
+		if err := auth.Require(ctx, auth.PermUsersReadDeleted); err != nil {
+			return repo.CursorPage[sqlc.User]{}, err
+		}
+	}
+
+	var sc repo.UserScroll
+	if token != "" {
+		var err error
+		if sc, err = decodeUserCursor(s.Cursors, token, f); err != nil {
+			return repo.CursorPage[sqlc.User]{}, err
+		}
+	}
+	sc.WithTotal = withTotal
+
+	w, err := s.Query.Scroll(ctx, f, sc)
+	if err != nil {
+		return repo.CursorPage[sqlc.User]{}, dberr.Map(err)
+	}
+	next, prev, err := pageCursors(s.Cursors, f, sc, w)
+	if err != nil {
+		return repo.CursorPage[sqlc.User]{}, err
+	}
+	return repo.CursorPage[sqlc.User]{Items: w.Items, PageSize: w.PageSize, NextCursor: next, PrevCursor: prev, Total: w.Total}, nil
+}
+
+// Export calls fn for every user matching f, in f.Sort order, without
+// paging. All rows come from one REPEATABLE READ snapshot, so a long export
+// is consistent even while users change. It stops at fn's first error,
+// which it returns as is.
+func (s *UserService) Export(ctx context.Context, f repo.UserListFilter, fn func(sqlc.User) error) error {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Export")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersRead); err != nil {
+		return err
+	}
+	if f.IncludeDeleted {
+		if err := auth.Require(ctx, auth.PermUsersReadDeleted); err != nil {
+			return err
+		}
+	}
+
+	var n int
+	var fnErr error
+	err := s.Tx.WithinSnapshot(ctx, func(ctx context.Context) error {
+		return s.Query.Each(ctx, f, func(u sqlc.User) error {
+			if fnErr = fn(u); fnErr != nil {
+				return fnErr
+			}
+			n++
+			return nil
+		})
+	})
+	span.SetAttributes(attribute.Int("users.exported", n))
+	if err != nil {
+		if fnErr != nil {
+			return fnErr
+		}
+		return dberr.Map(err)
+	}
+	return nil
+}
+
+const (
+	maxSearchQueryLen = 200
+	maxSearchLimit    = 100
+)
+
+// Search finds live users whose name, used_name, company, uid or email
+// match query, tolerating typos, best match first.
+func (s *UserService) Search(ctx context.Context, query string, limit int32) ([]repo.UserSearchHit, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Search")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersRead); err != nil {
+		return nil, err
+	}
+	query = strings.TrimSpace(query)
+	switch {
+	case query == "":
+		return nil, domain.InvalidField("q", "required", "is required")
+	case utf8.RuneCountInString(query) > maxSearchQueryLen:
+		return nil, domain.InvalidField("q", "max", fmt.Sprintf("must be at most %d characters", maxSearchQueryLen))
+	case !strings.ContainsFunc(query, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }):
+		return nil, domain.InvalidField("q", "search", "must contain a letter or digit")
+	}
+	if limit <= 0 || limit > maxSearchLimit {
+		limit = 20
+	}
+
+	var hits []repo.UserSearchHit
+	// The similarity threshold is set per transaction.
+	err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		var err error
+		hits, err = s.Query.Search(ctx, query, limit, s.SearchSimilarity)
+		return err
+	})
+	if err != nil {
+		return nil, dberr.Map(err)
+	}
+	return hits, nil
+}
+
+// Update replaces the user's fields. When ifMatch is set, the update only
+// applies while the user is still at that version (FAILED_PRECONDITION
+// otherwise).
+func (s *UserService) Update(ctx context.Context, id int64, ifMatch *int64, email *string, name string, usedName, company *string, birth *time.Time) (sqlc.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Update", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersWrite); err != nil {
+		return sqlc.User{}, err
+	}
 	if id <= 0 {
-		return sqlc.User{}, domain.Invalid("id must be positive")
+		return sqlc.User{}, domain.InvalidField("id", "positive", "must be positive")
 	}
 	if name == "" {
-		return sqlc.User{}, domain.Invalid("name is required")
+		return sqlc.User{}, domain.InvalidField("name", "required", "is required")
 	}
 
 	normalizedEmail, err := normalizeEmail(email)
 	if err != nil {
-		return sqlc.User{}, domain.Invalid("email must be a valid email address")
+		return sqlc.User{}, domain.InvalidField("email", "email", "must be a valid email address")
 	}
 
 	var out sqlc.User
 	err = s.Tx.WithinTx(ctx, func(ctx context.Context) error {
-		u, err := s.Users.Update(ctx, id, normalizedEmail, name, usedName, company, birth)
+		before, err := s.Users.GetByIDForUpdate(ctx, id)
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return domain.NotFound("user not found")
 			}
-			var pgErr *pgconn.PgError
-			if errors.As(err, &pgErr) && pgErr.Code == sqlStateUniqueViolation {
-				return mapUniqueViolation(pgErr)
+			return dberr.Map(err)
+		}
+		if err := checkVersion(before, ifMatch); err != nil {
+			return err
+		}
+		u, err := s.Users.Update(ctx, id, before.Version, normalizedEmail, name, usedName, company, birth)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return errVersionMismatch()
 			}
-			return domain.Internal(err)
+			return dberr.Map(err)
 		}
 		out = u
-		return nil
+		return s.recordAudit(ctx, AuditUserUpdate, &before, &u)
 	})
 	if err != nil {
-		var ae *domain.AppError
-		if errors.As(err, &ae) {
-			return sqlc.User{}, ae
-		}
-		return sqlc.User{}, domain.Internal(err)
+		return sqlc.User{}, dberr.Map(err)
 	}
 
 	if s.UCache != nil {
@@ -147,27 +313,105 @@ func (s *UserService) Update(ctx context.Context, id int64, email *string, name
 	return out, nil
 }
 
-func (s *UserService) Delete(ctx context.Context, id int64) error {
+// Patch applies a merge patch: only the fields present in p change, and null
+// clears an optional field. ifMatch works as in Update. An empty patch returns
+// the user unchanged without bumping its version.
+func (s *UserService) Patch(ctx context.Context, id int64, ifMatch *int64, p repo.UserPatch) (sqlc.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Patch", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersWrite); err != nil {
+		return sqlc.User{}, err
+	}
 	if id <= 0 {
-		return domain.Invalid("id must be positive")
+		return sqlc.User{}, domain.InvalidField("id", "positive", "must be positive")
+	}
+	if p.Name.Present {
+		p.Name.Value = strings.TrimSpace(p.Name.Value)
+		if p.Name.Null || p.Name.Value == "" {
+			return sqlc.User{}, domain.InvalidField("name", "required", "is required")
+		}
+	}
+	if p.Email.IsSet() {
+		normalizedEmail, err := normalizeEmail(&p.Email.Value)
+		if err != nil {
+			return sqlc.User{}, domain.InvalidField("email", "email", "must be a valid email address")
+		}
+		if normalizedEmail == nil {
+			p.Email = patch.Clear[string]()
+		} else {
+			p.Email = patch.Set(*normalizedEmail)
+		}
 	}
 
+	var out sqlc.User
 	err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
-		err := s.Users.Delete(ctx, id)
+		before, err := s.Users.GetByIDForUpdate(ctx, id)
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return domain.NotFound("user not found")
 			}
-			return domain.Internal(err)
+			return dberr.Map(err)
 		}
-		return nil
+		if err := checkVersion(before, ifMatch); err != nil {
+			return err
+		}
+		if p.Empty() {
+			out = before
+			return nil
+		}
+		u, err := s.Users.Patch(ctx, id, before.Version, p)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return errVersionMismatch()
+			}
+			return dberr.Map(err)
+		}
+		out = u
+		return s.recordAudit(ctx, AuditUserUpdate, &before, &u)
 	})
 	if err != nil {
-		var ae *domain.AppError
-		if errors.As(err, &ae) {
-			return ae
+		return sqlc.User{}, dberr.Map(err)
+	}
+
+	if s.UCache != nil {
+		_ = s.UCache.Set(ctx, out)
+	}
+	return out, nil
+}
+
+// Delete soft-deletes the user; it can be restored until the purge job
+// removes it. ifMatch works as in Update.
+func (s *UserService) Delete(ctx context.Context, id int64, ifMatch *int64) error {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Delete", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersDelete); err != nil {
+		return err
+	}
+	if id <= 0 {
+		return domain.InvalidField("id", "positive", "must be positive")
+	}
+
+	err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		before, err := s.Users.GetByIDForUpdate(ctx, id)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return domain.NotFound("user not found")
+			}
+			return dberr.Map(err)
+		}
+		if err := checkVersion(before, ifMatch); err != nil {
+			return err
 		}
-		return domain.Internal(err)
+		u, err := s.Users.Delete(ctx, id)
+		if err != nil {
+			return dberr.Map(err)
+		}
+		return s.recordAudit(ctx, AuditUserDelete, &before, &u)
+	})
+	if err != nil {
+		return dberr.Map(err)
 	}
 
 	if s.UCache != nil {
@@ -176,6 +420,147 @@ func (s *UserService) Delete(ctx context.Context, id int64) error {
 	return nil
 }
 
+// Restore undeletes a soft-deleted user. Restoring fails with CONFLICT when
+// its name or email has been taken by another user in the meantime.
+func (s *UserService) Restore(ctx context.Context, id int64) (sqlc.User, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Restore", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersDelete); err != nil {
+		return sqlc.User{}, err
+	}
+	if id <= 0 {
+		return sqlc.User{}, domain.InvalidField("id", "positive", "must be positive")
+	}
+
+	var out sqlc.User
+	err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		u, deletedAt, err := s.Users.Restore(ctx, id)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return domain.NotFound("deleted user not found")
+			}
+			return dberr.Map(err)
+		}
+		out = u
+		before := u
+		before.DeletedAt = pgtype.Timestamptz{Time: deletedAt, Valid: true}
+		return s.recordAudit(ctx, AuditUserRestore, &before, &u)
+	})
+	if err != nil {
+		return sqlc.User{}, dberr.Map(err)
+	}
+
+	if s.UCache != nil {
+		_ = s.UCache.Set(ctx, out)
+	}
+	return out, nil
+}
+
+// Purge hard-deletes up to limit users that were soft-deleted before the
+// cutoff, recording a purge event for each, and returns how many it removed.
+func (s *UserService) Purge(ctx context.Context, before time.Time, limit int32) (int, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.Purge")
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersDelete); err != nil {
+		return 0, err
+	}
+
+	var n int
+	err := s.Tx.WithinTx(ctx, func(ctx context.Context) error {
+		users, err := s.Users.PurgeDeleted(ctx, before, limit)
+		if err != nil {
+			return dberr.Map(err)
+		}
+		for i := range users {
+			if err := s.recordAudit(ctx, AuditUserPurge, &users[i], nil); err != nil {
+				return err
+			}
+		}
+		n = len(users)
+		return nil
+	})
+	if err != nil {
+		return 0, dberr.Map(err)
+	}
+	span.SetAttributes(attribute.Int("users.purged", n))
+	return n, nil
+}
+
+// RunPurge purges users deleted longer than retention ago every interval
+// until ctx is done. It acts as auth.System().
+func (s *UserService) RunPurge(ctx context.Context, retention, interval time.Duration) {
+	ctx = auth.WithPrincipal(ctx, auth.System())
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		cutoff := time.Now().Add(-retention)
+		for {
+			n, err := s.Purge(ctx, cutoff, purgeBatchSize)
+			if err != nil {
+				if ctx.Err() == nil {
+					logging.From(ctx).Error("user purge failed", "err", err)
+				}
+				break
+			}
+			if n > 0 {
+				logging.From(ctx).Info("purged deleted users", "count", n)
+			}
+			if n < purgeBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// purgeBatchSize bounds how many rows one purge transaction deletes.
+const purgeBatchSize = 500
+
+// AuditTrail pages through the audit events of user id, newest first. The
+// trail stays readable after the user is purged.
+func (s *UserService) AuditTrail(ctx context.Context, id int64, page, pageSize int32) (repo.Page[sqlc.AuditEvent], error) {
+	ctx, span := tracing.Tracer().Start(ctx, "UserService.AuditTrail", trace.WithAttributes(attribute.Int64("user.id", id)))
+	defer span.End()
+
+	if err := auth.Require(ctx, auth.PermUsersRead); err != nil {
+		return repo.Page[sqlc.AuditEvent]{}, err
+	}
+	if id <= 0 {
+		return repo.Page[sqlc.AuditEvent]{}, domain.InvalidField("id", "positive", "must be positive")
+	}
+	if s.Audit == nil {
+		return repo.Page[sqlc.AuditEvent]{Items: []sqlc.AuditEvent{}}, nil
+	}
+	out, err := s.Audit.ListByTarget(ctx, id, page, pageSize)
+	if err != nil {
+		return repo.Page[sqlc.AuditEvent]{}, dberr.Map(err)
+	}
+	return out, nil
+}
+
+// errVersionMismatch builds a fresh error each time: an AppError is mutable
+// and must not be shared between requests.
+func errVersionMismatch() error {
+	return domain.FailedPrecondition("user has been modified by someone else")
+}
+
+// checkVersion compares the locked row against the version the caller last
+// saw; a nil ifMatch skips the check.
+func checkVersion(u sqlc.User, ifMatch *int64) error {
+	if ifMatch != nil && *ifMatch != u.Version {
+		return errVersionMismatch()
+	}
+	return nil
+}
+
 func normalizeEmail(email *string) (*string, error) {
 	if email == nil {
 		return nil, nil
@@ -191,16 +576,3 @@ func normalizeEmail(email *string) (*string, error) {
 	}
 	return &v, nil
 }
-
-func mapUniqueViolation(pgErr *pgconn.PgError) *domain.AppError {
-	switch pgErr.ConstraintName {
-	case constraintUsersUIDUnique:
-		return domain.Conflict("uid already exists")
-	case constraintUsersNameUnique:
-		return domain.Conflict("name already exists")
-	case constraintUsersEmailUnique, constraintUsersEmailUniqueOld:
-		return domain.Conflict("email already exists")
-	default:
-		return domain.Conflict("unique constraint violation")
-	}
-}
@@ -1,10 +1,24 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/tfenng/scaffold/internal/auth"
+	"github.com/tfenng/scaffold/internal/cursor"
+	"github.com/tfenng/scaffold/internal/dberr"
 	"github.com/tfenng/scaffold/internal/domain"
+	sqlc "github.com/tfenng/scaffold/internal/gen/sqlc"
+	"github.com/tfenng/scaffold/internal/patch"
+	"github.com/tfenng/scaffold/internal/repo"
 )
 
 func TestMapUniqueViolation(t *testing.T) {
@@ -16,25 +30,37 @@ func TestMapUniqueViolation(t *testing.T) {
 	}{
 		{
 			name:       "uid unique",
-			constraint: constraintUsersUIDUnique,
+			constraint: "users_uid_unique",
 			wantCode:   domain.CodeConflict,
 			wantMsg:    "uid already exists",
 		},
 		{
 			name:       "name unique",
-			constraint: constraintUsersNameUnique,
+			constraint: "users_name_unique",
 			wantCode:   domain.CodeConflict,
 			wantMsg:    "name already exists",
 		},
 		{
 			name:       "email unique partial index",
-			constraint: constraintUsersEmailUnique,
+			constraint: "users_email_unique_not_null",
+			wantCode:   domain.CodeConflict,
+			wantMsg:    "email already exists",
+		},
+		{
+			name:       "name unique among live users",
+			constraint: "users_name_unique_live",
+			wantCode:   domain.CodeConflict,
+			wantMsg:    "name already exists",
+		},
+		{
+			name:       "email unique among live users",
+			constraint: "users_email_unique_live",
 			wantCode:   domain.CodeConflict,
 			wantMsg:    "email already exists",
 		},
 		{
 			name:       "email unique legacy constraint",
-			constraint: constraintUsersEmailUniqueOld,
+			constraint: "users_email_key",
 			wantCode:   domain.CodeConflict,
 			wantMsg:    "email already exists",
 		},
@@ -48,13 +74,300 @@ func TestMapUniqueViolation(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			err := mapUniqueViolation(&pgconn.PgError{ConstraintName: tc.constraint})
-			if err.Code != tc.wantCode {
-				t.Fatalf("unexpected code: got=%s want=%s", err.Code, tc.wantCode)
+			// Wrapped the way pgx and WithinTx hand it back.
+			pgErr := &pgconn.PgError{Code: dberr.UniqueViolation, ConstraintName: tc.constraint}
+			err := dberr.Map(fmt.Errorf("create user: %w", pgErr))
+
+			var ae *domain.AppError
+			if !errors.As(err, &ae) {
+				t.Fatalf("expected *domain.AppError, got %T", err)
+			}
+			if ae.Code != tc.wantCode {
+				t.Fatalf("unexpected code: got=%s want=%s", ae.Code, tc.wantCode)
+			}
+			if ae.Message != tc.wantMsg {
+				t.Fatalf("unexpected message: got=%q want=%q", ae.Message, tc.wantMsg)
 			}
-			if err.Message != tc.wantMsg {
-				t.Fatalf("unexpected message: got=%q want=%q", err.Message, tc.wantMsg)
+			if ae.HTTPStatus != 409 {
+				t.Fatalf("unexpected status: got=%d", ae.HTTPStatus)
 			}
 		})
 	}
 }
+
+type softDeleteUsers struct {
+	repo.UserRepo
+	u      sqlc.User
+	purged []sqlc.User
+}
+
+func (s *softDeleteUsers) GetByIDForUpdate(context.Context, int64) (sqlc.User, error) {
+	return s.u, nil
+}
+
+func (s *softDeleteUsers) Delete(context.Context, int64) (sqlc.User, error) {
+	s.u.DeletedAt = pgtype.Timestamptz{Time: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC), Valid: true}
+	return s.u, nil
+}
+
+func (s *softDeleteUsers) PurgeDeleted(context.Context, time.Time, int32) ([]sqlc.User, error) {
+	return s.purged, nil
+}
+
+func TestDeleteIsSoftAndAudited(t *testing.T) {
+	audit := &memAudit{}
+	s := &UserService{Tx: noTx{}, Users: &softDeleteUsers{u: sqlc.User{ID: 3, Uid: "carol", Name: "Carol"}}, Audit: audit}
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+
+	if err := s.Delete(ctx, 3, nil); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if len(audit.events) != 1 || audit.events[0].Action != AuditUserDelete {
+		t.Fatalf("unexpected events: %+v", audit.events)
+	}
+	var changes map[string]fieldChange
+	if err := json.Unmarshal(audit.events[0].Changes, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes["deleted_at"].Before != nil || *changes["deleted_at"].After != "2026-10-01T00:00:00Z" {
+		t.Fatalf("unexpected changes: %s", audit.events[0].Changes)
+	}
+}
+
+func TestPurgeRecordsEachUser(t *testing.T) {
+	audit := &memAudit{}
+	users := &softDeleteUsers{purged: []sqlc.User{{ID: 4, Uid: "dave"}, {ID: 5, Uid: "erin"}}}
+	s := &UserService{Tx: noTx{}, Users: users, Audit: audit}
+	ctx := auth.WithPrincipal(context.Background(), auth.System())
+
+	n, err := s.Purge(ctx, time.Now(), 500)
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if n != 2 || len(audit.events) != 2 {
+		t.Fatalf("got n=%d events=%d, want 2", n, len(audit.events))
+	}
+	for _, e := range audit.events {
+		if e.Action != AuditUserPurge || e.Actor != "system" {
+			t.Fatalf("unexpected event: %+v", e)
+		}
+	}
+}
+
+func TestListIncludeDeletedRequiresPermission(t *testing.T) {
+	s := &UserService{}
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleSupport}})
+
+	_, err := s.List(ctx, repo.UserListFilter{IncludeDeleted: true})
+	wantCode(t, err, domain.CodeForbidden)
+}
+
+func TestUpdateRejectsStaleVersion(t *testing.T) {
+	audit := &memAudit{}
+	s := &UserService{Tx: noTx{}, Users: &auditUsers{u: sqlc.User{ID: 9, Uid: "bob", Name: "Bob", Version: 3}}, Audit: audit}
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+
+	stale := int64(2)
+	_, err := s.Update(ctx, 9, &stale, nil, "Robert", nil, nil, nil)
+	wantCode(t, err, domain.CodeFailedPrecondition)
+	if len(audit.events) != 0 {
+		t.Fatalf("stale update was audited: %+v", audit.events)
+	}
+
+	current := int64(3)
+	if _, err := s.Update(ctx, 9, &current, nil, "Robert", nil, nil, nil); err != nil {
+		t.Fatalf("update at current version: %v", err)
+	}
+}
+
+func (a *auditUsers) Patch(_ context.Context, _, _ int64, p repo.UserPatch) (sqlc.User, error) {
+	if p.Name.Present {
+		a.u.Name = p.Name.Value
+	}
+	if p.Email.Present {
+		a.u.Email = pgtype.Text{String: p.Email.Value, Valid: !p.Email.Null}
+	}
+	a.u.Version++
+	return a.u, nil
+}
+
+func TestPatch(t *testing.T) {
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+	newService := func() (*UserService, *memAudit) {
+		audit := &memAudit{}
+		users := &auditUsers{u: sqlc.User{ID: 9, Uid: "bob", Name: "Bob", Email: pgtype.Text{String: "b@x.io", Valid: true}, Version: 1}}
+		return &UserService{Tx: noTx{}, Users: users, Audit: audit}, audit
+	}
+
+	t.Run("empty patch is a no-op", func(t *testing.T) {
+		s, audit := newService()
+		u, err := s.Patch(ctx, 9, nil, repo.UserPatch{})
+		if err != nil || u.Version != 1 || len(audit.events) != 0 {
+			t.Fatalf("u=%+v err=%v events=%d", u, err, len(audit.events))
+		}
+	})
+
+	t.Run("name cannot be cleared", func(t *testing.T) {
+		s, _ := newService()
+		_, err := s.Patch(ctx, 9, nil, repo.UserPatch{Name: patch.Clear[string]()})
+		wantCode(t, err, domain.CodeInvalidArgument)
+	})
+
+	t.Run("blank email clears it", func(t *testing.T) {
+		s, audit := newService()
+		u, err := s.Patch(ctx, 9, nil, repo.UserPatch{Email: patch.Set("  ")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Email.Valid || u.Name != "Bob" || len(audit.events) != 1 {
+			t.Fatalf("u=%+v events=%d", u, len(audit.events))
+		}
+	})
+}
+
+// memQuery scrolls over users already in list order; keys must name one of
+// them.
+type memQuery struct {
+	repo.UserQueryRepo
+	users []sqlc.User
+}
+
+func (m *memQuery) Scroll(_ context.Context, f repo.UserListFilter, s repo.UserScroll) (repo.Window[sqlc.User], error) {
+	at := func(k repo.Keyset) int {
+		return slices.IndexFunc(m.users, func(u sqlc.User) bool { return u.ID == k.ID })
+	}
+	items := m.users
+	switch {
+	case s.After != nil:
+		items = m.users[at(*s.After)+1:]
+	case s.Before != nil:
+		items = m.users[:at(*s.Before)]
+	}
+	w := repo.Window[sqlc.User]{PageSize: f.PageSize}
+	if w.More = len(items) > int(f.PageSize); w.More {
+		if s.Before != nil {
+			items = items[len(items)-int(f.PageSize):]
+		} else {
+			items = items[:f.PageSize]
+		}
+	}
+	w.Items = items
+	return w, nil
+}
+
+func (m *memQuery) Each(_ context.Context, _ repo.UserListFilter, fn func(sqlc.User) error) error {
+	for _, u := range m.users {
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotTx notes whether work ran in a snapshot.
+type snapshotTx struct {
+	noTx
+	used bool
+}
+
+func (tx *snapshotTx) WithinSnapshot(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx.used = true
+	return fn(ctx)
+}
+
+func TestExport(t *testing.T) {
+	tx := &snapshotTx{}
+	s := &UserService{Tx: tx, Query: &memQuery{users: []sqlc.User{{ID: 3}, {ID: 2}, {ID: 1}}}}
+	admin := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+
+	var ids []int64
+	err := s.Export(admin, repo.UserListFilter{}, func(u sqlc.User) error {
+		ids = append(ids, u.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tx.used || fmt.Sprint(ids) != "[3 2 1]" {
+		t.Fatalf("got %v, snapshot %v", ids, tx.used)
+	}
+
+	// The caller's own error comes back untouched.
+	stop := errors.New("client went away")
+	err = s.Export(admin, repo.UserListFilter{}, func(sqlc.User) error { return stop })
+	if err != stop {
+		t.Fatalf("got %v, want the callback's error", err)
+	}
+
+	support := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleSupport}})
+	err = s.Export(support, repo.UserListFilter{IncludeDeleted: true}, func(sqlc.User) error { return nil })
+	wantCode(t, err, domain.CodeForbidden)
+}
+
+func TestScrollCursors(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(id int64, d time.Duration) sqlc.User {
+		return sqlc.User{ID: id, CreatedAt: pgtype.Timestamptz{Time: t0.Add(d), Valid: true}}
+	}
+	// Users 4 and 3 share a timestamp, so the id breaks the tie.
+	q := &memQuery{users: []sqlc.User{at(5, 3*time.Second), at(4, 2*time.Second), at(3, 2*time.Second), at(2, time.Second), at(1, 0)}}
+	s := &UserService{Query: q, Cursors: cursor.NewRandom()}
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+	f := repo.UserListFilter{PageSize: 2}
+
+	ids := func(p repo.CursorPage[sqlc.User]) []int64 {
+		var out []int64
+		for _, u := range p.Items {
+			out = append(out, u.ID)
+		}
+		return out
+	}
+	page := func(token string, want ...int64) repo.CursorPage[sqlc.User] {
+		t.Helper()
+		p, err := s.Scroll(ctx, f, token, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ids(p); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		return p
+	}
+
+	p1 := page("", 5, 4)
+	if p1.PrevCursor != nil || p1.NextCursor == nil {
+		t.Fatalf("first page cursors: %+v", p1)
+	}
+	p2 := page(*p1.NextCursor, 3, 2)
+	p3 := page(*p2.NextCursor, 1)
+	if p3.NextCursor != nil {
+		t.Fatal("last page has a next cursor")
+	}
+	back := page(*p3.PrevCursor, 3, 2)
+	first := page(*back.PrevCursor, 5, 4)
+	if first.PrevCursor != nil {
+		t.Fatal("first page reached backwards has a prev cursor")
+	}
+
+	// A cursor is tied to the filters and sort it was issued with.
+	other := f
+	other.Where = repo.UserFilter{All: []repo.UserCond{{Field: "name", Op: repo.OpLike, Values: []any{"a"}}}}
+	_, err := s.Scroll(ctx, other, *p1.NextCursor, false)
+	wantCode(t, err, domain.CodeInvalidArgument)
+	sorted := f
+	sorted.Sort = repo.UserSort{{Field: "name"}}
+	_, err = s.Scroll(ctx, sorted, *p1.NextCursor, false)
+	wantCode(t, err, domain.CodeInvalidArgument)
+	_, err = s.Scroll(ctx, f, "garbage", false)
+	wantCode(t, err, domain.CodeInvalidArgument)
+}
+
+func TestSearchValidatesQuery(t *testing.T) {
+	s := &UserService{}
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "1", Roles: []string{auth.RoleAdmin}})
+
+	for _, q := range []string{"", "   ", "@#!", strings.Repeat("x", 201)} {
+		_, err := s.Search(ctx, q, 10)
+		wantCode(t, err, domain.CodeInvalidArgument)
+	}
+}
@@ -0,0 +1,21 @@
+package tracing
+
+import "testing"
+
+func TestQueryName(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{sql: "-- name: GetUserByID :one\nSELECT id FROM users WHERE id = $1", want: "GetUserByID"},
+		{sql: "\n  -- name: ListUsers :many\nSELECT 1", want: "ListUsers"},
+		{sql: "select version, dirty from schema_migrations", want: "SELECT"},
+		{sql: "begin", want: "BEGIN"},
+		{sql: "", want: "query"},
+	}
+	for _, tc := range tests {
+		if got := queryName(tc.sql); got != tc.want {
+			t.Errorf("queryName(%q) = %q, want %q", tc.sql, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tfenng/scaffold/internal/config"
+)
+
+func TestSetup(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		cfg     config.Tracing
+		wantSDK bool
+		wantErr string
+	}{
+		{name: "none", cfg: config.Tracing{Exporter: "none"}},
+		{name: "stdout", cfg: config.Tracing{Exporter: "stdout", File: filepath.Join(dir, "spans.json"), SampleRatio: 1}, wantSDK: true},
+		{name: "otlp", cfg: config.Tracing{Exporter: "otlp", OTLPEndpoint: "localhost:4318", OTLPInsecure: true, SampleRatio: 1}, wantSDK: true},
+		{name: "unknown exporter", cfg: config.Tracing{Exporter: "jaeger"}, wantErr: `unknown trace exporter "jaeger"`},
+		{name: "empty exporter", cfg: config.Tracing{}, wantErr: "unknown trace exporter"},
+		{name: "unwritable file", cfg: config.Tracing{Exporter: "stdout", File: filepath.Join(dir, "missing", "spans.json")}, wantErr: "open trace file"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			prev := otel.GetTracerProvider()
+			t.Cleanup(func() { restoreProvider(prev) })
+
+			shutdown, err := Setup(context.Background(), tc.cfg)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("got %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, sdk := otel.GetTracerProvider().(*sdktrace.TracerProvider); sdk != tc.wantSDK {
+				t.Fatalf("SDK provider installed = %v, want %v", sdk, tc.wantSDK)
+			}
+			if err := shutdown(context.Background()); err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+		})
+	}
+}
+
+func TestSetupStdoutWritesSpans(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	t.Cleanup(func() { restoreProvider(prev) })
+
+	file := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), config.Tracing{Exporter: "stdout", File: file, ServiceName: "scaffold-test", SampleRatio: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := Tracer().Start(context.Background(), "UserService.Get")
+	span.End()
+	// Shutdown flushes the batcher and closes the file.
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"UserService.Get"`) || !strings.Contains(string(b), "scaffold-test") {
+		t.Fatalf("span not written: %s", b)
+	}
+}
+
+// restoreProvider puts back the global provider a test replaced.
+func restoreProvider(prev trace.TracerProvider) {
+	if otel.GetTracerProvider() != prev {
+		otel.SetTracerProvider(prev)
+	}
+}
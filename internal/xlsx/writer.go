@@ -0,0 +1,153 @@
+// Package xlsx streams a single-sheet Office Open XML workbook. Rows are
+// written straight into the zip archive as they come, so a sheet of any
+// length is produced in constant memory. Cells hold inline strings or
+// numbers only; there are no styles, formulas or shared strings.
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of an .xlsx file.
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+const contentTypesXML = xmlHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`</Types>`
+
+const rootRelsXML = xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`</Relationships>`
+
+const workbookRelsXML = xmlHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+	`</Relationships>`
+
+const workbookXML = xmlHeader + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" ` +
+	`xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+	`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const (
+	sheetStart = xmlHeader + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+	sheetEnd   = `</sheetData></worksheet>`
+)
+
+// Writer writes one worksheet. Its methods are not safe for concurrent use.
+type Writer struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	buf   strings.Builder
+	err   error
+}
+
+// NewWriter starts a workbook whose only sheet is named sheet: 1-31
+// characters, none of []:*?/\.
+func NewWriter(w io.Writer, sheet string) (*Writer, error) {
+	if sheet == "" || len([]rune(sheet)) > 31 || strings.ContainsAny(sheet, `[]:*?/\`) {
+		return nil, fmt.Errorf("xlsx: invalid sheet name %q", sheet)
+	}
+	var name strings.Builder
+	_ = xml.EscapeText(&name, []byte(sheet))
+
+	zw := zip.NewWriter(w)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", contentTypesXML},
+		{"_rels/.rels", rootRelsXML},
+		{"xl/workbook.xml", fmt.Sprintf(workbookXML, name.String())},
+		{"xl/_rels/workbook.xml.rels", workbookRelsXML},
+	}
+	for _, p := range parts {
+		f, err := zw.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, p.body); err != nil {
+			return nil, err
+		}
+	}
+	// The sheet is the last part, so it can grow until Close.
+	sheetW, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheetW, sheetStart); err != nil {
+		return nil, err
+	}
+	return &Writer{zw: zw, sheet: sheetW}, nil
+}
+
+// WriteRow appends a row. A cell is a string, an integer, a float64, or nil
+// for an empty cell.
+func (w *Writer) WriteRow(cells ...any) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.buf.Reset()
+	w.buf.WriteString("<row>")
+	for _, v := range cells {
+		switch v := v.(type) {
+		case nil:
+			w.buf.WriteString("<c/>")
+		case string:
+			w.buf.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+			// Characters XML cannot hold become U+FFFD.
+			_ = xml.EscapeText(&w.buf, []byte(v))
+			w.buf.WriteString("</t></is></c>")
+		case int:
+			w.number(strconv.Itoa(v))
+		case int32:
+			w.number(strconv.FormatInt(int64(v), 10))
+		case int64:
+			w.number(strconv.FormatInt(v, 10))
+		case float64:
+			w.number(strconv.FormatFloat(v, 'g', -1, 64))
+		default:
+			w.err = fmt.Errorf("xlsx: unsupported cell type %T", v)
+			return w.err
+		}
+	}
+	w.buf.WriteString("</row>")
+	_, w.err = io.WriteString(w.sheet, w.buf.String())
+	return w.err
+}
+
+func (w *Writer) number(s string) {
+	w.buf.WriteString("<c><v>")
+	w.buf.WriteString(s)
+	w.buf.WriteString("</v></c>")
+}
+
+// Flush pushes what the archive has buffered to the underlying writer. Rows
+// still held by the compressor only follow once more data or Close arrives.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.zw.Flush()
+	return w.err
+}
+
+// Close ends the sheet and the archive. It does not close the underlying
+// writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := io.WriteString(w.sheet, sheetEnd); err != nil {
+		w.err = err
+		return err
+	}
+	w.err = errors.New("xlsx: writer is closed")
+	return w.zw.Close()
+}
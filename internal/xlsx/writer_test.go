@@ -0,0 +1,87 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	w, err := NewWriter(&out, "Users & co")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow("id", "name", "birth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow(int64(7), "  <Ann & \"Bob\">\x01", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow("late"); err == nil {
+		t.Fatal("WriteRow after Close should fail")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf("not a zip archive: %v", err)
+	}
+	parts := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = b
+		var doc struct{ XMLName xml.Name }
+		if err := xml.Unmarshal(b, &doc); err != nil {
+			t.Fatalf("%s is not well-formed: %v", f.Name, err)
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Fatalf("missing part %s", name)
+		}
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatal(err)
+	}
+	if len(sheet.Rows) != 2 || len(sheet.Rows[1].Cells) != 3 {
+		t.Fatalf("unexpected sheet: %+v", sheet)
+	}
+	c := sheet.Rows[1].Cells
+	if c[0].Type != "" || c[0].Value != "7" {
+		t.Fatalf("want a number cell, got %+v", c[0])
+	}
+	if c[1].Type != "inlineStr" || c[1].Inline != "  <Ann & \"Bob\">\ufffd" {
+		t.Fatalf("want the escaped string, got %+v", c[1])
+	}
+	if c[2].Value != "" || c[2].Inline != "" {
+		t.Fatalf("want an empty cell, got %+v", c[2])
+	}
+}
+
+func TestNewWriterRejectsSheetName(t *testing.T) {
+	for _, name := range []string{"", "a/b", "this sheet name is far too long for excel"} {
+		if _, err := NewWriter(io.Discard, name); err == nil {
+			t.Errorf("NewWriter(%q) should fail", name)
+		}
+	}
+}
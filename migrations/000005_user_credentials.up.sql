@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS user_credentials (
+  user_id BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+  password_hash TEXT NOT NULL,
+  roles TEXT[] NOT NULL DEFAULT '{}',
+  password_changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Refresh tokens are opaque; only their SHA-256 is stored. Every rotation
+-- stays in the family of the login that started it, so presenting a token
+-- that was already rotated revokes the whole family.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+  family_id TEXT NOT NULL,
+  token_hash TEXT NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  revoked_at TIMESTAMPTZ,
+  replaced_by BIGINT REFERENCES refresh_tokens (id) ON DELETE SET NULL,
+  CONSTRAINT refresh_tokens_token_hash_unique UNIQUE (token_hash)
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
@@ -0,0 +1,15 @@
+-- API keys look like sk_<prefix>_<secret>. The prefix is stored in clear for
+-- lookup; only the SHA-256 of the whole key is kept.
+CREATE TABLE IF NOT EXISTS api_keys (
+  id BIGSERIAL PRIMARY KEY,
+  name TEXT NOT NULL,
+  prefix TEXT NOT NULL,
+  key_hash TEXT NOT NULL,
+  scopes TEXT[] NOT NULL,
+  created_by TEXT NOT NULL,
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CONSTRAINT api_keys_prefix_unique UNIQUE (prefix)
+);
@@ -0,0 +1,13 @@
+-- No foreign key on target_id: the trail must outlive the user it describes.
+CREATE TABLE IF NOT EXISTS audit_events (
+  id BIGSERIAL PRIMARY KEY,
+  actor TEXT NOT NULL,
+  action TEXT NOT NULL,
+  target_id BIGINT NOT NULL,
+  target_uid TEXT NOT NULL,
+  changes JSONB NOT NULL,
+  request_id TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target_id, created_at DESC, id DESC);
@@ -0,0 +1,14 @@
+-- Soft-deleted rows may collide with live ones once the old constraints are
+-- back, so they are removed for good.
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS users_email_unique_live;
+DROP INDEX IF EXISTS users_name_unique_live;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_unique_not_null
+ON users (email)
+WHERE email IS NOT NULL;
+ALTER TABLE users ADD CONSTRAINT users_name_unique UNIQUE (name);
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,18 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+-- Names and emails are freed when a user is deleted; uid keeps its global
+-- unique constraint so an identifier is never handed to a different person.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_name_unique;
+DROP INDEX IF EXISTS users_email_unique_not_null;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_name_unique_live
+ON users (name)
+WHERE deleted_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_unique_live
+ON users (email)
+WHERE email IS NOT NULL AND deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx
+ON users (deleted_at)
+WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,2 @@
+-- version is bumped by every update and backs the ETag of a user.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
@@ -0,0 +1,14 @@
+-- Fallback store for Idempotency-Key when Redis is unavailable. A row with
+-- a NULL status_code is a request still in flight; expires_at doubles as its
+-- lock timeout.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  key TEXT PRIMARY KEY,
+  request_hash TEXT NOT NULL,
+  status_code INTEGER,
+  response_header JSONB,
+  response_body BYTEA,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
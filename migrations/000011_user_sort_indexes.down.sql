@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_users_updated_at_id;
+DROP INDEX IF EXISTS idx_users_birth_id;
+DROP INDEX IF EXISTS idx_users_company_id;
+DROP INDEX IF EXISTS idx_users_name_id;
@@ -0,0 +1,7 @@
+-- Back the sortable columns of GET /users. id is the tie-breaker of every
+-- sort, and the list hides deleted users unless asked, so the indexes only
+-- cover live rows; uid is already covered by users_uid_unique.
+CREATE INDEX IF NOT EXISTS idx_users_name_id ON users (name, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_users_company_id ON users (company, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_users_birth_id ON users (birth, id) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_users_updated_at_id ON users (updated_at DESC, id DESC) WHERE deleted_at IS NULL;
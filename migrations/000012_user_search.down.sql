@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_users_name_trgm;
+DROP INDEX IF EXISTS idx_users_search_text_trgm;
+DROP INDEX IF EXISTS idx_users_search_vector;
+
+DROP FUNCTION IF EXISTS user_search_text(TEXT, TEXT, TEXT, TEXT, TEXT);
+DROP FUNCTION IF EXISTS user_search_vector(TEXT, TEXT, TEXT, TEXT, TEXT);
+
+-- pg_trgm is left installed; other objects may depend on it.
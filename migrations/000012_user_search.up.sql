@@ -0,0 +1,33 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Search runs on expression indexes over two immutable functions rather than
+-- on STORED generated columns. Generated columns would put a tsvector and a
+-- helper string on every users row, and sqlc would add them to the User model
+-- that the service and cache layers pass around. SearchUsers calls the same
+-- functions with the same arguments, so the planner matches the indexes.
+
+-- user_search_vector ranks whole-word and prefix matches, weighted name/uid
+-- over used_name/email over company. The 'simple' configuration keeps names
+-- as written instead of stemming them as English words.
+CREATE OR REPLACE FUNCTION user_search_vector(name TEXT, uid TEXT, used_name TEXT, email TEXT, company TEXT)
+RETURNS TSVECTOR LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+  SELECT setweight(to_tsvector('simple', name), 'A') ||
+    setweight(to_tsvector('simple', uid), 'A') ||
+    setweight(to_tsvector('simple', coalesce(used_name, '')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(email, '')), 'B') ||
+    setweight(to_tsvector('simple', coalesce(company, '')), 'C')
+$$;
+
+-- user_search_text backs typo-tolerant trigram matching over the same fields.
+CREATE OR REPLACE FUNCTION user_search_text(name TEXT, uid TEXT, used_name TEXT, email TEXT, company TEXT)
+RETURNS TEXT LANGUAGE sql IMMUTABLE PARALLEL SAFE AS $$
+  SELECT lower(name || ' ' || uid || ' ' || coalesce(used_name, '') || ' ' || coalesce(email, '') || ' ' || coalesce(company, ''))
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_users_search_vector ON users
+  USING gin (user_search_vector(name, uid, used_name, email, company));
+CREATE INDEX IF NOT EXISTS idx_users_search_text_trgm ON users
+  USING gin (user_search_text(name, uid, used_name, email, company) gin_trgm_ops);
+
+-- Lets name[like] / name_like (ILIKE '%x%') use an index too.
+CREATE INDEX IF NOT EXISTS idx_users_name_trgm ON users USING gin (name gin_trgm_ops);
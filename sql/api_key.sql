@@ -0,0 +1,25 @@
+-- name: CreateApiKey :one
+INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, name, prefix, key_hash, scopes, created_by, expires_at, last_used_at, revoked_at, created_at;
+
+-- name: GetApiKeyByPrefix :one
+SELECT id, name, prefix, key_hash, scopes, created_by, expires_at, last_used_at, revoked_at, created_at
+FROM api_keys
+WHERE prefix = $1;
+
+-- name: ListApiKeys :many
+SELECT id, name, prefix, key_hash, scopes, created_by, expires_at, last_used_at, revoked_at, created_at
+FROM api_keys
+ORDER BY created_at DESC, id DESC;
+
+-- name: RevokeApiKey :one
+UPDATE api_keys
+SET revoked_at = now()
+WHERE id = $1 AND revoked_at IS NULL
+RETURNING prefix;
+
+-- name: TouchApiKey :exec
+UPDATE api_keys
+SET last_used_at = now()
+WHERE id = $1;
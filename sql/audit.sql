@@ -0,0 +1,19 @@
+-- name: CreateAuditEvent :exec
+INSERT INTO audit_events (actor, action, target_id, target_uid, changes, request_id)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: CreateAuditEvents :copyfrom
+INSERT INTO audit_events (actor, action, target_id, target_uid, changes, request_id)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: ListAuditEventsByTarget :many
+SELECT id, actor, action, target_id, target_uid, changes, request_id, created_at
+FROM audit_events
+WHERE target_id = $1
+ORDER BY created_at DESC, id DESC
+LIMIT $2 OFFSET $3;
+
+-- name: CountAuditEventsByTarget :one
+SELECT COUNT(1)
+FROM audit_events
+WHERE target_id = $1;
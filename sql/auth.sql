@@ -0,0 +1,44 @@
+-- name: GetCredential :one
+SELECT user_id, password_hash, roles, password_changed_at, created_at
+FROM user_credentials
+WHERE user_id = $1;
+
+-- name: CreateCredential :exec
+INSERT INTO user_credentials (user_id, password_hash, roles)
+VALUES ($1, $2, $3);
+
+-- name: UpdatePasswordHash :exec
+UPDATE user_credentials
+SET password_hash = $2, password_changed_at = now()
+WHERE user_id = $1;
+
+-- name: RehashPassword :exec
+UPDATE user_credentials
+SET password_hash = $2
+WHERE user_id = $1;
+
+-- name: CreateRefreshToken :one
+INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING id, user_id, family_id, token_hash, expires_at, created_at, revoked_at, replaced_by;
+
+-- name: GetRefreshTokenForUpdate :one
+SELECT id, user_id, family_id, token_hash, expires_at, created_at, revoked_at, replaced_by
+FROM refresh_tokens
+WHERE token_hash = $1
+FOR UPDATE;
+
+-- name: RotateRefreshToken :exec
+UPDATE refresh_tokens
+SET revoked_at = now(), replaced_by = $2
+WHERE id = $1;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET revoked_at = now()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -0,0 +1,29 @@
+-- name: ClaimIdempotencyKey :execrows
+INSERT INTO idempotency_keys (key, request_hash, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (key) DO UPDATE
+SET request_hash = EXCLUDED.request_hash,
+    status_code = NULL,
+    response_header = NULL,
+    response_body = NULL,
+    expires_at = EXCLUDED.expires_at,
+    created_at = now()
+WHERE idempotency_keys.expires_at < now();
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET status_code = $2, response_header = $3, response_body = $4, expires_at = $5
+WHERE key = $1;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < $1;
+
+-- name: GetIdempotencyKey :one
+SELECT key, request_hash, status_code, response_header, response_body, expires_at, created_at
+FROM idempotency_keys
+WHERE key = $1;
+
+-- name: ReleaseIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE key = $1 AND status_code IS NULL;
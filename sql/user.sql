@@ -1,42 +1,105 @@
+-- Users are soft-deleted: deleted_at is set and the row stays until the
+-- purge job removes it. Reads exclude deleted rows unless asked otherwise.
+
 -- name: GetUserByID :one
-SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
+FROM users
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetUserByIDForUpdate :one
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
 FROM users
-WHERE id = $1;
+WHERE id = $1 AND deleted_at IS NULL
+FOR UPDATE;
 
 -- name: GetUserByUID :one
-SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
 FROM users
-WHERE uid = $1;
+WHERE uid = $1 AND deleted_at IS NULL;
 
 -- name: GetUserByEmail :one
-SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version
 FROM users
-WHERE email = $1;
+WHERE email = $1 AND deleted_at IS NULL;
 
 -- name: CreateUser :one
 INSERT INTO users (uid, name, email, used_name, company, birth)
 VALUES ($1, $2, sqlc.narg('email')::text, $3, $4, $5)
-RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at;
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version;
 
--- name: ListUsers :many
-SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at
-FROM users
-WHERE (sqlc.narg('email')::text IS NULL OR email = sqlc.narg('email')::text)
-  AND (sqlc.narg('name_like')::text IS NULL OR name ILIKE ('%' || sqlc.narg('name_like')::text || '%'))
-ORDER BY created_at DESC, id DESC
-LIMIT $1 OFFSET $2;
+-- name: CreateUsers :many
+INSERT INTO users (uid, name, email, used_name, company, birth)
+SELECT uid, name, NULLIF(email, ''), NULLIF(used_name, ''), NULLIF(company, ''), NULLIF(birth, '')::date
+FROM unnest(
+  sqlc.arg('uids')::text[], sqlc.arg('names')::text[], sqlc.arg('emails')::text[],
+  sqlc.arg('used_names')::text[], sqlc.arg('companies')::text[], sqlc.arg('births')::text[]
+) AS t(uid, name, email, used_name, company, birth)
+ON CONFLICT DO NOTHING
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version;
 
--- name: CountUsers :one
-SELECT COUNT(1)
+-- name: ListTakenUserKeys :many
+SELECT uid, name, email, deleted_at IS NULL AS live
 FROM users
-WHERE (sqlc.narg('email')::text IS NULL OR email = sqlc.narg('email')::text)
-  AND (sqlc.narg('name_like')::text IS NULL OR name ILIKE ('%' || sqlc.narg('name_like')::text || '%'));
+WHERE uid = ANY(sqlc.arg('uids')::text[])
+  OR (deleted_at IS NULL AND (name = ANY(sqlc.arg('names')::text[]) OR email = ANY(sqlc.arg('emails')::text[])));
 
 -- name: UpdateUser :one
 UPDATE users
-SET name = $2, email = sqlc.narg('email')::text, used_name = $3, company = $4, birth = $5, updated_at = now()
-WHERE id = $1
-RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at;
+SET name = $2, email = sqlc.narg('email')::text, used_name = $3, company = $4, birth = $5, updated_at = now(), version = version + 1
+WHERE id = $1 AND deleted_at IS NULL AND version = sqlc.arg('version')
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version;
+
+-- name: PatchUser :one
+UPDATE users
+SET name = CASE WHEN sqlc.arg('set_name')::bool THEN sqlc.arg('name')::text ELSE name END,
+    email = CASE WHEN sqlc.arg('set_email')::bool THEN sqlc.narg('email')::text ELSE email END,
+    used_name = CASE WHEN sqlc.arg('set_used_name')::bool THEN sqlc.narg('used_name')::text ELSE used_name END,
+    company = CASE WHEN sqlc.arg('set_company')::bool THEN sqlc.narg('company')::text ELSE company END,
+    birth = CASE WHEN sqlc.arg('set_birth')::bool THEN sqlc.narg('birth')::date ELSE birth END,
+    updated_at = now(),
+    version = version + 1
+WHERE id = $1 AND deleted_at IS NULL AND version = sqlc.arg('version')
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version;
 
--- name: DeleteUser :exec
-DELETE FROM users WHERE id = $1;
+-- name: SoftDeleteUser :one
+UPDATE users
+SET deleted_at = now(), updated_at = now(), version = version + 1
+WHERE id = $1 AND deleted_at IS NULL
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version;
+
+-- name: RestoreUser :one
+UPDATE users u
+SET deleted_at = NULL, updated_at = now(), version = u.version + 1
+FROM users old
+WHERE u.id = $1 AND old.id = u.id AND u.deleted_at IS NOT NULL
+RETURNING u.id, u.uid, u.email, u.name, u.used_name, u.company, u.birth, u.created_at, u.updated_at, u.deleted_at, u.version, old.deleted_at AS previous_deleted_at;
+
+-- name: PurgeDeletedUsers :many
+DELETE FROM users
+WHERE id IN (
+  SELECT id FROM users
+  WHERE deleted_at < $1
+  ORDER BY deleted_at
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version;
+
+-- name: SetWordSimilarityThreshold :exec
+SELECT set_config('pg_trgm.word_similarity_threshold', sqlc.arg('threshold')::text, true);
+
+-- The user_search_* calls must match idx_users_search_vector and
+-- idx_users_search_text_trgm (000012_user_search) exactly for the indexes
+-- to be used.
+-- name: SearchUsers :many
+SELECT id, uid, email, name, used_name, company, birth, created_at, updated_at, deleted_at, version,
+  (ts_rank_cd(user_search_vector(name, uid, used_name, email, company), to_tsquery('simple', sqlc.arg('tsquery')::text), 32) +
+    word_similarity(sqlc.arg('query')::text, user_search_text(name, uid, used_name, email, company)))::float8 AS rank,
+  ts_headline('simple', concat_ws(' · ', name, used_name, company, uid, email), to_tsquery('simple', sqlc.arg('tsquery')::text),
+    'HighlightAll=true, StartSel=' || chr(57344) || ', StopSel=' || chr(57345)) AS snippet
+FROM users
+WHERE deleted_at IS NULL
+  AND (user_search_vector(name, uid, used_name, email, company) @@ to_tsquery('simple', sqlc.arg('tsquery')::text)
+    OR sqlc.arg('query')::text <% user_search_text(name, uid, used_name, email, company))
+ORDER BY rank DESC, id DESC
+LIMIT $1;
@@ -29,7 +29,7 @@ export default function UsersPage() {
   });
 
   const deleteMutation = useMutation({
-    mutationFn: (id: number) => userApi.delete(id),
+    mutationFn: (user: User) => userApi.delete(user.id, user.version),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ["users"] });
       toast.success("User deleted");
@@ -113,7 +113,7 @@ export default function UsersPage() {
                         size="icon"
                         onClick={() => {
                           if (confirm("Are you sure?")) {
-                            deleteMutation.mutate(user.id);
+                            deleteMutation.mutate(user);
                           }
                         }}
                       >
@@ -11,6 +11,7 @@ import { Button } from "@/components/ui/button";
 import { Input } from "@/components/ui/input";
 import { Label } from "@/components/ui/label";
 import { toast } from "sonner";
+import { isAxiosError } from "axios";
 
 interface UserDialogProps {
   open: boolean;
@@ -102,14 +103,20 @@ export function UserDialog({ open, onOpenChange, userId }: UserDialogProps) {
   });
 
   const updateMutation = useMutation({
-    mutationFn: (data: UpdateUserInput) => userApi.update(userId!, normalizeUpdateInput(data)),
+    mutationFn: (data: UpdateUserInput) =>
+      userApi.update(userId!, user!.data.version, normalizeUpdateInput(data)),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ["users"] });
       queryClient.invalidateQueries({ queryKey: ["user", userId] });
       toast.success("User updated successfully");
       onOpenChange(false);
     },
-    onError: () => {
+    onError: (err) => {
+      if (isAxiosError(err) && err.response?.status === 412) {
+        queryClient.invalidateQueries({ queryKey: ["user", userId] });
+        toast.error("This user was changed by someone else; the form has been reloaded");
+        return;
+      }
       toast.error("Failed to update user");
     },
   });
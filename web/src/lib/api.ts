@@ -1,10 +1,23 @@
 import axios from "axios";
-import type { User, Page, UserListFilter } from "@/types";
+import type { User, Page, UserListFilter, UserSearchHit, UserImportReport } from "@/types";
 
 const api = axios.create({
   baseURL: process.env.NEXT_PUBLIC_API_URL || "http://localhost:8080",
 });
 
+let accessToken: string | null = null;
+
+export function setAccessToken(token: string | null) {
+  accessToken = token;
+}
+
+api.interceptors.request.use((config) => {
+  if (accessToken) {
+    config.headers.Authorization = `Bearer ${accessToken}`;
+  }
+  return config;
+});
+
 type CreateUserPayload = {
   uid: string;
   email?: string;
@@ -22,17 +35,48 @@ type UpdateUserPayload = {
   birth?: string;
 };
 
+// ifMatch sends the version the caller last saw; the server answers 412 when
+// the user has changed since.
+const ifMatch = (version: number) => ({ headers: { "If-Match": `"${version}"` } });
+
 export const userApi = {
   getById: (id: number) => api.get<User>(`/users/${id}`),
 
   list: (filter: UserListFilter) =>
     api.get<Page<User>>("/users", { params: filter }),
 
+  search: (q: string, limit?: number) =>
+    api.get<{ items: UserSearchHit[] }>("/users/search", { params: { q, limit } }),
+
   create: (data: CreateUserPayload) => api.post<User>("/users", data),
 
-  update: (id: number, data: UpdateUserPayload) => api.put<User>(`/users/${id}`, data),
+  // file is CSV with a header row, or NDJSON. An all-or-nothing import with
+  // failed rows answers 422 with the report as its body.
+  import: (file: File, mode: UserImportReport["mode"] = "all_or_nothing") =>
+    api.post<UserImportReport>("/users:import", file, {
+      params: { mode },
+      headers: { "Content-Type": file.name.endsWith(".csv") ? "text/csv" : "application/x-ndjson" },
+    }),
+
+  // Downloads every user matching filter in one file; page and page_size
+  // are ignored.
+  export: (filter: UserListFilter, format: "csv" | "ndjson" | "xlsx" = "csv", columns?: string[]) =>
+    api.get<Blob>("/users:export", {
+      params: { ...filter, format, columns: columns?.join(",") },
+      responseType: "blob",
+    }),
+
+  update: (id: number, version: number, data: UpdateUserPayload) =>
+    api.put<User>(`/users/${id}`, data, ifMatch(version)),
+
+  patch: (id: number, version: number, data: Partial<{ [K in keyof UpdateUserPayload]: UpdateUserPayload[K] | null }>) =>
+    api.patch<User>(`/users/${id}`, data, {
+      headers: { ...ifMatch(version).headers, "Content-Type": "application/merge-patch+json" },
+    }),
+
+  delete: (id: number, version: number) => api.delete<void>(`/users/${id}`, ifMatch(version)),
 
-  delete: (id: number) => api.delete<void>(`/users/${id}`),
+  restore: (id: number) => api.post<User>(`/users/${id}/restore`),
 };
 
 export default api;
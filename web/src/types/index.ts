@@ -8,6 +8,8 @@ export interface User {
   birth: string | null;
   created_at: string;
   updated_at: string;
+  deleted_at: string | null;
+  version: number;
 }
 
 export interface Page<T> {
@@ -18,14 +20,60 @@ export interface Page<T> {
   total_pages: number;
 }
 
+export interface AuditEvent {
+  id: number;
+  actor: string;
+  action: "user.create" | "user.update" | "user.delete" | "user.restore" | "user.purge";
+  user_id: number;
+  user_uid: string;
+  changes: Record<string, { before: string | null; after: string | null }>;
+  request_id: string | null;
+  created_at: string;
+}
+
+export interface UserSearchHit extends User {
+  rank: number;
+  // HTML with matches in <mark>; the user's fields are already escaped.
+  snippet: string;
+}
+
+export interface UserImportReport {
+  mode: "all_or_nothing" | "best_effort";
+  // false when an all-or-nothing import had failed rows and created nothing.
+  committed: boolean;
+  total: number;
+  created: number;
+  failed: number;
+  rows: {
+    line: number;
+    uid?: string;
+    status: "created" | "failed" | "rolled_back";
+    id?: number;
+    errors?: { field?: string; code: string; message: string }[];
+  }[];
+}
+
+// Filter expressions such as "birth[gte]" or "or[0][company][in]" can be
+// added as extra keys; see web/api.md.
 export interface UserListFilter {
   email?: string;
   name_like?: string;
+  include_deleted?: boolean;
+  // e.g. "-updated_at,name"; see web/api.md for the sortable fields.
+  sort?: string;
   page?: number;
   page_size?: number;
+  [filter: `${string}[${string}]`]: string | undefined;
+}
+
+export interface FieldViolation {
+  field: string;
+  rule: string;
+  message: string;
 }
 
 export interface ApiError {
   code: string;
   message: string;
+  errors?: FieldViolation[];
 }